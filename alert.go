@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ThresholdAlert = "THRESHOLD"
+)
+
+// Alert is a finding which is based on several Connections together, rather than on a single one
+type Alert struct {
+	Type     string `json:"type"`
+	RuleID   string `json:"rule_id,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+	// Key describes what the alert was grouped by, e.g. `source 192.0.0.3`
+	Key       string `json:"key"`
+	Count     int    `json:"count"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+	// Stats holds detector specific statistics, which help analysts triage the Alert
+	Stats map[string]float64 `json:"stats,omitempty"`
+	// Connections are the Connections which contributed to the Alert
+	Connections []Connection `json:"connections"`
+}
+
+// String describes the Alert in a single line, for the report
+func (a Alert) String() string {
+	description := fmt.Sprintf("%s alert on %s: %d connections between %s and %s", a.Type, a.Key, a.Count, a.FirstSeen, a.LastSeen)
+	if a.RuleName != "" {
+		description = fmt.Sprintf("%s alert for rule '%s' on %s: %d connections between %s and %s", a.Type, a.RuleName, a.Key, a.Count, a.FirstSeen, a.LastSeen)
+	}
+
+	if len(a.Stats) == 0 {
+		return description
+	}
+	// Maps have a random iteration order, so the stats are sorted to keep the output stable between runs
+	var stats []string
+	for key, value := range a.Stats {
+		stats = append(stats, fmt.Sprintf("%s=%.3f", key, value))
+	}
+	sort.Strings(stats)
+	return fmt.Sprintf("%s (%s)", description, strings.Join(stats, ", "))
+}
+
+// AlertsWriter writes Alerts to a `.json` file.
+// Unlike the suspicious Connections, each Alert has a nested list of Connections, which doesn't fit a CSV file.
+type AlertsWriter struct{}
+
+// Write an Alert slice to the output path
+func (a AlertsWriter) Write(alerts []Alert, path string) error {
+	fmt.Fprintf(statusOutput, "Writing alerts file to %s\n", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating directory %s", filepath.Dir(path))
+	}
+
+	content, err := json.MarshalIndent(alerts, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling alerts")
+	}
+
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrapf(err, "writing file %s", path)
+	}
+
+	log.Println("Successfully wrote file.")
+	return nil
+}
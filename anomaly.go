@@ -0,0 +1,406 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var AnomalyAlert = "ANOMALY"
+
+// The features of a host-window, which are scored against the host's profile. The protocol mix is kept as a feature
+// for each protocol, e.g. `protocol_TCP`, holding the fraction of the window's Connections which used it.
+var (
+	ConnectionsFeature  = "connections"
+	DestinationsFeature = "destinations"
+	PortEntropyFeature  = "port_entropy"
+	protocolFeature     = "protocol_"
+)
+
+// featureFloor is added to the standard deviation of a feature, so that a feature which never varied while training
+// (or was never seen) doesn't score infinitely on the smallest change
+func featureFloor(feature string) float64 {
+	switch feature {
+	case ConnectionsFeature, DestinationsFeature:
+		return 1
+	case PortEntropyFeature:
+		return 0.1
+	default:
+		return 0.05
+	}
+}
+
+// minProfileWindows is the amount of windows a host must have been trained on for its own profile to be used, rather
+// than the global one
+var minProfileWindows = 3
+
+// Profiles are the behaviour profiles of the hosts, trained on the windows of a stream of Connections
+type Profiles struct {
+	Window time.Duration
+	Hosts  map[string]Profile
+	// Global is trained on the windows of all hosts, and is used for the hosts without a profile of their own
+	Global Profile
+}
+
+// Profile holds the statistics of each feature, over the windows a host was active in
+type Profile struct {
+	Windows  int                     `json:"windows"`
+	Features map[string]FeatureStats `json:"features"`
+}
+
+// FeatureStats are the mean and standard deviation of a single feature
+type FeatureStats struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// hostWindow accumulates the Connections of a single source host within a single window
+type hostWindow struct {
+	start        time.Time
+	count        int
+	destinations map[string]interface{}
+	ports        map[int]int
+	protocols    map[string]int
+	first        Connection
+	last         Connection
+	sample       []Connection
+}
+
+func newHostWindow(start time.Time) *hostWindow {
+	return &hostWindow{start: start, destinations: map[string]interface{}{}, ports: map[int]int{}, protocols: map[string]int{}}
+}
+
+func (w *hostWindow) add(conn Connection) {
+	if w.count == 0 {
+		w.first = conn
+	}
+	w.count++
+	w.last = conn
+	w.destinations[conn.Destination.String()] = nil
+	w.ports[conn.DestinationPort]++
+	w.protocols[conn.Protocol]++
+	if len(w.sample) < beaconSampleSize {
+		w.sample = append(w.sample, conn)
+	}
+}
+
+// features returns the value of each feature of the window
+func (w *hostWindow) features() map[string]float64 {
+	features := map[string]float64{
+		ConnectionsFeature:  float64(w.count),
+		DestinationsFeature: float64(len(w.destinations)),
+	}
+
+	// The counts are sorted, so that the floating point sum is the same between runs
+	var counts []int
+	for _, count := range w.ports {
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(w.count)
+		entropy -= p * math.Log2(p)
+	}
+	features[PortEntropyFeature] = entropy
+
+	for protocol, count := range w.protocols {
+		features[protocolFeature+protocol] = float64(count) / float64(w.count)
+	}
+	return features
+}
+
+// TrainProfiles builds the profile of each source host from its windows, and a global profile from the windows of all
+// hosts. Connections without a valid timestamp are skipped.
+func TrainProfiles(conns []Connection, window time.Duration) Profiles {
+	windows := map[string]map[time.Time]*hostWindow{}
+	for _, conn := range conns {
+		ts, err := conn.Time()
+		if err != nil {
+			continue
+		}
+
+		host := conn.Source.String()
+		if windows[host] == nil {
+			windows[host] = map[time.Time]*hostWindow{}
+		}
+		start := ts.Truncate(window)
+		hw, ok := windows[host][start]
+		if !ok {
+			hw = newHostWindow(start)
+			windows[host][start] = hw
+		}
+		hw.add(conn)
+	}
+
+	// The hosts and windows are sorted, so that the floating point sums are the same between runs
+	var hosts []string
+	for host := range windows {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	profiles := Profiles{Window: window, Hosts: map[string]Profile{}}
+	var all []map[string]float64
+	for _, host := range hosts {
+		var starts []time.Time
+		for start := range windows[host] {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i].Before(starts[j])
+		})
+
+		var features []map[string]float64
+		for _, start := range starts {
+			features = append(features, windows[host][start].features())
+		}
+		profiles.Hosts[host] = newProfile(features)
+		all = append(all, features...)
+	}
+	profiles.Global = newProfile(all)
+	return profiles
+}
+
+// newProfile computes the statistics of each feature over the windows. A feature which is missing from a window (i.e.
+// a protocol which wasn't used in it) counts as 0.
+func newProfile(windows []map[string]float64) Profile {
+	profile := Profile{Windows: len(windows), Features: map[string]FeatureStats{}}
+	if len(windows) == 0 {
+		return profile
+	}
+
+	sums := map[string]float64{}
+	for _, features := range windows {
+		for feature, value := range features {
+			sums[feature] += value
+		}
+	}
+	for feature, sum := range sums {
+		mean := sum / float64(len(windows))
+		variance := 0.0
+		for _, features := range windows {
+			variance += math.Pow(features[feature]-mean, 2)
+		}
+		profile.Features[feature] = FeatureStats{Mean: mean, StdDev: math.Sqrt(variance / float64(len(windows)))}
+	}
+	return profile
+}
+
+// profile returns the host's own profile if it was trained on enough windows, and otherwise the global one
+func (p Profiles) profile(host string) Profile {
+	if profile, ok := p.Hosts[host]; ok && profile.Windows >= minProfileWindows {
+		return profile
+	}
+	return p.Global
+}
+
+// Score returns the anomaly score of a window's features against a profile, which is the highest z-score of any of its
+// features, together with the z-score of each feature. Only increases are anomalous, so z-scores are at least 0, and
+// features which are missing from the window (i.e. are 0) are left out.
+func (p Profile) Score(features map[string]float64) (float64, map[string]float64) {
+	scores := map[string]float64{}
+	highest := 0.0
+	for feature, value := range features {
+		stats := p.Features[feature]
+		score := math.Max(0, (value-stats.Mean)/(stats.StdDev+featureFloor(feature)))
+		scores[feature] = score
+		highest = math.Max(highest, score)
+	}
+	return highest, scores
+}
+
+// AnomalyDetector scores the windows of each source host against its profile, and flags the windows which score at
+// least the threshold
+type AnomalyDetector struct {
+	profiles  Profiles
+	threshold float64
+	windows   map[string]*hostWindow
+	current   time.Time
+	// anomalous holds the reason each flagged host-window was flagged, by host and window start
+	anomalous map[string]map[time.Time]string
+}
+
+// NewAnomalyDetector returns an AnomalyDetector which flags the host-windows scoring at least threshold against the
+// profiles. The windows are of the same length as those the profiles were trained on.
+func NewAnomalyDetector(profiles Profiles, threshold float64) *AnomalyDetector {
+	return &AnomalyDetector{
+		profiles:  profiles,
+		threshold: threshold,
+		windows:   map[string]*hostWindow{},
+		anomalous: map[string]map[time.Time]string{},
+	}
+}
+
+// Observe adds the Connection to its host-window. Once the stream moves on to a later window, the earlier host-windows
+// are scored, and an Alert is returned for each which is anomalous. Connections without a valid timestamp are ignored.
+func (a *AnomalyDetector) Observe(conn Connection) []Alert {
+	ts, err := conn.Time()
+	if err != nil {
+		return nil
+	}
+
+	start := ts.Truncate(a.profiles.Window)
+	var alerts []Alert
+	if start.After(a.current) {
+		alerts = a.closeWindows(start)
+		a.current = start
+	}
+
+	// Connections are expected in timestamp order, so a late Connection of an already scored window is ignored
+	if start.Before(a.current) {
+		return alerts
+	}
+
+	host := conn.Source.String()
+	hw, ok := a.windows[host]
+	if !ok {
+		hw = newHostWindow(start)
+		a.windows[host] = hw
+	}
+	hw.add(conn)
+	return alerts
+}
+
+// Flush scores the host-windows which are still open
+func (a *AnomalyDetector) Flush() []Alert {
+	return a.closeWindows(time.Time{})
+}
+
+// closeWindows scores and removes the host-windows which started before the given time (or all of them, for a zero
+// time), in host order
+func (a *AnomalyDetector) closeWindows(before time.Time) []Alert {
+	var hosts []string
+	for host, hw := range a.windows {
+		if before.IsZero() || hw.start.Before(before) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	var alerts []Alert
+	for _, host := range hosts {
+		hw := a.windows[host]
+		delete(a.windows, host)
+
+		features := hw.features()
+		score, scores := a.profiles.profile(host).Score(features)
+		if score < a.threshold {
+			continue
+		}
+
+		if a.anomalous[host] == nil {
+			a.anomalous[host] = map[time.Time]string{}
+		}
+		a.anomalous[host][hw.start] = describeAnomaly(score, scores, features)
+
+		stats := map[string]float64{"score": score}
+		for feature, value := range features {
+			stats[feature] = value
+			stats[feature+"_z"] = scores[feature]
+		}
+		alerts = append(alerts, Alert{
+			Type:        AnomalyAlert,
+			Key:         "source " + host,
+			Count:       hw.count,
+			FirstSeen:   hw.first.Timestamp,
+			LastSeen:    hw.last.Timestamp,
+			Stats:       stats,
+			Connections: hw.sample,
+		})
+	}
+	return alerts
+}
+
+// describeAnomaly explains a score by the features which contributed to it the most, e.g.
+// `anomaly score 6.20: connections=540 (z=6.20)`
+func describeAnomaly(score float64, scores, features map[string]float64) string {
+	var names []string
+	for feature, z := range scores {
+		if z >= score/2 && z > 0 {
+			names = append(names, feature)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if scores[names[i]] != scores[names[j]] {
+			return scores[names[i]] > scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%.3g (z=%.2f)", name, features[name], scores[name]))
+	}
+	return fmt.Sprintf("anomaly score %.2f: %s", score, strings.Join(parts, ", "))
+}
+
+// Anomalous returns the reason the Connection's host-window was flagged, and false if it wasn't
+func (a *AnomalyDetector) Anomalous(conn Connection) (string, bool) {
+	ts, err := conn.Time()
+	if err != nil {
+		return "", false
+	}
+	reason, ok := a.anomalous[conn.Source.String()][ts.Truncate(a.profiles.Window)]
+	return reason, ok
+}
+
+// ProfilesReadWriter manages Profiles, reading them from and writing them to a `.json` file
+type ProfilesReadWriter struct{}
+
+// profilesJson keeps the window as a duration string, e.g. `1h0m0s`, so the file can be read and edited by people
+type profilesJson struct {
+	Window string             `json:"window"`
+	Hosts  map[string]Profile `json:"hosts"`
+	Global Profile            `json:"global"`
+}
+
+// Read a profiles `.json` file, as written by Write
+func (p ProfilesReadWriter) Read(path string) (Profiles, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Profiles{}, errors.Wrap(err, "failed to read profiles file")
+	}
+
+	var parsed profilesJson
+	if err = json.Unmarshal(content, &parsed); err != nil {
+		return Profiles{}, errors.Wrap(err, "failed to parse profiles file")
+	}
+
+	window, err := time.ParseDuration(parsed.Window)
+	if err != nil || window <= 0 {
+		return Profiles{}, errors.Errorf("invalid profiles window %s", parsed.Window)
+	}
+	return Profiles{Window: window, Hosts: parsed.Hosts, Global: parsed.Global}, nil
+}
+
+// Write Profiles to the output path
+func (p ProfilesReadWriter) Write(profiles Profiles, path string) error {
+	fmt.Fprintf(statusOutput, "Writing profiles file to %s\n", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating directory %s", filepath.Dir(path))
+	}
+
+	content, err := json.MarshalIndent(profilesJson{Window: profiles.Window.String(), Hosts: profiles.Hosts, Global: profiles.Global}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling profiles")
+	}
+
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrapf(err, "writing file %s", path)
+	}
+
+	log.Println("Successfully wrote file.")
+	return nil
+}
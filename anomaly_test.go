@@ -0,0 +1,131 @@
+package engine_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	spec.Run(t, "AnomalyDetector", testAnomalyDetector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testAnomalyDetector(t *testing.T, when spec.G, it spec.S) {
+	// window returns count Connections from the source within the hour starting at start, spread over the destinations
+	window := func(source string, start, count, destinations int, protocol string) []engine.Connection {
+		var conns []engine.Connection
+		for i := 0; i < count; i++ {
+			conns = append(conns, engine.Connection{
+				Timestamp:       fmt.Sprintf("%d.000000", start*3600+i),
+				Source:          net.ParseIP(source),
+				SourcePort:      40000 + i,
+				Destination:     net.ParseIP(fmt.Sprintf("10.0.0.%d", 1+i%destinations)),
+				DestinationPort: 443,
+				Protocol:        protocol,
+			})
+		}
+		return conns
+	}
+
+	var training []engine.Connection
+	it.Before(func() {
+		training = nil
+		for hour := 0; hour < 4; hour++ {
+			training = append(training, window("192.168.0.1", hour, 10+hour%2, 2, "TCP")...)
+		}
+	})
+
+	when("#TrainProfiles", func() {
+		it("profiles each feature over the host's windows", func() {
+			profiles := engine.TrainProfiles(training, time.Hour)
+			assert.Equal(t, time.Hour, profiles.Window)
+
+			profile := profiles.Hosts["192.168.0.1"]
+			assert.Equal(t, 4, profile.Windows)
+			assert.Equal(t, engine.FeatureStats{Mean: 10.5, StdDev: 0.5}, profile.Features[engine.ConnectionsFeature])
+			assert.Equal(t, engine.FeatureStats{Mean: 2, StdDev: 0}, profile.Features[engine.DestinationsFeature])
+			assert.Equal(t, engine.FeatureStats{Mean: 1, StdDev: 0}, profile.Features["protocol_TCP"])
+			assert.Equal(t, 4, profiles.Global.Windows)
+		})
+	})
+
+	when("#Observe", func() {
+		it("flags the host-windows which deviate from the profile", func() {
+			detector := engine.NewAnomalyDetector(engine.TrainProfiles(training, time.Hour), 4)
+
+			var conns []engine.Connection
+			conns = append(conns, window("192.168.0.1", 10, 10, 2, "TCP")...)
+			conns = append(conns, window("192.168.0.1", 11, 12, 12, "TCP")...)
+			conns = append(conns, window("192.168.0.1", 12, 10, 2, "UDP")...)
+			result := engine.DetectAttacks(nil, conns, detector)
+
+			assert.Equal(t, 2, len(result.Alerts))
+			alert := result.Alerts[0]
+			assert.Equal(t, engine.AnomalyAlert, alert.Type)
+			assert.Equal(t, "source 192.168.0.1", alert.Key)
+			assert.Equal(t, 12, alert.Count)
+			assert.Equal(t, "39600.000000", alert.FirstSeen)
+			assert.Equal(t, "39611.000000", alert.LastSeen)
+			assert.Equal(t, 10.0, alert.Stats["score"])
+			assert.Equal(t, 10.0, alert.Stats["destinations_z"])
+			assert.Equal(t, 5, len(alert.Connections))
+			assert.Equal(t, "source 192.168.0.1", result.Alerts[1].Key)
+			assert.Equal(t, 20.0, result.Alerts[1].Stats["protocol_UDP_z"])
+
+			reason, ok := detector.Anomalous(conns[10])
+			assert.True(t, ok)
+			assert.Contains(t, reason, "anomaly score 10.00: destinations=12 (z=10.00)")
+			_, ok = detector.Anomalous(conns[0])
+			assert.False(t, ok)
+		})
+
+		it("scores hosts without enough windows against the global profile", func() {
+			detector := engine.NewAnomalyDetector(engine.TrainProfiles(training, time.Hour), 4)
+			result := engine.DetectAttacks(nil, window("192.168.0.2", 10, 30, 2, "TCP"), detector)
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, "source 192.168.0.2", result.Alerts[0].Key)
+		})
+	})
+
+	when("ProfilesReadWriter", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "profiles")
+			assert.Nil(t, err)
+		})
+
+		it.After(func() {
+			assert.Nil(t, os.RemoveAll(tmpDir))
+		})
+
+		it("reads the profiles which were written", func() {
+			path := filepath.Join(tmpDir, "profiles.json")
+			profiles := engine.TrainProfiles(training, time.Hour)
+			assert.Nil(t, engine.ProfilesReadWriter{}.Write(profiles, path))
+
+			read, err := engine.ProfilesReadWriter{}.Read(path)
+			assert.Nil(t, err)
+			assert.Equal(t, profiles, read)
+		})
+
+		it("returns an error for an invalid window", func() {
+			path := filepath.Join(tmpDir, "profiles.json")
+			assert.Nil(t, ioutil.WriteFile(path, []byte(`{"window": "forever"}`), 0644))
+
+			_, err := engine.ProfilesReadWriter{}.Read(path)
+			assert.Error(t, err)
+		})
+	})
+}
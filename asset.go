@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Asset labels the addresses within a network, e.g. `10.0.1.0/24` as `database servers`
+type Asset struct {
+	Network *net.IPNet
+	Label   string
+	// Criticality multiplies the risk score of the Connections to and from the Asset, and is 1 unless set
+	Criticality float64
+}
+
+// DefaultCriticality is the criticality of addresses without an Asset, and of Assets which don't set one
+var DefaultCriticality = 1.0
+
+// AssetReader reads Assets from a valid `assets.json` file.
+// It intentionally mirrors the PolicyReader, and doesn't accept the path as an input to the struct creation.
+type AssetReader struct{}
+
+// This leaves the results from the json intentionally untyped, to make it more resilient to improper values.
+type assetJson struct {
+	CIDR        interface{} `json:"cidr"`
+	Label       interface{} `json:"label"`
+	Criticality interface{} `json:"criticality,omitempty"`
+}
+
+// Read an `assets.json` file, and returns an Asset slice. Assets with an improper CIDR are logged and skipped.
+func (a AssetReader) Read(path string) ([]Asset, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read assets file")
+	}
+
+	var assetsJson []assetJson
+	if err = json.Unmarshal(content, &assetsJson); err != nil {
+		return nil, errors.Wrap(err, "failed to parse assets file")
+	}
+
+	var assets []Asset
+	for _, asset := range assetsJson {
+		_, network, err := net.ParseCIDR(fmt.Sprintf("%v", asset.CIDR))
+		if err != nil {
+			log.Printf("Improper asset CIDR %v found \n", asset.CIDR)
+			continue
+		}
+
+		criticality := DefaultCriticality
+		if asset.Criticality != nil {
+			parsed, err := strconv.ParseFloat(fmt.Sprintf("%v", asset.Criticality), 64)
+			if err != nil || parsed < 0 {
+				log.Printf("Improper asset criticality %v found \n", asset.Criticality)
+			} else {
+				criticality = parsed
+			}
+		}
+		assets = append(assets, Asset{Network: network, Label: fmt.Sprintf("%v", asset.Label), Criticality: criticality})
+	}
+	return assets, nil
+}
+
+// FindAsset returns the most specific Asset containing the address, and false if there is none
+func FindAsset(assets []Asset, ip net.IP) (Asset, bool) {
+	var found Asset
+	bestBits := -1
+	for _, asset := range assets {
+		if !asset.Network.Contains(ip) {
+			continue
+		}
+		if bits, _ := asset.Network.Mask.Size(); bits > bestBits {
+			found, bestBits = asset, bits
+		}
+	}
+	return found, bestBits >= 0
+}
@@ -0,0 +1,62 @@
+package engine_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestAssets(t *testing.T) {
+	spec.Run(t, "Assets", testAssets, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testAssets(t *testing.T, when spec.G, it spec.S) {
+	var assetReader = engine.AssetReader{}
+
+	when("#Read", func() {
+		it("returns a clear error when the file doesn't exist", func() {
+			_, err := assetReader.Read(filepath.Join("/tmp", "path", "does-not-exist"))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "failed to read assets file")
+		})
+
+		it("skips improper assets", func() {
+			assets, err := assetReader.Read(filepath.Join(testdataPath, "assets.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(assets))
+			assert.Equal(t, "10.0.0.0/16", assets[0].Network.String())
+			assert.Equal(t, "datacenter", assets[0].Label)
+		})
+
+		it("reads the criticality, which defaults to 1", func() {
+			assets, err := assetReader.Read(filepath.Join(testdataPath, "assets.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, engine.DefaultCriticality, assets[0].Criticality)
+			assert.Equal(t, 5.0, assets[1].Criticality)
+		})
+	})
+
+	when("#FindAsset", func() {
+		it("returns the most specific asset", func() {
+			assets, err := assetReader.Read(filepath.Join(testdataPath, "assets.json"))
+			assert.Nil(t, err)
+
+			asset, ok := engine.FindAsset(assets, net.ParseIP("10.0.1.5"))
+			assert.True(t, ok)
+			assert.Equal(t, "database servers", asset.Label)
+
+			asset, ok = engine.FindAsset(assets, net.ParseIP("10.0.2.5"))
+			assert.True(t, ok)
+			assert.Equal(t, "datacenter", asset.Label)
+
+			_, ok = engine.FindAsset(assets, net.ParseIP("8.8.8.8"))
+			assert.False(t, ok)
+		})
+	})
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var baselineBucket = []byte("tuples")
+
+// Baseline is a persistent, on-disk store of the (source, destination, destination port, protocol) tuples which were
+// observed in the past, together with when each was first and last seen. It is backed by an embedded bbolt database,
+// so that it survives across runs, and daily batches can build on each other.
+type Baseline struct {
+	db *bolt.DB
+}
+
+// BaselineEntry is the time span in which a tuple was observed
+type BaselineEntry struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// OpenBaseline opens the Baseline at the path, creating it if it doesn't exist yet. It must be closed once done.
+func OpenBaseline(path string) (*Baseline, error) {
+	// The timeout keeps a second run from blocking forever, while another one holds the file lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open baseline %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(baselineBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "failed to initialize baseline %s", path)
+	}
+	return &Baseline{db: db}, nil
+}
+
+// Close the Baseline's database
+func (b *Baseline) Close() error {
+	return b.db.Close()
+}
+
+// tupleKey returns the baseline key of a Connection. The source port is left out, as it is usually ephemeral.
+func tupleKey(conn Connection) string {
+	return fmt.Sprintf("%s|%s|%d|%s", conn.Source, conn.Destination, conn.DestinationPort, conn.Protocol)
+}
+
+func encodeEntry(entry BaselineEntry) []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[:8], uint64(entry.FirstSeen.UnixNano()))
+	binary.BigEndian.PutUint64(value[8:], uint64(entry.LastSeen.UnixNano()))
+	return value
+}
+
+func decodeEntry(value []byte) (BaselineEntry, bool) {
+	if len(value) != 16 {
+		return BaselineEntry{}, false
+	}
+	return BaselineEntry{
+		FirstSeen: time.Unix(0, int64(binary.BigEndian.Uint64(value[:8]))).UTC(),
+		LastSeen:  time.Unix(0, int64(binary.BigEndian.Uint64(value[8:]))).UTC(),
+	}, true
+}
+
+// Learn records the tuples of the Connections in the Baseline, extending the time span of tuples which were already
+// known. It returns the amount of tuples which were new. Connections without a valid timestamp are skipped.
+func (b *Baseline) Learn(conns []Connection) (int, error) {
+	// Aggregates the tuples first, so each is only written once
+	observed := map[string]BaselineEntry{}
+	for _, conn := range conns {
+		ts, err := conn.Time()
+		if err != nil {
+			continue
+		}
+
+		key := tupleKey(conn)
+		entry, ok := observed[key]
+		if !ok {
+			entry = BaselineEntry{FirstSeen: ts, LastSeen: ts}
+		}
+		if ts.Before(entry.FirstSeen) {
+			entry.FirstSeen = ts
+		}
+		if ts.After(entry.LastSeen) {
+			entry.LastSeen = ts
+		}
+		observed[key] = entry
+	}
+
+	added := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(baselineBucket)
+		for key, entry := range observed {
+			if known, ok := decodeEntry(bucket.Get([]byte(key))); ok {
+				if known.FirstSeen.Before(entry.FirstSeen) {
+					entry.FirstSeen = known.FirstSeen
+				}
+				if known.LastSeen.After(entry.LastSeen) {
+					entry.LastSeen = known.LastSeen
+				}
+			} else {
+				added++
+			}
+
+			if err := bucket.Put([]byte(key), encodeEntry(entry)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to update baseline")
+	}
+	return added, nil
+}
+
+// Expire removes the tuples which were last seen before the cutoff, and returns the amount which were removed
+func (b *Baseline) Expire(cutoff time.Time) (int, error) {
+	removed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(baselineBucket)
+		var stale [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			if entry, ok := decodeEntry(value); !ok || entry.LastSeen.Before(cutoff) {
+				// Keys are only valid during the transaction, and the bucket can't be modified while iterating it
+				stale = append(stale, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to expire baseline")
+	}
+	return removed, nil
+}
+
+// Len returns the amount of tuples in the Baseline
+func (b *Baseline) Len() (int, error) {
+	size := 0
+	err := b.db.View(func(tx *bolt.Tx) error {
+		size = tx.Bucket(baselineBucket).Stats().KeyN
+		return nil
+	})
+	return size, err
+}
+
+// Get returns the time span in which the Connection's tuple was observed, and false if it was never observed
+func (b *Baseline) Get(conn Connection) (BaselineEntry, bool) {
+	var entry BaselineEntry
+	found := false
+	_ = b.db.View(func(tx *bolt.Tx) error {
+		entry, found = decodeEntry(tx.Bucket(baselineBucket).Get([]byte(tupleKey(conn))))
+		return nil
+	})
+	return entry, found
+}
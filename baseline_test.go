@@ -0,0 +1,129 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestBaseline(t *testing.T) {
+	spec.Run(t, "Baseline", testBaseline, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testBaseline(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir string
+		path   string
+	)
+
+	connection := func(timestamp string, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       timestamp,
+			Source:          net.ParseIP("10.0.0.1"),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "baseline")
+		assert.Nil(t, err)
+		path = filepath.Join(tmpDir, "baseline.db")
+	})
+
+	it.After(func() {
+		assert.Nil(t, os.RemoveAll(tmpDir))
+	})
+
+	when("#Learn", func() {
+		it("records each tuple once, with the time span it was seen in", func() {
+			baseline, err := engine.OpenBaseline(path)
+			assert.Nil(t, err)
+			defer baseline.Close()
+
+			added, err := baseline.Learn([]engine.Connection{
+				connection("1000", "10.0.0.2", 22),
+				connection("1200", "10.0.0.2", 22),
+				connection("1100", "10.0.0.3", 443),
+				connection("not a time", "10.0.0.4", 80),
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 2, added)
+
+			size, err := baseline.Len()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, size)
+
+			entry, ok := baseline.Get(connection("5000", "10.0.0.2", 22))
+			assert.True(t, ok)
+			assert.Equal(t, time.Unix(1000, 0).UTC(), entry.FirstSeen)
+			assert.Equal(t, time.Unix(1200, 0).UTC(), entry.LastSeen)
+
+			_, ok = baseline.Get(connection("5000", "10.0.0.2", 23))
+			assert.False(t, ok)
+		})
+
+		it("persists across runs, and extends the time span of known tuples", func() {
+			baseline, err := engine.OpenBaseline(path)
+			assert.Nil(t, err)
+			_, err = baseline.Learn([]engine.Connection{connection("1000", "10.0.0.2", 22)})
+			assert.Nil(t, err)
+			assert.Nil(t, baseline.Close())
+
+			baseline, err = engine.OpenBaseline(path)
+			assert.Nil(t, err)
+			defer baseline.Close()
+
+			added, err := baseline.Learn([]engine.Connection{connection("9000", "10.0.0.2", 22)})
+			assert.Nil(t, err)
+			assert.Equal(t, 0, added)
+
+			entry, ok := baseline.Get(connection("9000", "10.0.0.2", 22))
+			assert.True(t, ok)
+			assert.Equal(t, time.Unix(1000, 0).UTC(), entry.FirstSeen)
+			assert.Equal(t, time.Unix(9000, 0).UTC(), entry.LastSeen)
+		})
+	})
+
+	when("#Expire", func() {
+		it("removes the tuples which were last seen before the cutoff", func() {
+			baseline, err := engine.OpenBaseline(path)
+			assert.Nil(t, err)
+			defer baseline.Close()
+
+			_, err = baseline.Learn([]engine.Connection{
+				connection("1000", "10.0.0.2", 22),
+				connection("3000", "10.0.0.3", 443),
+			})
+			assert.Nil(t, err)
+
+			removed, err := baseline.Expire(time.Unix(2000, 0))
+			assert.Nil(t, err)
+			assert.Equal(t, 1, removed)
+
+			_, ok := baseline.Get(connection("5000", "10.0.0.2", 22))
+			assert.False(t, ok)
+			_, ok = baseline.Get(connection("5000", "10.0.0.3", 443))
+			assert.True(t, ok)
+		})
+	})
+
+	when("the path is invalid", func() {
+		it("returns an error", func() {
+			_, err := engine.OpenBaseline(filepath.Join(tmpDir, "missing", "baseline.db"))
+			assert.Error(t, err)
+		})
+	})
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+var (
+	BeaconAlert = "BEACON"
+)
+
+// maxBeaconIntervals bounds the amount of timestamps kept for a single group, so that a long-lived, chatty pair can't
+// grow the state indefinitely. The regularity is then scored over the most recent timestamps.
+var maxBeaconIntervals = 256
+
+// beaconSampleSize is the amount of Connections which are kept for each group, as a sample for the Alert
+var beaconSampleSize = 5
+
+// BeaconDetector flags a source contacting the same destination, port and protocol at near-constant intervals, which
+// is how C2 beacons usually show up.
+type BeaconDetector struct {
+	minCount int
+	minScore float64
+	jitter   float64
+	internal []*net.IPNet
+	groups   map[string]windowGroup
+}
+
+// NewBeaconDetector returns a BeaconDetector which flags groups of at least minCount Connections, whose regularity
+// score is at least minScore. The score is the fraction of intervals which are within jitter (a fraction, e.g. 0.1 for
+// 10%) of the median interval. With internal networks, only the outbound Connections (from an internal source to an
+// external destination) are grouped, so that periodic internal and inbound traffic (e.g. monitoring polls) isn't
+// flagged. Without them, every Connection is grouped.
+func NewBeaconDetector(minCount int, minScore, jitter float64, internal []*net.IPNet) *BeaconDetector {
+	return &BeaconDetector{minCount: minCount, minScore: minScore, jitter: jitter, internal: internal, groups: map[string]windowGroup{}}
+}
+
+// beaconGroup holds the timestamps (in seconds) of a single (source, destination, port, protocol) group
+type beaconGroup struct {
+	count  int
+	times  []float64
+	sample []Connection
+	latest Connection
+	ts     time.Time
+}
+
+func (g *beaconGroup) last() time.Time {
+	return g.ts
+}
+
+// Observe records the Connection's timestamp in its group. Beacons can only be scored once the whole stream has been
+// seen, so it never returns an Alert. Connections without a valid timestamp, and those which aren't outbound (when
+// there are internal networks), are ignored.
+func (b *BeaconDetector) Observe(conn Connection) []Alert {
+	if len(b.internal) != 0 && (!containsIP(b.internal, conn.Source) || containsIP(b.internal, conn.Destination)) {
+		return nil
+	}
+	ts, err := conn.Time()
+	if err != nil {
+		return nil
+	}
+
+	key := fmt.Sprintf("source %s -> %s:%d/%s", conn.Source, conn.Destination, conn.DestinationPort, conn.Protocol)
+	group, ok := b.groups[key]
+	if !ok {
+		if len(b.groups) >= maxDetectorGroups {
+			// There is no window for beacons, so only the least recently seen groups are evicted
+			evictGroups(b.groups, time.Time{})
+		}
+		group = &beaconGroup{}
+		b.groups[key] = group
+	}
+	beacon := group.(*beaconGroup)
+
+	beacon.count++
+	beacon.times = append(beacon.times, float64(ts.UnixNano())/float64(time.Second))
+	if len(beacon.times) > maxBeaconIntervals+1 {
+		beacon.times = beacon.times[1:]
+	}
+	if len(beacon.sample) < beaconSampleSize {
+		beacon.sample = append(beacon.sample, conn)
+	}
+	beacon.latest = conn
+	beacon.ts = ts
+	return nil
+}
+
+// Flush returns the Alerts of the groups which look like a beacon (see Report), and forgets the groups
+func (b *BeaconDetector) Flush() []Alert {
+	alerts := b.Report()
+	b.groups = map[string]windowGroup{}
+	return alerts
+}
+
+// Report scores each group's regularity so far, and returns an Alert for each of the groups which look like a beacon,
+// ordered by their key
+func (b *BeaconDetector) Report() []Alert {
+	keys := make([]string, 0, len(b.groups))
+	for key := range b.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var alerts []Alert
+	for _, key := range keys {
+		beacon := b.groups[key].(*beaconGroup)
+		if beacon.count < b.minCount || len(beacon.times) < 3 {
+			continue
+		}
+
+		stats := scoreIntervals(beacon.times, b.jitter)
+		if stats == nil || stats["score"] < b.minScore {
+			continue
+		}
+
+		alerts = append(alerts, Alert{
+			Type:        BeaconAlert,
+			Key:         key,
+			Count:       beacon.count,
+			FirstSeen:   beacon.sample[0].Timestamp,
+			LastSeen:    beacon.latest.Timestamp,
+			Stats:       stats,
+			Connections: beacon.sample,
+		})
+	}
+	return alerts
+}
+
+// scoreIntervals computes the statistics of the intervals between the timestamps, and a regularity score, which is the
+// fraction of intervals within jitter of the median interval. It returns nil if the timestamps have no usable interval.
+func scoreIntervals(times []float64, jitter float64) map[string]float64 {
+	sorted := append([]float64{}, times...)
+	sort.Float64s(sorted)
+
+	intervals := make([]float64, 0, len(sorted)-1)
+	sum := 0.0
+	for i := 1; i < len(sorted); i++ {
+		interval := sorted[i] - sorted[i-1]
+		intervals = append(intervals, interval)
+		sum += interval
+	}
+	mean := sum / float64(len(intervals))
+
+	variance := 0.0
+	for _, interval := range intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(intervals)))
+
+	sort.Float64s(intervals)
+	median := intervals[len(intervals)/2]
+	if len(intervals)%2 == 0 {
+		median = (intervals[len(intervals)/2-1] + median) / 2
+	}
+	// Connections at the same instant (e.g. both sides of a session) aren't periodic
+	if median <= 0 {
+		return nil
+	}
+
+	regular := 0
+	for _, interval := range intervals {
+		if math.Abs(interval-median) <= jitter*median {
+			regular++
+		}
+	}
+
+	return map[string]float64{
+		"score":           float64(regular) / float64(len(intervals)),
+		"mean_interval":   mean,
+		"median_interval": median,
+		"stddev_interval": stddev,
+		"min_interval":    intervals[0],
+		"max_interval":    intervals[len(intervals)-1],
+	}
+}
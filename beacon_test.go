@@ -0,0 +1,96 @@
+package engine_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestBeaconDetector(t *testing.T) {
+	spec.Run(t, "BeaconDetector", testBeaconDetector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testBeaconDetector(t *testing.T, when spec.G, it spec.S) {
+	between := func(source, destination string, timestamps ...float64) []engine.Connection {
+		var conns []engine.Connection
+		for _, timestamp := range timestamps {
+			conns = append(conns, engine.Connection{
+				Timestamp:       fmt.Sprintf("%.6f", timestamp),
+				Source:          net.ParseIP(source),
+				SourcePort:      40000,
+				Destination:     net.ParseIP(destination),
+				DestinationPort: 443,
+				Protocol:        "TCP",
+			})
+		}
+		return conns
+	}
+	connections := func(destination string, timestamps ...float64) []engine.Connection {
+		return between("192.0.0.3", destination, timestamps...)
+	}
+
+	when("connections are periodic", func() {
+		it("flags the pair with its interval statistics", func() {
+			conns := connections("10.0.0.1", 1000, 1060.5, 1119.8, 1180.2, 1240, 1300)
+
+			result := engine.DetectAttacks(nil, conns, engine.NewBeaconDetector(5, 0.9, 0.1, nil))
+			assert.Equal(t, 1, len(result.Alerts))
+			alert := result.Alerts[0]
+			assert.Equal(t, engine.BeaconAlert, alert.Type)
+			assert.Equal(t, "source 192.0.0.3 -> 10.0.0.1:443/TCP", alert.Key)
+			assert.Equal(t, 6, alert.Count)
+			assert.Equal(t, "1000.000000", alert.FirstSeen)
+			assert.Equal(t, "1300.000000", alert.LastSeen)
+			assert.Equal(t, 1.0, alert.Stats["score"])
+			assert.InDelta(t, 60, alert.Stats["mean_interval"], 0.001)
+			assert.InDelta(t, 59.3, alert.Stats["min_interval"], 0.001)
+			assert.InDelta(t, 60.5, alert.Stats["max_interval"], 0.001)
+			assert.Equal(t, conns[:5], alert.Connections)
+		})
+	})
+
+	when("connections are irregular", func() {
+		it("doesn't flag the pair", func() {
+			conns := connections("10.0.0.1", 1000, 1003, 1100, 1102, 1500, 1900)
+			result := engine.DetectAttacks(nil, conns, engine.NewBeaconDetector(5, 0.9, 0.1, nil))
+			assert.Nil(t, result.Alerts)
+		})
+	})
+
+	when("there are too few connections", func() {
+		it("doesn't flag the pair", func() {
+			conns := connections("10.0.0.1", 1000, 1060, 1120)
+			result := engine.DetectAttacks(nil, conns, engine.NewBeaconDetector(5, 0.9, 0.1, nil))
+			assert.Nil(t, result.Alerts)
+		})
+	})
+
+	when("there are internal networks", func() {
+		it("only flags the outbound pairs, rather than the periodic internal and inbound traffic", func() {
+			internal, err := engine.ParseNetworks(engine.DefaultInternalNetworks)
+			assert.Nil(t, err)
+			timestamps := []float64{1000, 1060, 1120, 1180, 1240}
+			conns := append(between("10.0.0.3", "203.0.113.5", timestamps...), between("10.0.0.3", "10.0.0.1", timestamps...)...)
+			conns = append(conns, between("203.0.113.5", "10.0.0.3", timestamps...)...)
+
+			result := engine.DetectAttacks(nil, conns, engine.NewBeaconDetector(5, 0.9, 0.1, internal))
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, "source 10.0.0.3 -> 203.0.113.5:443/TCP", result.Alerts[0].Key)
+		})
+	})
+
+	when("several pairs are interleaved", func() {
+		it("scores each pair separately", func() {
+			conns := append(connections("10.0.0.1", 1000, 1010, 1020, 1030, 1040), connections("10.0.0.2", 1005, 1200, 1201, 1500, 1600)...)
+			result := engine.DetectAttacks(nil, conns, engine.NewBeaconDetector(5, 0.9, 0.1, nil))
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, "source 192.0.0.3 -> 10.0.0.1:443/TCP", result.Alerts[0].Key)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// The magic bytes at the beginning of compressed files, which are used to detect their compression regardless of their
+// extension
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// The extensions of output files which are compressed, e.g. `out/suspicious.csv.gz`
+var (
+	GzipExtension = ".gz"
+	ZstdExtension = ".zst"
+)
+
+// readCloser closes the decompressor, when there is one
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// decompress transparently decompresses a stream while it is read, when it is gzip or zstd compressed. The compression
+// is detected by the stream's magic bytes, so it doesn't depend on the file's extension. Closing the returned reader
+// releases the decompressor, but doesn't close the stream.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	// A stream shorter than the magic bytes can't be compressed, so the error only means there's less to peek at
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		decompressor, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, errors.Wrap(err, "decompressing gzip stream")
+		}
+		return decompressor, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decompressor, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, errors.Wrap(err, "decompressing zstd stream")
+		}
+		return readCloser{Reader: decompressor, close: func() error {
+			decompressor.Close()
+			return nil
+		}}, nil
+	default:
+		return readCloser{Reader: buffered, close: func() error { return nil }}, nil
+	}
+}
+
+// writeCloser closes the compressor, flushing what remains of the compressed stream, before the underlying file
+type writeCloser struct {
+	io.Writer
+	close func() error
+}
+
+func (w writeCloser) Close() error {
+	return w.close()
+}
+
+// createCompressed creates a file, which is compressed while it is written when its extension is `.gz` (gzip) or `.zst`
+// (zstd). The file must be closed for the compressed stream to be complete.
+func createCompressed(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var compressor io.WriteCloser
+	switch filepath.Ext(path) {
+	case GzipExtension:
+		compressor = gzip.NewWriter(f)
+	case ZstdExtension:
+		if compressor, err = zstd.NewWriter(f); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "compressing zstd file %s", path)
+		}
+	default:
+		return f, nil
+	}
+
+	return writeCloser{Writer: compressor, close: func() error {
+		if err := compressor.Close(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}}, nil
+}
@@ -3,19 +3,38 @@
 package engine
 
 import (
+	"fmt"
 	"log"
 	"net"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Connection defines a network connection.
 type Connection struct {
-	Timestamp string // Stored as a string, in order to conserve effort when saving back to a file
-	Source net.IP
-	SourcePort int
-	Destination net.IP
-	DestinationPort int
-	Protocol string
+	Timestamp string `json:"timestamp"` // Stored as a string, in order to conserve effort when saving back to a file
+	Source net.IP `json:"source"`
+	SourcePort int `json:"source_port"`
+	Destination net.IP `json:"destination"`
+	DestinationPort int `json:"destination_port"`
+	Protocol string `json:"protocol"`
+	// Fields holds the extra fields of the collectors which provide them (e.g. Zeek's `service` and `duration`), by
+	// name, and is nil for the other files
+	Fields map[string]string `json:"fields,omitempty"`
+	// Origin is the file and line the Connection was read from, and is only set when the reader tracks it (see
+	// ConnectionsReadWriter.TrackOrigin)
+	Origin *Origin `json:"-"`
+}
+
+// Origin is where a Connection was read from
+type Origin struct {
+	File string
+	// Line is the line of the Connection in its file (the line its record starts on, for a `.csv` file, or its entry, for
+	// a JSON array), and is 0 for the Connections of captures
+	Line int
 }
 
 // NewConnection takes a row of information from a CSV (represented by an array of strings), and returns the parsed Connection object.
@@ -45,6 +64,32 @@ func NewConnection(row []string) Connection {
 	return conn
 }
 
+// Time parses the epoch Timestamp (e.g. `1599665118.593452`) of the Connection.
+// The seconds and fraction are parsed separately, to avoid losing the microseconds to float precision.
+func (c Connection) Time() (time.Time, error) {
+	parts := strings.SplitN(c.Timestamp, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid timestamp %s", c.Timestamp)
+	}
+
+	var nsec int64
+	if len(parts) == 2 && parts[1] != "" {
+		// Pads or truncates the fraction to nanosecond precision
+		fraction := (parts[1] + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid timestamp %s", c.Timestamp)
+		}
+	}
+
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// epochTimestamp formats a time as an epoch Timestamp with microseconds, the same as the connections files
+func epochTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
 func (c Connection) toCSV() []string{
 	return []string{c.Timestamp, c.Source.String(), strconv.Itoa(c.SourcePort), c.Destination.String(), strconv.Itoa(c.DestinationPort), c.Protocol}
 }
@@ -1,79 +1,346 @@
 package engine
 
 import (
+	"bufio"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-// ConnectionsReadWriter manages Connections, reading them in from a valid .csv file, and writing to a .csv file.
+// ConnectionsReadWriter manages Connections, reading them in from a valid .csv (or JSON Lines, Zeek, NetFlow, pcap or
+// cloud flow log) file, and writing to a .csv (or JSON Lines) file.
 // It is based on the Go ReadWriter pattern, but intentionally doesn't accept the path/file as an input to the struct
 // creation, to make it clear that it can read and write to separate locations.
-type ConnectionsReadWriter struct {}
+type ConnectionsReadWriter struct {
+	// Schema describes the layout of the files which are read, and the zero value reads the default layout
+	Schema Schema
+	// Format is the format of the files which are written, CSVFormat or JSONLinesFormat, and is detected by the path's
+	// extension when it is empty (see formatOf)
+	Format string
+	// InputFormat is the format of the files which are read, and is detected by their beginning when it is empty. The
+	// NetFlowFormat isn't detected, and must be set.
+	InputFormat string
+	// NetFlowPorts are the UDP ports of the NetFlow exports which are read from a capture, and any port is read when
+	// it is nil
+	NetFlowPorts []int
+	// CaptureMode is FlowMode or PacketMode, and reads a Connection for each flow of a pcap or pcapng capture when
+	// it is empty
+	CaptureMode string
+	// FlowTimeout is the time a flow of a capture may be idle before its next packet starts a new flow, and is the
+	// DefaultFlowTimeout when it is zero
+	FlowTimeout time.Duration
+	// TrackOrigin sets the Origin of each Connection which is read
+	TrackOrigin bool
+	// OmitHeader leaves out the header of a `.csv` stream, when its rows are appended to a stream which has one
+	OmitHeader bool
+}
+
+// The formats of the files which are read, besides CSVFormat and JSONLinesFormat, see ConnectionsReadWriter.InputFormat
+var (
+	ZeekFormat = "zeek"
+	NetFlowFormat = "netflow"
+	PcapFormat = "pcap"
+	AWSFormat = "aws"
+	GCPFormat = "gcp"
+)
+
+// InputFormats are the formats of the files which are read
+var InputFormats = []string{CSVFormat, JSONLinesFormat, ZeekFormat, NetFlowFormat, PcapFormat, AWSFormat, GCPFormat}
 
 var headerRow = []string{"timestamp","source","source_port","destination","destination_port","protocol"}
 
-// Read reads a connections `.csv` file, and returns a Connection slice
+// byteOrderMark is written at the beginning of some UTF-8 exports, and isn't part of the first column
+var byteOrderMark = "\ufeff"
+
+// StdStream is the path which reads the connections from stdin, or writes them to stdout, to use the engine in a pipeline
+var StdStream = "-"
+
+// statusOutput is where the writers report the files they write. It is switched to stderr when the suspicious
+// connections are written to stdout, so that stdout only holds the CSV.
+var statusOutput io.Writer = os.Stdout
+
+// Read reads a connections `.csv`, JSON Lines, Zeek `conn.log`, NetFlow, pcap or AWS or GCP flow log file (or stdin, for StdStream), which may be gzip or zstd compressed,
+// and returns a Connection slice
 func (c ConnectionsReadWriter) Read(path string) ([]Connection, error) {
+	connections, err := c.readPath(path)
+	if c.TrackOrigin {
+		for i := range connections {
+			if connections[i].Origin == nil {
+				connections[i].Origin = &Origin{}
+			}
+			connections[i].Origin.File = path
+		}
+	}
+	return connections, err
+}
+
+func (c ConnectionsReadWriter) readPath(path string) ([]Connection, error) {
+	if path == StdStream {
+		return c.ReadStream(os.Stdin)
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read connection file")
 	}
 	defer f.Close()
 
-	reader := csv.NewReader(f)
+	return c.ReadStream(f)
+}
+
+// origin returns the Origin of a Connection read from a line, when the Origins are tracked, and nil otherwise
+func (c ConnectionsReadWriter) origin(line int) *Origin {
+	if !c.TrackOrigin {
+		return nil
+	}
+	return &Origin{Line: line}
+}
+
+// ReadStream reads connections in the InputFormat from a stream, which may be gzip or zstd compressed, and returns a
+// Connection slice. The `.csv`, JSON Lines, Zeek `conn.log`, pcap (or pcapng) and flow log formats are detected by the
+// stream's beginning, when the InputFormat is empty, except for AWS flow logs without a header.
+func (c ConnectionsReadWriter) ReadStream(r io.Reader) ([]Connection, error) {
+	decompressed, err := decompress(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read connection file")
+	}
+	defer decompressed.Close()
+
+	buffered := bufio.NewReader(decompressed)
+	switch c.InputFormat {
+	case "":
+		if isJSONLines(buffered) {
+			return c.readJSONLines(buffered)
+		}
+		if isZeekTSV(buffered) {
+			return c.readZeekTSV(buffered)
+		}
+		if isCapture(buffered) {
+			return c.readCapture(buffered)
+		}
+		if isAWSFlowLog(buffered) {
+			return c.readAWSFlowLog(buffered)
+		}
+		if isJSONArray(buffered) {
+			return c.readGCPArray(buffered)
+		}
+		return c.readCSV(buffered)
+	case CSVFormat:
+		return c.readCSV(buffered)
+	case JSONLinesFormat:
+		return c.readJSONLines(buffered)
+	case ZeekFormat:
+		// Zeek logs are either TSV or JSON
+		if isJSONLines(buffered) {
+			return c.readJSONLines(buffered)
+		}
+		return c.readZeekTSV(buffered)
+	case NetFlowFormat:
+		return c.readNetFlow(buffered)
+	case PcapFormat:
+		return c.readCapture(buffered)
+	case AWSFormat:
+		return c.readAWSFlowLog(buffered)
+	case GCPFormat:
+		// GCP log entries are either a JSON array or JSON Lines
+		if isJSONArray(buffered) {
+			return c.readGCPArray(buffered)
+		}
+		return c.readJSONLines(buffered)
+	default:
+		return nil, errors.Errorf("unknown input format %s, expected one of %s", c.InputFormat, strings.Join(InputFormats, ", "))
+	}
+}
+
+// readCSV reads the connections of a `.csv` stream, whose columns are mapped by its header or by the Schema
+func (c ConnectionsReadWriter) readCSV(r io.Reader) ([]Connection, error) {
+	lines := &csvLines{r: bufio.NewReader(r), comment: c.Schema.Comment}
+	reader := csv.NewReader(lines)
+	c.Schema.configure(reader)
+
+	var mapping columnMapping
+	var header []string
 	var connections []Connection
 	for {
 		row, err := reader.Read()
+		line := lines.next()
 		if err != nil {
 			if err == io.EOF {
 				err = nil
 			}
-			break
-		//	Header row, if exists, isn't a valid Connection
-		} else if row[0] == headerRow[0] {
+			return connections, err
+		}
+
+		if mapping == nil {
+			row[0] = strings.TrimPrefix(row[0], byteOrderMark)
+			// Header row, if exists, isn't a valid Connection, but maps the columns of the following ones
+			var isHeader bool
+			if mapping, isHeader, err = headerMapping(row); err != nil {
+				return nil, errors.Wrap(err, "failed to read connection file header")
+			}
+			if isHeader {
+				header = row
+				continue
+			}
+			if mapping, err = c.Schema.columnsMapping(); err != nil {
+				return nil, err
+			}
+		} else if header != nil && reflect.DeepEqual(row, header) {
+			// The header is repeated when files are concatenated
 			continue
 		}
 
-		connections = append(connections, NewConnection(row))
+		if len(row) < mapping.width() {
+			return connections, errors.Errorf("line %d has %d column(s), but %d are expected", line, len(row), mapping.width())
+		}
+		conn := NewConnection(mapping.row(row))
+		conn.Origin = c.origin(line)
+		connections = append(connections, conn)
 	}
+}
 
-	return connections, err
+// csvLines hands the lines of a `.csv` stream to a csv.Reader one at a time, so that the reader never holds the lines
+// after its record, and the line each record starts on is known, as the reader only counts its records. The blank and
+// comment lines, which the reader skips, don't start a record.
+type csvLines struct {
+	r *bufio.Reader
+	comment rune
+	// line is the amount of lines which were read, and start is the first of them which started a record since next
+	line int
+	start int
+	// pending is the rest of a line which didn't fit the last Read
+	pending []byte
+}
+
+func (l *csvLines) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		line, err := l.r.ReadBytes('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		l.line++
+		content := strings.TrimRight(string(line), "\r\n")
+		if l.start == 0 && content != "" && (l.comment == 0 || !strings.HasPrefix(content, string(l.comment))) {
+			l.start = l.line
+		}
+		l.pending = line
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// next returns the line the last record started on, and forgets it, so that the following lines start the next one
+func (l *csvLines) next() int {
+	start := l.start
+	l.start = 0
+	return start
+}
+
+// Column is an additional column of the output CSV, whose value is computed for each of the written Connections
+type Column struct {
+	Name string
+	// Value returns the column's value for the Connection at index i of the written Connections
+	Value func(i int, conn Connection) string
+	// JSONName is the column's field in the JSON Lines format, when it differs from its Name
+	JSONName string
+	// JSONValue returns the column's value in the JSON Lines format, when it isn't a string (e.g. a list or a number)
+	JSONValue func(i int, conn Connection) interface{}
+}
+
+func (c Column) jsonName() string {
+	if c.JSONName != "" {
+		return c.JSONName
+	}
+	return c.Name
+}
+
+func (c Column) jsonValue(i int, conn Connection) interface{} {
+	if c.JSONValue != nil {
+		return c.JSONValue(i, conn)
+	}
+	return c.Value(i, conn)
+}
+
+// Write a Connection slice to the output path, optionally followed by additional Columns. The file is compressed when
+// the path ends with `.gz` or `.zst`, and is written to stdout for StdStream. It is written in the JSON Lines format when
+// that is the Format, or when the Format is empty and the path ends with `.jsonl` or `.ndjson`.
+func (c ConnectionsReadWriter) Write(connections []Connection, path string, columns ...Column) error {
+	return c.write(connections, path, "suspicious connections", columns...)
 }
 
-// Write a Connection slice to the output path
-func (c ConnectionsReadWriter) Write(connections []Connection, path string) error {
-	fmt.Printf("Writing suspicious connections file to %s\n", path)
+// write is Write, whose status message names the output (e.g. the suspicious or the suppressed connections)
+func (c ConnectionsReadWriter) write(connections []Connection, path string, name string, columns ...Column) error {
+	if c.Format == "" && path != StdStream {
+		c.Format = formatOf(path)
+	}
+	if path == StdStream {
+		fmt.Fprintf(statusOutput, "Writing %s to stdout\n", name)
+		return c.WriteStream(os.Stdout, connections, columns...)
+	}
+	fmt.Fprintf(statusOutput, "Writing %s file to %s\n", name, path)
 
 	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
 		return errors.Wrapf(err, "creating directory %s", filepath.Dir(path))
 	}
 
-	file, err := os.Create(path)
+	file, err := createCompressed(path)
 	if err != nil {
-		return errors.Wrapf(err, "creating file %s", file.Name())
+		return errors.Wrapf(err, "creating file %s", path)
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	if err = writer.Write(headerRow); err != nil {
-		return errors.Wrap(err, "writing header to file")
+	if err = c.WriteStream(file, connections, columns...); err != nil {
+		file.Close()
+		return err
 	}
-	for _, value := range connections {
-		if err = writer.Write(value.toCSV()); err != nil {
-			return errors.Wrapf(err, "writing value %+v to file", value)
-		}
+	if err = file.Close(); err != nil {
+		return errors.Wrapf(err, "closing file %s", path)
 	}
 
 	log.Println("Successfully wrote file.")
 	return nil
 }
 
+// WriteStream writes a Connection slice to a stream in the Format (`.csv` by default), optionally followed by additional
+// Columns
+func (c ConnectionsReadWriter) WriteStream(w io.Writer, connections []Connection, columns ...Column) error {
+	switch c.Format {
+	case "", CSVFormat:
+	case JSONLinesFormat:
+		return writeJSONLines(w, connections, columns...)
+	default:
+		return errors.Errorf("unknown output format %s, expected %s or %s", c.Format, CSVFormat, JSONLinesFormat)
+	}
+
+	writer := csv.NewWriter(w)
 
+	header := append([]string{}, headerRow...)
+	for _, column := range columns {
+		header = append(header, column.Name)
+	}
+	if !c.OmitHeader {
+		if err := writer.Write(header); err != nil {
+			return errors.Wrap(err, "writing header to file")
+		}
+	}
+	for i, value := range connections {
+		row := value.toCSV()
+		for _, column := range columns {
+			row = append(row, column.Value(i, value))
+		}
+		if err := writer.Write(row); err != nil {
+			return errors.Wrapf(err, "writing value %+v to file", value)
+		}
+	}
+
+	writer.Flush()
+	return errors.Wrap(writer.Error(), "writing to file")
+}
@@ -1,7 +1,12 @@
 package engine_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -60,4 +65,105 @@ func testConnectionsReadWriter(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 	})
-}
\ No newline at end of file
+
+	when("streams", func() {
+		conns := []engine.Connection{{
+			Timestamp: "1599665118.593452",
+			Source: net.ParseIP("192.0.0.2"),
+			SourcePort: 5000,
+			Destination: net.ParseIP("192.128.0.32"),
+			DestinationPort: 51000,
+			Protocol: "TCP",
+		}}
+		csvContent := "timestamp,source,source_port,destination,destination_port,protocol\n" +
+			"1599665118.593452,192.0.0.2,5000,192.128.0.32,51000,TCP\n"
+
+		it("writes the connections to a stream", func() {
+			var buf bytes.Buffer
+			assert.Nil(t, connectionRW.WriteStream(&buf, conns))
+			assert.Equal(t, csvContent, buf.String())
+		})
+
+		it("reads the connections from a stream, decompressing it when it is compressed", func() {
+			read, err := connectionRW.ReadStream(bytes.NewBufferString(csvContent))
+			assert.Nil(t, err)
+			assert.Equal(t, conns, read)
+
+			var compressed bytes.Buffer
+			writer := gzip.NewWriter(&compressed)
+			_, err = writer.Write([]byte(csvContent))
+			assert.Nil(t, err)
+			assert.Nil(t, writer.Close())
+
+			read, err = connectionRW.ReadStream(&compressed)
+			assert.Nil(t, err)
+			assert.Equal(t, conns, read)
+		})
+	})
+
+	when("#Write", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "connections")
+			assert.Nil(t, err)
+		})
+
+		it.After(func() {
+			assert.Nil(t, os.RemoveAll(tmpDir))
+		})
+
+		it("writes the additional columns after the connection", func() {
+			path := filepath.Join(tmpDir, "suspicious.csv")
+			conns := []engine.Connection{{
+				Timestamp: "1599665118.593452",
+				Source: net.ParseIP("192.0.0.2"),
+				SourcePort: 5000,
+				Destination: net.ParseIP("192.128.0.32"),
+				DestinationPort: 51000,
+				Protocol: "TCP",
+			}}
+			column := engine.Column{Name: "reason", Value: func(i int, conn engine.Connection) string {
+				return fmt.Sprintf("connection %d from %s", i, conn.Source)
+			}}
+			assert.Nil(t, connectionRW.Write(conns, path, column))
+
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Equal(t, "timestamp,source,source_port,destination,destination_port,protocol,reason\n" +
+				"1599665118.593452,192.0.0.2,5000,192.128.0.32,51000,TCP,connection 0 from 192.0.0.2\n", string(content))
+
+			read, err := connectionRW.Read(path)
+			assert.Nil(t, err)
+			assert.Equal(t, conns, read)
+		})
+
+		for _, ext := range []string{".gz", ".zst"} {
+			ext := ext
+			it("compresses the file when the path ends with "+ext, func() {
+				path := filepath.Join(tmpDir, "suspicious.csv"+ext)
+				conns := []engine.Connection{{
+					Timestamp: "1599665118.593452",
+					Source: net.ParseIP("192.0.0.2"),
+					SourcePort: 5000,
+					Destination: net.ParseIP("192.128.0.32"),
+					DestinationPort: 51000,
+					Protocol: "TCP",
+				}}
+				assert.Nil(t, connectionRW.Write(conns, path))
+
+				content, err := ioutil.ReadFile(path)
+				assert.Nil(t, err)
+				assert.NotEqual(t, "timestamp", string(content[:9]))
+
+				// The compression is detected by the content, so it doesn't depend on the extension
+				renamed := filepath.Join(tmpDir, "renamed.csv")
+				assert.Nil(t, os.Rename(path, renamed))
+				read, err := connectionRW.Read(renamed)
+				assert.Nil(t, err)
+				assert.Equal(t, conns, read)
+			})
+		}
+	})
+}
@@ -3,6 +3,7 @@ package engine_test
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
@@ -43,4 +44,24 @@ func testConnections(t *testing.T, when spec.G, it spec.S) {
 			}, connection)
 		})
 	})
+
+	when("#Time", func() {
+		it("parses the epoch timestamp", func() {
+			ts, err := engine.Connection{Timestamp: "1599665154.660434"}.Time()
+			assert.Nil(t, err)
+			assert.Equal(t, time.Unix(1599665154, 660434000).UTC(), ts)
+		})
+
+		it("parses a timestamp without a fraction", func() {
+			ts, err := engine.Connection{Timestamp: "1599665154"}.Time()
+			assert.Nil(t, err)
+			assert.Equal(t, time.Unix(1599665154, 0).UTC(), ts)
+		})
+
+		it("returns an error for an invalid timestamp", func() {
+			_, err := engine.Connection{Timestamp: "yesterday"}.Time()
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "invalid timestamp yesterday")
+		})
+	})
 }
\ No newline at end of file
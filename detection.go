@@ -1,51 +1,187 @@
 package engine
 
+var (
+	CleanVerdict = "CLEAN"
+	SuspiciousVerdict = "SUSPICIOUS"
+)
+
 // DetectionResult contains all information gathered during DetectAttacks
 type DetectionResult struct {
 	Suspicious []Connection
 	RuleCount map[string]int
+	// TagCount and TechniqueCount hold the amount of Connections which matched a rule with each tag and technique, and
+	// are nil when no matched rule had any
+	TagCount map[string]int
+	TechniqueCount map[string]int
 	NoMatchCount int
 	CleanCount int
+	// Alerts raised by stateful rules, which are based on several Connections together
+	Alerts []Alert
+	// Verdicts holds the verdict of each of the Connections, in the order they were observed
+	Verdicts []string
+	// SuspiciousIndices holds the index of each of the Suspicious Connections among the observed ones, and
+	// SuspiciousMatched the Policies each of them matched
+	SuspiciousIndices []int
+	SuspiciousMatched [][]Policy
+}
+
+// Evaluation is the final verdict for a single Connection, together with the Policies it matched (in policy order)
+type Evaluation struct {
+	Verdict string
+	Matched []Policy
 }
 
-// DetectAttacks in a Connection slice, based on a Policy slice
+// DetectAttacks in a Connection slice, based on a Policy slice, and optionally on Detectors which run alongside it
 // Parallelization could help performance, and could be taken care of by splitting the connection slice,
 // and calling DetectAttacks with copies of the Policies, ultimately combining the DetectionResult responses.
-func DetectAttacks(policies []Policy, conns []Connection) DetectionResult {
-	result := &DetectionResult{
+func DetectAttacks(policies []Policy, conns []Connection, detectors ...Detector) DetectionResult {
+	detection := NewDetection(policies, detectors...)
+	result := detection.Observe(conns)
+	result.Alerts = append(result.Alerts, detection.Flush()...)
+	return result
+}
+
+// Evaluate a single Connection against a Policy slice, returning its final verdict:
+// CLEAN if it matched any IGNORE rule or no rules at all, and SUSPICIOUS if it matched only INSPECT rules.
+func Evaluate(policies []Policy, conn Connection) Evaluation {
+	eval := Evaluation{Verdict: CleanVerdict}
+	suspect := false
+	ignore := false
+	for _, policy := range policies {
+		// Stateful rules don't have a verdict for a single Connection, and are handled by their own stores
+		if policy.stateful() {
+			continue
+		}
+		if policy.Matches(conn) {
+			eval.Matched = append(eval.Matched, policy)
+			if policy.Verdict == InspectVerdict {
+				suspect = true
+			} else if policy.Verdict == IgnoreVerdict {
+				ignore = true
+			}
+		}
+	}
+
+	if suspect && !ignore {
+		eval.Verdict = SuspiciousVerdict
+	}
+	return eval
+}
+
+// Detection runs the policy engine and the Detectors over a stream of Connections which arrive in batches (e.g. the
+// rows appended to a followed file), keeping the state of the stateful rules and Detectors between the batches
+type Detection struct {
+	policies []Policy
+	detectors []Detector
+	sequences *sequenceStore
+}
+
+// NewDetection creates a Detection of the Policies, and optionally of Detectors which run alongside them
+func NewDetection(policies []Policy, detectors ...Detector) *Detection {
+	if thresholds := newThresholdStore(policies); thresholds != nil {
+		detectors = append([]Detector{thresholds}, detectors...)
+	}
+	return &Detection{policies: policies, detectors: detectors, sequences: newSequenceStore(policies)}
+}
+
+// Observe a batch of Connections, returning the DetectionResult of the batch
+func (d *Detection) Observe(conns []Connection) DetectionResult {
+	result := DetectionResult{
 		RuleCount: map[string]int{},
 	}
 
-	runDetection(result, policies, conns)
-	result.CleanCount = len(conns) - len(result.Suspicious)
-	return *result
-}
-
-func runDetection(d *DetectionResult, policies []Policy, conns []Connection) {
-	for _, conn := range conns {
-		suspect := false
-		ignore := false
-		policyMatched := false
-		for _, policy := range policies {
-			if policy.Matches(conn) {
-				policyMatched = true
-				d.RuleCount[policy.Name] += 1
-				if policy.Verdict == InspectVerdict {
-					suspect = true
-				} else if policy.Verdict == IgnoreVerdict {
-					ignore = true
-				}
+	for i, conn := range conns {
+		for _, detector := range d.detectors {
+			result.Alerts = append(result.Alerts, detector.Observe(conn)...)
+		}
+
+		eval := Evaluate(d.policies, conn)
+		if d.sequences != nil {
+			result.Alerts = append(result.Alerts, d.sequences.observe(conn, eval.Matched)...)
+		}
+		for _, policy := range eval.Matched {
+			result.RuleCount[policy.Name] += 1
+		}
+		_, tags, techniques := RuleMetadata(eval.Matched)
+		for _, tag := range tags {
+			if result.TagCount == nil {
+				result.TagCount = map[string]int{}
+			}
+			result.TagCount[tag] += 1
+		}
+		for _, technique := range techniques {
+			if result.TechniqueCount == nil {
+				result.TechniqueCount = map[string]int{}
 			}
+			result.TechniqueCount[technique] += 1
 		}
 
-		if !policyMatched {
-			d.NoMatchCount += 1
+		if len(eval.Matched) == 0 {
+			result.NoMatchCount += 1
 		}
 
-		if suspect && !ignore {
-			d.Suspicious = append(d.Suspicious, conn)
+		result.Verdicts = append(result.Verdicts, eval.Verdict)
+		if eval.Verdict == SuspiciousVerdict {
+			result.Suspicious = append(result.Suspicious, conn)
+			result.SuspiciousIndices = append(result.SuspiciousIndices, i)
+			result.SuspiciousMatched = append(result.SuspiciousMatched, eval.Matched)
 		}
 	}
+	result.CleanCount = len(conns) - len(result.Suspicious)
+	return result
 }
 
+// Flush is called once the stream of Connections has ended, and returns the Alerts of the Detectors which can only be
+// raised by looking at the stream as a whole
+func (d *Detection) Flush() []Alert {
+	var alerts []Alert
+	for _, detector := range d.detectors {
+		alerts = append(alerts, detector.Flush()...)
+	}
+	return alerts
+}
+
+// Report returns the Alerts of the Detectors which are only raised by looking at the stream as a whole, for the
+// Connections observed so far, without ending the stream (see Reporter). The same Alerts are returned again by the
+// following calls, and by Flush.
+func (d *Detection) Report() []Alert {
+	var alerts []Alert
+	for _, detector := range d.detectors {
+		if reporter, ok := detector.(Reporter); ok {
+			alerts = append(alerts, reporter.Report()...)
+		}
+	}
+	return alerts
+}
 
+// Add the counts, suspicious Connections and Alerts of another DetectionResult (e.g. of the next batch of a Detection)
+func (d *DetectionResult) Add(other DetectionResult) {
+	d.Suspicious = append(d.Suspicious, other.Suspicious...)
+	// The indices of the other DetectionResult follow the Connections observed by this one
+	for _, index := range other.SuspiciousIndices {
+		d.SuspiciousIndices = append(d.SuspiciousIndices, len(d.Verdicts)+index)
+	}
+	d.SuspiciousMatched = append(d.SuspiciousMatched, other.SuspiciousMatched...)
+	d.Verdicts = append(d.Verdicts, other.Verdicts...)
+	if d.RuleCount == nil {
+		d.RuleCount = map[string]int{}
+	}
+	for key, count := range other.RuleCount {
+		d.RuleCount[key] += count
+	}
+	for key, count := range other.TagCount {
+		if d.TagCount == nil {
+			d.TagCount = map[string]int{}
+		}
+		d.TagCount[key] += count
+	}
+	for key, count := range other.TechniqueCount {
+		if d.TechniqueCount == nil {
+			d.TechniqueCount = map[string]int{}
+		}
+		d.TechniqueCount[key] += count
+	}
+	d.NoMatchCount += other.NoMatchCount
+	d.CleanCount += other.CleanCount
+	d.Alerts = append(d.Alerts, other.Alerts...)
+}
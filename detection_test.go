@@ -2,6 +2,7 @@ package engine_test
 
 import (
 	"net"
+	"path/filepath"
 	"testing"
 
 	"github.com/sclevine/spec"
@@ -45,7 +46,7 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 						Verdict: "INSPECT",
 					},
 				}, connections)
-				assert.Equal(t, detector, engine.DetectionResult{CleanCount: 1, RuleCount: map[string]int{}, NoMatchCount: 1})
+				assert.Equal(t, detector, engine.DetectionResult{CleanCount: 1, RuleCount: map[string]int{}, NoMatchCount: 1, Verdicts: []string{engine.CleanVerdict}})
 			})
 		})
 
@@ -62,14 +63,15 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 					},
 				}
 
-				detector := engine.DetectAttacks([]engine.Policy{
+				policies := []engine.Policy{
 					{
 						ID: "c36049aa-f2b3-11ea-aa02-0050569de26b",
 						Name: "inspect Martin's laptop",
 						IPMap: map[string]interface{}{"192.0.0.3":nil},
 						Verdict: "INSPECT",
 					},
-				}, connections)
+				}
+				detector := engine.DetectAttacks(policies, connections)
 
 				assert.Equal(t, engine.DetectionResult{
 					Suspicious: connections,
@@ -77,6 +79,9 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 					RuleCount: map[string]int{
 						"inspect Martin's laptop":1,
 					},
+					Verdicts: []string{engine.SuspiciousVerdict},
+					SuspiciousIndices: []int{0},
+					SuspiciousMatched: [][]engine.Policy{policies},
 				}, detector)
 			})
 		})
@@ -108,6 +113,7 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 					RuleCount: map[string]int{
 						"inspect Martin's laptop":1,
 					},
+					Verdicts: []string{engine.CleanVerdict},
 				}, detector)
 			})
 		})
@@ -146,6 +152,7 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 						"inspect Martin's laptop":1,
 						"inspect Martin's laptop2":1,
 					},
+					Verdicts: []string{engine.CleanVerdict},
 				}, detector)
 			})
 		})
@@ -203,7 +210,7 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 					},
 				}
 
-				detector := engine.DetectAttacks([]engine.Policy{
+				policies := []engine.Policy{
 					{
 						ID: "1",
 						Name: "inspect Martin's laptop",
@@ -222,7 +229,8 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 						ProtocolMap: map[string]interface{}{"UDP":nil},
 						Verdict: "INSPECT",
 					},
-				}, connections)
+				}
+				detector := engine.DetectAttacks(policies, connections)
 
 				assert.Equal(t, engine.DetectionResult{
 					CleanCount: 4,
@@ -250,8 +258,92 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 							Protocol: "UDP",
 						},
 					},
+					Verdicts: []string{engine.SuspiciousVerdict, engine.CleanVerdict, engine.CleanVerdict, engine.CleanVerdict, engine.SuspiciousVerdict, engine.CleanVerdict},
+					SuspiciousIndices: []int{0, 4},
+					SuspiciousMatched: [][]engine.Policy{{policies[0]}, {policies[0], policies[2]}},
 				}, detector)
 			})
 		})
+
+		when("rules have tags and techniques", func() {
+			it("counts the connections of each tag and technique", func() {
+				connections := []engine.Connection{
+					{Source: net.ParseIP("192.0.0.3"), SourcePort: 5000, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 22, Protocol: "TCP"},
+					{Source: net.ParseIP("192.0.0.3"), SourcePort: 5001, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 3389, Protocol: "TCP"},
+					{Source: net.ParseIP("192.0.0.4"), SourcePort: 5002, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 443, Protocol: "TCP"},
+				}
+
+				detector := engine.DetectAttacks([]engine.Policy{
+					{
+						ID: "1",
+						Name: "inspect SSH",
+						Ports: []engine.Port{{Start: 22, End: 22}},
+						Verdict: "INSPECT",
+						Tags: []string{"remote-access"},
+						Techniques: []string{"T1021.004"},
+					},
+					{
+						ID: "2",
+						Name: "inspect admin ports",
+						Ports: []engine.Port{{Start: 22, End: 22}, {Start: 3389, End: 3389}},
+						Verdict: "INSPECT",
+						Tags: []string{"remote-access", "admin"},
+					},
+				}, connections)
+
+				assert.Equal(t, map[string]int{"remote-access": 2, "admin": 2}, detector.TagCount)
+				assert.Equal(t, map[string]int{"T1021.004": 1}, detector.TechniqueCount)
+			})
+		})
 	})
-}
\ No newline at end of file
+	when("#NewDetection", func() {
+		it("keeps the state of stateful rules between batches, and adds up their results", func() {
+			policies, err := engine.PolicyReader{}.Read(filepath.Join(testdataPath, "threshold_policy.json"))
+			assert.Nil(t, err)
+			ssh := func(timestamp string, port int) engine.Connection {
+				return engine.Connection{Timestamp: timestamp, Source: net.ParseIP("192.0.0.3"), SourcePort: port, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 22, Protocol: "TCP"}
+			}
+
+			detection := engine.NewDetection(policies[:1])
+			first := detection.Observe([]engine.Connection{ssh("1599665118", 5000), ssh("1599665119", 5001)})
+			assert.Empty(t, first.Alerts)
+			second := detection.Observe([]engine.Connection{ssh("1599665120", 5002), ssh("1599665121", 5003)})
+			assert.Len(t, second.Alerts, 1)
+			assert.Empty(t, detection.Flush())
+
+			first.Add(second)
+			assert.Equal(t, 4, first.CleanCount)
+			assert.Len(t, first.Alerts, 1)
+		})
+
+		it("adds up the verdicts of the batches, with the indices of the suspicious connections among all of them", func() {
+			policies := []engine.Policy{{ID: "inspect-ssh", Name: "inspect SSH", Ports: []engine.Port{{Start: 22, End: 22}}, Verdict: engine.InspectVerdict}}
+			connection := func(port int) engine.Connection {
+				return engine.Connection{Timestamp: "1599665118", Source: net.ParseIP("192.0.0.3"), SourcePort: 5000, Destination: net.ParseIP("10.0.0.2"), DestinationPort: port, Protocol: "TCP"}
+			}
+
+			detection := engine.NewDetection(policies)
+			results := detection.Observe([]engine.Connection{connection(22), connection(80)})
+			results.Add(detection.Observe([]engine.Connection{connection(443), connection(22)}))
+			assert.Equal(t, []string{engine.SuspiciousVerdict, engine.CleanVerdict, engine.CleanVerdict, engine.SuspiciousVerdict}, results.Verdicts)
+			assert.Equal(t, []int{0, 3}, results.SuspiciousIndices)
+			assert.Equal(t, [][]engine.Policy{policies, policies}, results.SuspiciousMatched)
+		})
+
+		it("reports the alerts of the stream so far, without ending it", func() {
+			beacon := func(timestamp string) engine.Connection {
+				return engine.Connection{Timestamp: timestamp, Source: net.ParseIP("192.0.0.3"), SourcePort: 5000, Destination: net.ParseIP("10.0.0.1"), DestinationPort: 443, Protocol: "TCP"}
+			}
+
+			detection := engine.NewDetection(nil, engine.NewBeaconDetector(3, 0.9, 0.1, nil))
+			detection.Observe([]engine.Connection{beacon("1000"), beacon("1060"), beacon("1120")})
+			assert.Len(t, detection.Report(), 1)
+			detection.Observe([]engine.Connection{beacon("1180")})
+			alerts := detection.Report()
+			assert.Len(t, alerts, 1)
+			assert.Equal(t, 4, alerts[0].Count)
+			assert.Equal(t, alerts, detection.Flush())
+			assert.Empty(t, detection.Flush())
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"sort"
+	"time"
+)
+
+// Detector runs over the stream of Connections alongside the policy engine, and raises Alerts for patterns which span
+// several Connections, and so can't be expressed as a rule.
+// Connections are expected to be observed in timestamp order.
+type Detector interface {
+	// Observe a single Connection, returning the Alerts which it triggered
+	Observe(conn Connection) []Alert
+	// Flush is called once the stream of Connections has ended, and returns the Alerts which can only be raised by
+	// looking at the stream as a whole
+	Flush() []Alert
+}
+
+// Reporter is a Detector whose Alerts are only raised by looking at the stream as a whole, but whose Flush doesn't end
+// its state, so that the Alerts of a stream which hasn't ended (e.g. a followed file) can be reported along the way
+type Reporter interface {
+	Detector
+	// Report returns the Alerts of the Connections observed so far, which are the same as those of Flush
+	Report() []Alert
+}
+
+// maxDetectorGroups bounds the amount of groups tracked by a single detector (or threshold rule), so that a stream of
+// many distinct sources can't grow its state indefinitely
+var maxDetectorGroups = 100000
+
+// windowGroup is the state a Detector keeps for a single group of Connections within its window
+type windowGroup interface {
+	// last returns the timestamp of the latest Connection in the group
+	last() time.Time
+}
+
+// evictGroups removes the groups which have no Connections left in the window. If that isn't enough to get back under
+// maxDetectorGroups, the least recently seen groups are removed as well, trading some accuracy for bounded memory.
+func evictGroups(groups map[string]windowGroup, start time.Time) {
+	for key, group := range groups {
+		if group.last().Before(start) {
+			delete(groups, key)
+		}
+	}
+
+	excess := len(groups) - maxDetectorGroups*3/4
+	if excess <= 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return groups[keys[i]].last().Before(groups[keys[j]].last())
+	})
+	for _, key := range keys[:excess] {
+		delete(groups, key)
+	}
+}
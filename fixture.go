@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Fixture describes a single policy unit-test: a connection, and the verdict (and optionally the rule IDs) it is
+// expected to produce when evaluated against a policy.
+type Fixture struct {
+	Name       string
+	Connection Connection
+	Verdict    string
+	// MatchedRules is nil when the fixture doesn't make any assertion about the matched rules
+	MatchedRules []string
+}
+
+// FixtureResult is the outcome of running a single Fixture against a policy
+type FixtureResult struct {
+	Fixture  Fixture
+	Actual   Evaluation
+	Failures []string
+}
+
+// Passed returns true if the Fixture's expectations were all met
+func (f FixtureResult) Passed() bool {
+	return len(f.Failures) == 0
+}
+
+// FixtureReader reads policy unit-test fixtures from a JSON file.
+// It intentionally mirrors the PolicyReader, and doesn't accept the path as an input to the struct creation.
+type FixtureReader struct{}
+
+// The connection is keyed by the same column names as the connections CSV header, so that it can be parsed by
+// NewConnection, and values are left untyped so ports may be written as either numbers or strings.
+type fixtureJson struct {
+	Name         string                 `json:"name"`
+	Connection   map[string]interface{} `json:"connection"`
+	Verdict      string                 `json:"verdict"`
+	MatchedRules []string               `json:"matched_rules,omitempty"`
+}
+
+// DefaultFixturePath returns the fixture file expected to sit next to a policy file,
+// e.g. `data/policy.json` becomes `data/policy_test.json`
+func DefaultFixturePath(policyPath string) string {
+	ext := filepath.Ext(policyPath)
+	return strings.TrimSuffix(policyPath, ext) + "_test" + ext
+}
+
+// Read a fixtures `.json` file, and returns a Fixture slice
+func (f FixtureReader) Read(path string) ([]Fixture, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read fixture file")
+	}
+
+	// Numbers are kept as written, so that a timestamp isn't reformatted (e.g. to 1.599665118593452e+09)
+	var fixturesJson []fixtureJson
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err = decoder.Decode(&fixturesJson); err != nil {
+		// Unlike the policy, a malformed fixture file should fail loudly, as it would otherwise silently pass CI
+		return nil, errors.Wrap(err, "failed to parse fixture file")
+	}
+
+	var fixtures []Fixture
+	for i, fix := range fixturesJson {
+		name := fix.Name
+		if name == "" {
+			name = fmt.Sprintf("%s[%d]", filepath.Base(path), i)
+		}
+
+		verdict := strings.ToUpper(fix.Verdict)
+		if verdict != CleanVerdict && verdict != SuspiciousVerdict {
+			return nil, errors.Errorf("fixture '%s' has invalid verdict '%s', expected %s or %s", name, fix.Verdict, CleanVerdict, SuspiciousVerdict)
+		}
+
+		row := make([]string, len(headerRow))
+		for j, column := range headerRow {
+			if value, ok := fix.Connection[column]; ok && value != nil {
+				row[j] = fmt.Sprintf("%v", value)
+			}
+		}
+
+		fixtures = append(fixtures, Fixture{
+			Name:         name,
+			Connection:   NewConnection(row),
+			Verdict:      verdict,
+			MatchedRules: fix.MatchedRules,
+		})
+	}
+
+	return fixtures, nil
+}
+
+// RunFixtures evaluates each Fixture against a Policy slice, and returns the result for each of them
+func RunFixtures(policies []Policy, fixtures []Fixture) []FixtureResult {
+	var results []FixtureResult
+	for _, fixture := range fixtures {
+		result := FixtureResult{
+			Fixture: fixture,
+			Actual:  Evaluate(policies, fixture.Connection),
+		}
+
+		if result.Actual.Verdict != fixture.Verdict {
+			result.Failures = append(result.Failures, fmt.Sprintf("verdict: expected %s, got %s", fixture.Verdict, result.Actual.Verdict))
+		}
+
+		if fixture.MatchedRules != nil {
+			var actualIDs []string
+			for _, policy := range result.Actual.Matched {
+				actualIDs = append(actualIDs, policy.ID)
+			}
+			result.Failures = append(result.Failures, diffRuleIDs(fixture.MatchedRules, actualIDs)...)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// diffRuleIDs compares the expected and actual matched rule IDs, ignoring order, and describes each difference
+func diffRuleIDs(expected, actual []string) []string {
+	expectedSet := map[string]interface{}{}
+	for _, id := range expected {
+		expectedSet[id] = nil
+	}
+	actualSet := map[string]interface{}{}
+	for _, id := range actual {
+		actualSet[id] = nil
+	}
+
+	var diffs []string
+	for id := range expectedSet {
+		if _, ok := actualSet[id]; !ok {
+			diffs = append(diffs, fmt.Sprintf("matched rules: - %s (expected, but didn't match)", id))
+		}
+	}
+	for id := range actualSet {
+		if _, ok := expectedSet[id]; !ok {
+			diffs = append(diffs, fmt.Sprintf("matched rules: + %s (matched, but wasn't expected)", id))
+		}
+	}
+
+	// Maps have a random iteration order, so this keeps the output stable between runs
+	sort.Strings(diffs)
+	return diffs
+}
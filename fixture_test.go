@@ -0,0 +1,110 @@
+package engine_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestFixtures(t *testing.T) {
+	spec.Run(t, "Fixtures", testFixtures, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testFixtures(t *testing.T, when spec.G, it spec.S) {
+	var fixtureReader = engine.FixtureReader{}
+
+	when("#DefaultFixturePath", func() {
+		it("sits next to the policy file", func() {
+			assert.Equal(t, filepath.Join("data", "policy_test.json"), engine.DefaultFixturePath(filepath.Join("data", "policy.json")))
+		})
+	})
+
+	when("#Read", func() {
+		when("fixture file doesn't exist", func() {
+			it("returns a clear error", func() {
+				_, err := fixtureReader.Read(filepath.Join("/tmp", "path", "does-not-exist"))
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), "failed to read fixture file")
+			})
+		})
+
+		when("fixture has an invalid verdict", func() {
+			it("returns a clear error", func() {
+				_, err := fixtureReader.Read(filepath.Join(testdataPath, "invalid_fixtures.json"))
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), "fixture 'bad' has invalid verdict 'MAYBE'")
+			})
+		})
+
+		when("fixture file", func() {
+			it("parses the connections with the connections CSV schema", func() {
+				fixtures, err := fixtureReader.Read(filepath.Join(testdataPath, "fixture_policy_test.json"))
+				assert.Nil(t, err)
+				assert.Equal(t, 3, len(fixtures))
+				assert.Equal(t, engine.Fixture{
+					Name: "ssh from the laptop is suspicious",
+					Connection: engine.Connection{
+						Timestamp:       "1599665118.593452",
+						Source:          net.ParseIP("192.0.0.3"),
+						SourcePort:      45040,
+						Destination:     net.ParseIP("192.0.0.2"),
+						DestinationPort: 22,
+						Protocol:        "TCP",
+					},
+					Verdict:      engine.SuspiciousVerdict,
+					MatchedRules: []string{"inspect-ssh", "inspect-laptop"},
+				}, fixtures[0])
+				assert.Equal(t, engine.CleanVerdict, fixtures[1].Verdict)
+				assert.Nil(t, fixtures[1].MatchedRules)
+			})
+
+			it("keeps a numeric timestamp as written, so that it can be parsed", func() {
+				fixtures, err := fixtureReader.Read(filepath.Join(testdataPath, "numeric_fixtures.json"))
+				assert.Nil(t, err)
+				assert.Equal(t, 1, len(fixtures))
+				assert.Equal(t, "1599665118.593452", fixtures[0].Connection.Timestamp)
+				assert.Equal(t, 22, fixtures[0].Connection.DestinationPort)
+				ts, err := fixtures[0].Connection.Time()
+				assert.Nil(t, err)
+				assert.Equal(t, int64(1599665118), ts.Unix())
+			})
+		})
+	})
+
+	when("#RunFixtures", func() {
+		var policies []engine.Policy
+
+		it.Before(func() {
+			var err error
+			policies, err = engine.PolicyReader{}.Read(filepath.Join(testdataPath, "fixture_policy.json"))
+			assert.Nil(t, err)
+		})
+
+		it("passes when the expectations are met", func() {
+			fixtures, err := fixtureReader.Read(filepath.Join(testdataPath, "fixture_policy_test.json"))
+			assert.Nil(t, err)
+			for _, result := range engine.RunFixtures(policies, fixtures) {
+				assert.True(t, result.Passed(), "%s: %v", result.Fixture.Name, result.Failures)
+			}
+		})
+
+		it("describes the verdict and rule differences", func() {
+			fixtures, err := fixtureReader.Read(filepath.Join(testdataPath, "failing_fixtures.json"))
+			assert.Nil(t, err)
+			results := engine.RunFixtures(policies, fixtures)
+			assert.Equal(t, 1, len(results))
+			assert.False(t, results[0].Passed())
+			assert.Equal(t, []string{
+				"verdict: expected CLEAN, got SUSPICIOUS",
+				"matched rules: + inspect-laptop (matched, but wasn't expected)",
+				"matched rules: - inspect-ssh (expected, but didn't match)",
+			}, results[0].Failures)
+		})
+	})
+}
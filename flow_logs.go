@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// awsDefaultFields are the fields of the default (version 2) format of AWS VPC Flow Logs, which are read when a log
+// has no header (e.g. when it was exported from CloudWatch Logs)
+var awsDefaultFields = []string{"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status"}
+
+// awsFields maps the fields of an AWS VPC flow log to the fields of a Connection, which are named as in the default
+// header. The rest of the log's fields are kept in the Connection's Fields.
+var awsFields = map[string]string{
+	"start":    "timestamp",
+	"srcaddr":  "source",
+	"srcport":  "source_port",
+	"dstaddr":  "destination",
+	"dstport":  "destination_port",
+	"protocol": "protocol",
+}
+
+// awsUnsetField is the value of the fields an AWS flow log record doesn't have (e.g. the addresses of a NODATA record)
+var awsUnsetField = "-"
+
+// The statuses of the AWS flow log records which hold no traffic, and are skipped
+var (
+	awsNoData   = "NODATA"
+	awsSkipData = "SKIPDATA"
+)
+
+// gcpJSONKey is a field only the log entries of GCP have, which tells a GCP flow log apart from other JSON Lines files
+var gcpJSONKey = "jsonPayload"
+
+// gcpFields maps the fields of the jsonPayload of a GCP VPC flow log (or firewall log) entry to the fields of a
+// Connection. The rest of its fields are kept in the Connection's Fields, named by their path (e.g.
+// `src_instance.vm_name`).
+var gcpFields = map[string]string{
+	"start_time":           "timestamp",
+	"connection.src_ip":    "source",
+	"connection.src_port":  "source_port",
+	"connection.dest_ip":   "destination",
+	"connection.dest_port": "destination_port",
+	"connection.protocol":  "protocol",
+}
+
+// gcpRenamedFields are the fields of a GCP entry which are kept under the names of the same fields of an AWS flow log,
+// so that a policy's fields cover both
+var gcpRenamedFields = map[string]string{
+	"bytes_sent":   "bytes",
+	"packets_sent": "packets",
+	"disposition":  "action",
+}
+
+// gcpActions maps the dispositions of a GCP firewall log to the actions of an AWS flow log
+var gcpActions = map[string]string{
+	"ALLOWED": "ACCEPT",
+	"DENIED":  "REJECT",
+}
+
+// isAWSFlowLog peeks at the beginning of a stream, and returns true if it begins with the header of an AWS VPC flow log
+func isAWSFlowLog(r *bufio.Reader) bool {
+	peeked, _ := r.Peek(4096)
+	if end := bytes.IndexByte(peeked, '\n'); end >= 0 {
+		peeked = peeked[:end]
+	}
+	return isAWSHeader(strings.Fields(string(peeked)))
+}
+
+// isAWSHeader returns true if the fields of a line are the header of an AWS flow log, whose records never hold the
+// name of a field
+func isAWSHeader(fields []string) bool {
+	return containsString(fields, "srcaddr") && containsString(fields, "dstaddr")
+}
+
+// isJSONArray peeks at the beginning of a stream, and returns true if it begins with a JSON array, as the entries of a
+// GCP log are exported (e.g. by `gcloud logging read --format json`)
+func isJSONArray(r *bufio.Reader) bool {
+	return peekFirstByte(r) == '['
+}
+
+// readAWSFlowLog reads the Connections of an AWS VPC flow log, whose space separated fields are named by its header (of
+// a default or custom format), or are the default fields when it has none. The records of NODATA and SKIPDATA
+// intervals are skipped, and so are the headers of logs which were concatenated.
+func (c ConnectionsReadWriter) readAWSFlowLog(r io.Reader) ([]Connection, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+
+	fields := awsDefaultFields
+	var connections []Connection
+	skipped := map[string]int{}
+	for line := 1; scanner.Scan(); line++ {
+		values := strings.Fields(scanner.Text())
+		if len(values) == 0 {
+			continue
+		}
+		if isAWSHeader(values) {
+			fields = values
+			continue
+		}
+		// The lines exported from CloudWatch Logs may begin with the time they were ingested
+		if len(values) == len(fields)+1 {
+			if _, err := time.Parse(time.RFC3339Nano, values[0]); err == nil {
+				values = values[1:]
+			}
+		}
+		if len(values) != len(fields) {
+			return connections, errors.Errorf("line %d has %d field(s), but the header has %d", line, len(values), len(fields))
+		}
+
+		named := map[string]string{}
+		for i, value := range values {
+			if value != awsUnsetField {
+				named[fields[i]] = value
+			}
+		}
+		if status := named["log-status"]; status == awsNoData || status == awsSkipData {
+			skipped[status]++
+			continue
+		}
+
+		conn, err := awsConnection(named)
+		if err != nil {
+			return connections, errors.Wrapf(err, "line %d", line)
+		}
+		conn.Origin = c.origin(line)
+		connections = append(connections, conn)
+	}
+	if skipped[awsNoData] > 0 || skipped[awsSkipData] > 0 {
+		log.Printf("Skipped %d NODATA and %d SKIPDATA flow log record(s), which hold no traffic \n", skipped[awsNoData], skipped[awsSkipData])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return connections, errors.Wrap(err, "failed to read AWS flow log")
+	}
+	return connections, nil
+}
+
+// awsConnection creates a Connection from the named fields of an AWS flow log record, whose protocol number is named as
+// the rules name it (e.g. `6` is `TCP`)
+func awsConnection(named map[string]string) (Connection, error) {
+	row, err := mappedRow(named, awsFields)
+	if err != nil {
+		return Connection{}, err
+	}
+	row[indexOf(headerRow, "protocol")] = protocolOf(row[indexOf(headerRow, "protocol")])
+
+	conn := NewConnection(row)
+	conn.Fields = unmappedFields(named, awsFields)
+	return conn, nil
+}
+
+// readGCPArray reads the Connections of a JSON array of GCP log entries, see gcpConnection
+func (c ConnectionsReadWriter) readGCPArray(r io.Reader) ([]Connection, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if _, err := decoder.Token(); err != nil {
+		return nil, errors.Wrap(err, "failed to read GCP flow log")
+	}
+
+	var connections []Connection
+	for entry := 1; decoder.More(); entry++ {
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			return connections, errors.Wrapf(err, "entry %d isn't a valid JSON object", entry)
+		}
+		conn, err := gcpConnection(object)
+		if err != nil {
+			return connections, errors.Wrapf(err, "entry %d", entry)
+		}
+		conn.Origin = c.origin(entry)
+		connections = append(connections, conn)
+	}
+	return connections, nil
+}
+
+// gcpConnection creates a Connection from a GCP VPC flow log (or firewall log) entry. The flow starts at its start_time,
+// or at the entry's timestamp, and its bytes, packets and disposition are kept as the bytes, packets and action of an
+// AWS flow log.
+func gcpConnection(object map[string]interface{}) (Connection, error) {
+	payload, ok := object[gcpJSONKey].(map[string]interface{})
+	if !ok {
+		return Connection{}, errors.Errorf("has no %s object", gcpJSONKey)
+	}
+	named := map[string]string{}
+	flattenJSON("", payload, named)
+	if _, ok := named["start_time"]; !ok && object["timestamp"] != nil {
+		named["start_time"] = stringOrEmpty(object["timestamp"])
+	}
+
+	row, err := mappedRow(named, gcpFields)
+	if err != nil {
+		return Connection{}, err
+	}
+	timestamp := row[indexOf(headerRow, "timestamp")]
+	if ts, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		row[indexOf(headerRow, "timestamp")] = epochTimestamp(ts)
+	}
+	row[indexOf(headerRow, "protocol")] = protocolOf(row[indexOf(headerRow, "protocol")])
+
+	conn := NewConnection(row)
+	conn.Fields = unmappedFields(named, gcpFields)
+	for from, to := range gcpRenamedFields {
+		if value, ok := conn.Fields[from]; ok {
+			delete(conn.Fields, from)
+			conn.Fields[to] = value
+		}
+	}
+	if action, ok := gcpActions[conn.Fields["action"]]; ok {
+		conn.Fields["action"] = action
+	}
+	return conn, nil
+}
+
+// flattenJSON names the values of a JSON object by their path, joining the names of nested objects with dots, and the
+// values of arrays with commas
+func flattenJSON(prefix string, value interface{}, named map[string]string) {
+	switch typed := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, v := range typed {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenJSON(key, v, named)
+		}
+	case []interface{}:
+		var values []string
+		for _, v := range typed {
+			values = append(values, stringOrEmpty(v))
+		}
+		named[prefix] = strings.Join(values, ",")
+	default:
+		named[prefix] = stringOrEmpty(value)
+	}
+}
+
+// mappedRow returns the CSV row of the named fields which are mapped to the fields of a Connection, or an error naming
+// the required ones which are missing
+func mappedRow(named map[string]string, mapping map[string]string) ([]string, error) {
+	row := make([]string, len(headerRow))
+	var missing []string
+	for name, field := range mapping {
+		value, ok := named[name]
+		if !ok {
+			if containsString(requiredFields, field) {
+				missing = append(missing, name)
+			}
+			continue
+		}
+		row[indexOf(headerRow, field)] = value
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return nil, errors.Errorf("is missing the %s field(s)", strings.Join(missing, ", "))
+	}
+	return row, nil
+}
+
+// unmappedFields returns the named fields which aren't mapped to the fields of a Connection, or nil if there are none
+func unmappedFields(named map[string]string, mapping map[string]string) map[string]string {
+	var fields map[string]string
+	for name, value := range named {
+		if _, ok := mapping[name]; ok {
+			continue
+		}
+		if fields == nil {
+			fields = map[string]string{}
+		}
+		fields[name] = value
+	}
+	return fields
+}
+
+// protocolOf names a protocol number as the rules name it, and upper cases a protocol which is already named
+func protocolOf(protocol string) string {
+	if number, err := strconv.Atoi(protocol); err == nil {
+		return protocolName(number)
+	}
+	return strings.ToUpper(protocol)
+}
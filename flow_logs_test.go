@@ -0,0 +1,174 @@
+package engine_test
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestFlowLogs(t *testing.T) {
+	spec.Run(t, "FlowLogs", testFlowLogs, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testFlowLogs(t *testing.T, when spec.G, it spec.S) {
+	var connectionRW = engine.ConnectionsReadWriter{}
+
+	when("AWS VPC flow logs", func() {
+		awsRW := engine.ConnectionsReadWriter{InputFormat: engine.AWSFormat}
+		expected := []engine.Connection{
+			{
+				Timestamp:       "1599665118",
+				Source:          net.ParseIP("192.0.0.2"),
+				SourcePort:      5000,
+				Destination:     net.ParseIP("192.128.0.32"),
+				DestinationPort: 22,
+				Protocol:        "TCP",
+				Fields: map[string]string{
+					"version":      "2",
+					"account-id":   "123456789010",
+					"interface-id": "eni-1235b8ca123456789",
+					"packets":      "20",
+					"bytes":        "4249",
+					"end":          "1599665178",
+					"action":       "ACCEPT",
+					"log-status":   "OK",
+				},
+			},
+			{
+				Timestamp:   "1599665119",
+				Source:      net.ParseIP("192.0.0.2"),
+				Destination: net.ParseIP("192.128.0.33"),
+				Protocol:    "ICMP",
+				Fields: map[string]string{
+					"version":      "2",
+					"account-id":   "123456789010",
+					"interface-id": "eni-1235b8ca123456789",
+					"packets":      "4",
+					"bytes":        "336",
+					"end":          "1599665179",
+					"action":       "REJECT",
+					"log-status":   "OK",
+				},
+			},
+		}
+
+		it("detects a log with a header, and skips its NODATA and SKIPDATA records", func() {
+			connections, err := connectionRW.Read(filepath.Join(testdataPath, "aws_flow.log"))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, connections)
+		})
+
+		it("reads the default fields of a log without a header, which may begin with the time it was ingested", func() {
+			log := "2 123456789010 eni-1235b8ca123456789 192.0.0.2 192.128.0.32 5000 22 6 20 4249 1599665118 1599665178 ACCEPT OK\n" +
+				"2020-09-09T15:26:20.000Z 2 123456789010 eni-1235b8ca123456789 192.0.0.2 192.128.0.33 - - 1 4 336 1599665119 1599665179 REJECT OK\n"
+			connections, err := awsRW.ReadStream(strings.NewReader(log))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, connections)
+		})
+
+		it("reads the fields of a custom format, in the order of its header", func() {
+			log := "interface-id dstaddr srcaddr protocol start action tcp-flags\n" +
+				"eni-1235b8ca123456789 192.128.0.32 192.0.0.2 17 1599665118 ACCEPT -\n"
+			connections, err := connectionRW.ReadStream(strings.NewReader(log))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{{
+				Timestamp:   "1599665118",
+				Source:      net.ParseIP("192.0.0.2"),
+				Destination: net.ParseIP("192.128.0.32"),
+				Protocol:    "UDP",
+				Fields:      map[string]string{"interface-id": "eni-1235b8ca123456789", "action": "ACCEPT"},
+			}}, connections)
+		})
+
+		it("returns an error for a record which doesn't match the header", func() {
+			log := "srcaddr dstaddr protocol start\n192.0.0.2 192.128.0.32 6\n"
+			_, err := connectionRW.ReadStream(strings.NewReader(log))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 2 has 3 field(s), but the header has 4")
+		})
+
+		it("returns an error for a custom format without the required fields", func() {
+			log := "srcaddr dstaddr start\n192.0.0.2 192.128.0.32 1599665118\n"
+			_, err := connectionRW.ReadStream(strings.NewReader(log))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 2: is missing the protocol field(s)")
+		})
+	})
+
+	when("GCP VPC flow logs", func() {
+		expected := []engine.Connection{
+			{
+				Timestamp:       "1599665118.593452",
+				Source:          net.ParseIP("192.0.0.2"),
+				SourcePort:      5000,
+				Destination:     net.ParseIP("192.128.0.32"),
+				DestinationPort: 22,
+				Protocol:        "TCP",
+				Fields: map[string]string{
+					"bytes":                   "4249",
+					"packets":                 "20",
+					"reporter":                "SRC",
+					"end_time":                "2020-09-09T15:26:18.593452Z",
+					"src_instance.project_id": "estate",
+					"src_instance.vm_name":    "bastion",
+					"src_instance.zone":       "us-east1-b",
+				},
+			},
+			{
+				Timestamp:   "1599665119.104211",
+				Source:      net.ParseIP("192.0.0.2"),
+				Destination: net.ParseIP("192.128.0.33"),
+				Protocol:    "ICMP",
+				Fields: map[string]string{
+					"action":                 "REJECT",
+					"rule_details.reference": "network:default/firewall:deny-icmp",
+					"rule_details.action":    "DENY",
+				},
+			},
+		}
+
+		it("detects a JSON array of log entries, keeping the bytes, packets and disposition as AWS names them", func() {
+			connections, err := connectionRW.Read(filepath.Join(testdataPath, "gcp_flows.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, connections)
+		})
+
+		it("reads the entries of JSON Lines", func() {
+			log := `{"jsonPayload":{"connection":{"src_ip":"192.0.0.2","dest_ip":"192.128.0.33","protocol":1},"disposition":"DENIED",` +
+				`"rule_details":{"reference":"network:default/firewall:deny-icmp","action":"DENY"}},"timestamp":"2020-09-09T15:25:19.104211Z"}` + "\n"
+			gcpRW := engine.ConnectionsReadWriter{InputFormat: engine.GCPFormat}
+			connections, err := gcpRW.ReadStream(bytes.NewReader([]byte(log)))
+			assert.Nil(t, err)
+			assert.Equal(t, expected[1:], connections)
+		})
+
+		it("returns an error for an entry without a connection", func() {
+			_, err := connectionRW.ReadStream(strings.NewReader(`[{"jsonPayload":{"reporter":"SRC"}}]`))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "entry 1: is missing the connection.dest_ip, connection.protocol, connection.src_ip, start_time field(s)")
+		})
+	})
+
+	when("matched by a policy", func() {
+		it("matches the actions of both AWS and GCP flows", func() {
+			policies, err := engine.PolicyReader{}.Read(filepath.Join(testdataPath, "flow_log_policy.json"))
+			assert.Nil(t, err)
+			aws, err := connectionRW.Read(filepath.Join(testdataPath, "aws_flow.log"))
+			assert.Nil(t, err)
+			gcp, err := connectionRW.Read(filepath.Join(testdataPath, "gcp_flows.json"))
+			assert.Nil(t, err)
+			for _, connections := range [][]engine.Connection{aws, gcp} {
+				assert.Equal(t, engine.CleanVerdict, engine.Evaluate(policies, connections[0]).Verdict)
+				assert.Equal(t, engine.SuspiciousVerdict, engine.Evaluate(policies, connections[1]).Verdict)
+			}
+		})
+	})
+}
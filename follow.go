@@ -0,0 +1,376 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSummaryInterval is how often a followed run logs the summary of the connections it analyzed since the last one
+var DefaultSummaryInterval = 5 * time.Minute
+
+// followParser parses the lines appended to a followed file. The leading lines of the file which aren't connections
+// (e.g. a CSV header, or the header of a Zeek log) are kept, and are read before each batch of lines, so that the
+// columns of every batch are mapped the same.
+type followParser struct {
+	rw ConnectionsReadWriter
+	header []byte
+	// started is set once a line of the file was a connection, and the lines which follow aren't part of its header
+	started bool
+}
+
+// reset forgets the header, once the followed file was truncated or rotated and begins with its own header
+func (p *followParser) reset() {
+	p.header, p.started = nil, false
+}
+
+// parse returns the Connections of the lines. An improper line is skipped, rather than the lines after it, and the
+// returned error describes the skipped lines.
+func (p *followParser) parse(lines []byte) ([]Connection, error) {
+	var connections []Connection
+	var skipped []error
+	for !p.started && len(lines) != 0 {
+		var line []byte
+		line, lines = splitLine(lines)
+
+		conns, err := p.read(line)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		if len(conns) == 0 {
+			p.header = append(p.header, line...)
+			continue
+		}
+		p.started = true
+		connections = conns
+	}
+
+	if len(lines) != 0 {
+		conns, err := p.read(lines)
+		if err == nil {
+			connections = append(connections, conns...)
+		} else {
+			// An improper line fails the whole batch, so the batch is read again line by line, which only skips the
+			// improper lines (and splits the rows with quoted line breaks of such a batch)
+			for len(lines) != 0 {
+				var line []byte
+				line, lines = splitLine(lines)
+				conns, err := p.read(line)
+				if err != nil {
+					skipped = append(skipped, err)
+					continue
+				}
+				connections = append(connections, conns...)
+			}
+		}
+	}
+
+	if len(skipped) != 0 {
+		return connections, errors.Wrapf(skipped[0], "%d improper line(s) were skipped, the first", len(skipped))
+	}
+	return connections, nil
+}
+
+// read returns the Connections of the lines, which are read after the header
+func (p *followParser) read(lines []byte) ([]Connection, error) {
+	return p.rw.ReadStream(bytes.NewReader(append(append([]byte{}, p.header...), lines...)))
+}
+
+// splitLine returns the first line of the lines, with its line break, and the lines after it
+func splitLine(lines []byte) ([]byte, []byte) {
+	end := bytes.IndexByte(lines, '\n') + 1
+	if end == 0 {
+		end = len(lines)
+	}
+	return lines[:end], lines[end:]
+}
+
+// followOutput appends the rows of each batch to an output of a followed run, which is created by its first rows
+type followOutput struct {
+	rw ConnectionsReadWriter
+	path string
+	// name is the name of the output in its status message, e.g. `suspicious connections`
+	name string
+	w io.Writer
+	file *os.File
+}
+
+func (o *followOutput) write(connections []Connection, columns ...Column) error {
+	if len(connections) == 0 {
+		return nil
+	}
+	if o.w == nil {
+		if o.rw.Format == "" && o.path != StdStream {
+			o.rw.Format = formatOf(o.path)
+		}
+		if o.path == StdStream {
+			fmt.Fprintf(statusOutput, "Writing %s to stdout\n", o.name)
+			o.w = os.Stdout
+		} else {
+			fmt.Fprintf(statusOutput, "Writing %s file to %s\n", o.name, o.path)
+			if err := os.MkdirAll(filepath.Dir(o.path), os.ModePerm); err != nil {
+				return errors.Wrapf(err, "creating directory %s", filepath.Dir(o.path))
+			}
+			file, err := os.Create(o.path)
+			if err != nil {
+				return errors.Wrapf(err, "creating file %s", o.path)
+			}
+			o.w, o.file = file, file
+		}
+	}
+
+	err := o.rw.WriteStream(o.w, connections, columns...)
+	// The header was written with the first rows, and the rows which follow are appended to it
+	o.rw.OmitHeader = true
+	return err
+}
+
+func (o *followOutput) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	return errors.Wrapf(o.file.Close(), "closing file %s", o.path)
+}
+
+// followNetworkAnalysis follows the connections file, analyzing the rows appended to it as they arrive, until the
+// context is done. The suspicious connections are written (and the alerts raised) as soon as their rows are read,
+// while the summary of the analysis is logged periodically, rather than once.
+func followNetworkAnalysis(ctx context.Context, opts analysisOptions) error {
+	if followInterval <= 0 || summaryInterval <= 0 {
+		return errors.New("--follow-interval and --summary-interval must be positive")
+	}
+	paths, err := ExpandInputs(opts.connectionsPaths)
+	if err != nil {
+		return err
+	}
+	if len(paths) != 1 {
+		return errors.Errorf("--follow follows a single connections file, rather than %d", len(paths))
+	}
+	connectionsPath := paths[0]
+	if connectionsPath == StdStream {
+		return errors.New("--follow needs a connections file, rather than stdin")
+	}
+	if opts.graphPath != "" || sortByScore || opts.anomalies != nil {
+		return errors.New("--graph-output, --sort-by-score and --anomaly-suspicious need all the connections, so they can't be used with --follow")
+	}
+	if inputFormat == NetFlowFormat || inputFormat == PcapFormat {
+		return errors.Errorf("--follow only reads connections files of lines, rather than %s files", inputFormat)
+	}
+	for _, path := range []string{opts.outputPath, opts.suppressedPath} {
+		if ext := filepath.Ext(path); ext == GzipExtension || ext == ZstdExtension {
+			return errors.Errorf("--follow appends to its outputs, so it can't write the compressed %s", path)
+		}
+	}
+
+	a, err := newAnalysis(opts)
+	if err != nil {
+		return err
+	}
+	var suppressor *Suppressor
+	if suppressionsPath != "" {
+		if suppressor, err = readSuppressor(); err != nil {
+			return err
+		}
+	}
+
+	follower := &Follower{Path: connectionsPath, Interval: followInterval}
+	defer follower.Close()
+	output := &followOutput{rw: ConnectionsReadWriter{Format: outputFormat}, path: opts.outputPath, name: "suspicious connections"}
+	defer output.Close()
+	suppressedOutput := &followOutput{path: opts.suppressedPath, name: "suppressed connections"}
+	defer suppressedOutput.Close()
+	alerts := &followAlerts{path: opts.alertsPath}
+	defer alerts.Close()
+
+	f := &followedRun{analysis: a, opts: opts, detection: NewDetection(a.policies, opts.detectors...), suppressor: suppressor,
+		output: output, suppressedOutput: suppressedOutput, alerts: alerts, jsonOutput: isJSONOutput(opts.outputPath), since: time.Now()}
+	parser := &followParser{rw: a.connectionsRW}
+	log.Printf("Following %s, with a summary every %s \n", connectionsPath, summaryInterval)
+
+	for {
+		next, cancel := context.WithDeadline(ctx, f.since.Add(summaryInterval))
+		lines, newFile, err := follower.Next(next)
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+		if err == context.DeadlineExceeded {
+			if err := f.summarize(); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if newFile {
+			parser.reset()
+		}
+		connections, err := parser.parse(lines)
+		if err != nil {
+			log.Printf("Improper lines were appended to %s: %s \n", connectionsPath, err)
+		}
+		if err := f.analyze(connections); err != nil {
+			return err
+		}
+		// A file which is appended to more often than the follow interval never waits until the deadline
+		if time.Since(f.since) >= summaryInterval {
+			if err := f.summarize(); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Stopped following %s \n", connectionsPath)
+	if err := f.addReported(f.detection.Flush()); err != nil {
+		return err
+	}
+	return f.summarize()
+}
+
+// followAlerts appends the Alerts of a followed run to its alerts file as JSON Lines, rather than rewriting the file
+// with all the Alerts so far. The file is created by the first Alerts.
+type followAlerts struct {
+	path string
+	file *os.File
+}
+
+func (a *followAlerts) write(alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	if a.file == nil {
+		fmt.Fprintf(statusOutput, "Writing alerts file to %s\n", a.path)
+		if err := os.MkdirAll(filepath.Dir(a.path), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "creating directory %s", filepath.Dir(a.path))
+		}
+		file, err := os.Create(a.path)
+		if err != nil {
+			return errors.Wrapf(err, "creating file %s", a.path)
+		}
+		a.file = file
+	}
+
+	encoder := json.NewEncoder(a.file)
+	for _, alert := range alerts {
+		if err := encoder.Encode(alert); err != nil {
+			return errors.Wrapf(err, "writing file %s", a.path)
+		}
+	}
+	return nil
+}
+
+func (a *followAlerts) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return errors.Wrapf(a.file.Close(), "closing file %s", a.path)
+}
+
+// followedRun is the state of a followed run, which is kept between the batches of rows
+type followedRun struct {
+	analysis
+	opts analysisOptions
+	detection *Detection
+	suppressor *Suppressor
+	output *followOutput
+	suppressedOutput *followOutput
+	jsonOutput bool
+	alerts *followAlerts
+	// reported holds the type and key of the Alerts which were reported by the Detectors along the way, which are
+	// returned again by each report (see Reporter), and are only added once
+	reported map[string]bool
+	// results and suppressed are the DetectionResult and the amount of suppressed connections since the last summary
+	results DetectionResult
+	suppressed int
+	since time.Time
+}
+
+// analyze a batch of Connections, writing their suspicious ones (or all of them, with --output-all) and their alerts
+func (f *followedRun) analyze(connections []Connection) error {
+	results := f.detection.Observe(connections)
+	f.results.Add(results)
+	if err := f.addAlerts(results.Alerts); err != nil {
+		return err
+	}
+
+	suspicious, indices := results.Suspicious, results.SuspiciousIndices
+	var reasons []string
+	if f.suppressor != nil {
+		var suppressed []Connection
+		var suppressedBy []string
+		suspicious, indices, reasons, suppressed, suppressedBy = suppressConnections(f.suppressor, f.policies, suspicious, indices, reasons)
+		f.suppressed += len(suppressed)
+		if err := f.suppressedOutput.write(suppressed, suppressionColumn(suppressedBy)); err != nil {
+			return err
+		}
+	}
+
+	output := suspicious
+	verdicts := make([]string, len(suspicious))
+	for i := range verdicts {
+		verdicts[i] = SuspiciousVerdict
+	}
+	if outputAll {
+		output, verdicts, reasons = withUnsuspicious(connections, results.Verdicts, indices, reasons)
+	}
+	rows := newOutputRows(f.policies, output, verdicts, reasons, f.scorer, f.incidents)
+	return f.output.write(rows.connections, rows.columns(f.jsonOutput)...)
+}
+
+// addAlerts logs the new Alerts as they are raised, and appends them to the alerts file
+func (f *followedRun) addAlerts(alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	for _, alert := range alerts {
+		log.Printf("* %s\n", alert)
+	}
+	f.scorer.AddAlerts(alerts)
+	return f.alerts.write(alerts)
+}
+
+// addReported adds the Alerts of a report of the Detectors, leaving out those which were already reported
+func (f *followedRun) addReported(alerts []Alert) error {
+	if f.reported == nil {
+		f.reported = map[string]bool{}
+	}
+	var added []Alert
+	for _, alert := range alerts {
+		key := alert.Type + "\x00" + alert.Key
+		if !f.reported[key] {
+			f.reported[key] = true
+			added = append(added, alert)
+		}
+	}
+	f.results.Alerts = append(f.results.Alerts, added...)
+	return f.addAlerts(added)
+}
+
+// summarize logs the summary of the connections since the last summary, together with the Alerts the Detectors report
+// for the stream so far, and writes the incidents so far
+func (f *followedRun) summarize() error {
+	if err := f.addReported(f.detection.Report()); err != nil {
+		return err
+	}
+	log.Printf("\nResults of the last %s:\n", time.Since(f.since).Round(time.Second))
+	logResults(f.results, f.skipped)
+	if f.suppressor != nil {
+		log.Printf("* %d suspicious connection(s) were suppressed\n", f.suppressed)
+		logSuppressions(f.suppressor)
+	}
+	log.Printf("* The suspicious connections were grouped into %d incident(s)\n", len(f.incidents.Incidents()))
+	f.results, f.suppressed, f.since = DetectionResult{}, 0, time.Now()
+
+	incidentsWriter := IncidentsWriter{}
+	return incidentsWriter.Write(f.incidents.Incidents(), f.opts.incidentsPath)
+}
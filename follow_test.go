@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollow(t *testing.T) {
+	spec.Run(t, "Follow", testFollow, spec.Report(report.Terminal{}))
+}
+
+func testFollow(t *testing.T, when spec.G, it spec.S) {
+	when("#followParser", func() {
+		it("reads each batch with the header of the file", func() {
+			parser := &followParser{}
+			connections, err := parser.parse([]byte("src_port,protocol,dst,src,ts\n5000,TCP,192.128.0.32,192.0.0.2,1599665118\n"))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 1)
+
+			connections, err = parser.parse([]byte("5001,UDP,192.128.0.33,192.0.0.2,1599665119\n"))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 1)
+			assert.Equal(t, "192.128.0.33", connections[0].Destination.String())
+			assert.Equal(t, 5001, connections[0].SourcePort)
+
+			// A file without a header is read by the default columns, once the header of the last one is reset
+			parser.reset()
+			connections, err = parser.parse([]byte("1599665120,192.0.0.2,5002,192.128.0.34,22,TCP\n"))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 1)
+			assert.Equal(t, 22, connections[0].DestinationPort)
+		})
+
+		it("skips only the improper lines of a batch", func() {
+			parser := &followParser{}
+			connections, err := parser.parse([]byte("timestamp,source,source_port,destination,destination_port,protocol\n" +
+				"1599665118,192.0.0.2,5000,192.128.0.32,22,TCP\n" +
+				"1599665119,192.0.0.2\n" +
+				"1599665120,192.0.0.2,5002,192.128.0.34,22,TCP\n"))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "1 improper line(s) were skipped")
+			assert.Len(t, connections, 2)
+			assert.Equal(t, "192.128.0.34", connections[1].Destination.String())
+
+			connections, err = parser.parse([]byte("1599665121,192.0.0.2,5003,192.128.0.35,22,TCP\n"))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 1)
+		})
+
+		it("keeps the leading lines of a Zeek log, which arrive before its first connection", func() {
+			parser := &followParser{}
+			connections, err := parser.parse([]byte("#separator \\x09\n#unset_field\t-\n"))
+			assert.Nil(t, err)
+			assert.Empty(t, connections)
+
+			connections, err = parser.parse([]byte("#fields\tts\tid.orig_h\tid.orig_p\tid.resp_h\tid.resp_p\tproto\n1599665118.593452\t192.0.0.2\t5000\t192.128.0.32\t-\ttcp\n"))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 1)
+			assert.Equal(t, "TCP", connections[0].Protocol)
+			assert.Equal(t, 0, connections[0].DestinationPort)
+		})
+	})
+
+	when("#followOutput", func() {
+		it("names its output in the status message", func() {
+			dir, err := ioutil.TempDir("", "follow")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+			status := &bytes.Buffer{}
+			statusOutput = status
+			defer func() { statusOutput = os.Stdout }()
+
+			output := &followOutput{path: filepath.Join(dir, "suppressed.csv"), name: "suppressed connections"}
+			defer output.Close()
+			assert.Nil(t, output.write([]Connection{NewConnection([]string{"1599665118", "192.0.0.2", "5000", "192.128.0.32", "22", "TCP"})}))
+			assert.Equal(t, "Writing suppressed connections file to "+output.path+"\n", status.String())
+		})
+	})
+
+	when("#followNetworkAnalysis", func() {
+		it("writes the suspicious connections of the appended rows as they arrive, until it is stopped", func() {
+			dir, err := ioutil.TempDir("", "follow")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+			followInterval = time.Millisecond
+			defer func() { followInterval = DefaultFollowInterval }()
+
+			connectionsPath := filepath.Join(dir, "connections.csv")
+			assert.Nil(t, ioutil.WriteFile(connectionsPath, []byte("timestamp,source,source_port,destination,destination_port,protocol\n"+
+				"1599665118,192.0.0.2,5000,192.128.0.32,22,TCP\n"), 0644))
+			opts := analysisOptions{
+				policyPath: filepath.Join("testdata", "fixture_policy.json"),
+				connectionsPaths: []string{connectionsPath},
+				outputPath: filepath.Join(dir, "suspicious.csv"),
+				alertsPath: filepath.Join(dir, "alerts.json"),
+				incidentsPath: filepath.Join(dir, "incidents.json"),
+				suppressedPath: filepath.Join(dir, "suppressed.csv"),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- followNetworkAnalysis(ctx, opts)
+			}()
+			waitForLines := func(lines int) string {
+				for i := 0; i < 1000; i++ {
+					content, _ := ioutil.ReadFile(opts.outputPath)
+					if strings.Count(string(content), "\n") >= lines {
+						return string(content)
+					}
+					time.Sleep(time.Millisecond)
+				}
+				return ""
+			}
+			assert.Contains(t, waitForLines(2), "1599665118,192.0.0.2,5000,192.128.0.32,22,TCP")
+
+			f, err := os.OpenFile(connectionsPath, os.O_APPEND|os.O_WRONLY, 0644)
+			assert.Nil(t, err)
+			_, err = f.WriteString("1599665119,192.0.0.5,5001,192.128.0.32,443,TCP\n1599665120,192.0.0.3,5002,192.128.0.32,443,TCP\n")
+			assert.Nil(t, err)
+			assert.Nil(t, f.Close())
+
+			output := waitForLines(3)
+			cancel()
+			assert.Nil(t, <-done)
+
+			// The header is only written once, and the clean connection isn't written at all
+			assert.Equal(t, 1, strings.Count(output, "timestamp,source"))
+			assert.NotContains(t, output, "192.0.0.5")
+			assert.Contains(t, output, "1599665120,192.0.0.3,5002,192.128.0.32,443,TCP")
+			_, err = os.Stat(opts.incidentsPath)
+			assert.Nil(t, err)
+		})
+
+		it("reports the alerts of the whole stream with each summary, only once", func() {
+			dir, err := ioutil.TempDir("", "follow")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+
+			scorer, err := NewScorer(WeightedScore, 1, nil, nil)
+			assert.Nil(t, err)
+			incidents, err := NewIncidentGrouper([]string{SourceIncidentKey}, time.Minute)
+			assert.Nil(t, err)
+			alertsPath := filepath.Join(dir, "alerts.json")
+			f := &followedRun{analysis: analysis{scorer: scorer, incidents: incidents},
+				opts: analysisOptions{alertsPath: alertsPath, incidentsPath: filepath.Join(dir, "incidents.json")},
+				detection: NewDetection(nil, NewBeaconDetector(3, 0.9, 0.1, nil)), output: &followOutput{path: filepath.Join(dir, "suspicious.csv")},
+				alerts: &followAlerts{path: alertsPath}, since: time.Now()}
+			defer f.alerts.Close()
+			alerts := func() []string {
+				content, _ := ioutil.ReadFile(alertsPath)
+				return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			}
+			beacon := func(timestamps ...string) []Connection {
+				var connections []Connection
+				for _, ts := range timestamps {
+					connections = append(connections, NewConnection([]string{ts, "192.0.0.3", "5000", "10.0.0.1", "443", "TCP"}))
+				}
+				return connections
+			}
+
+			assert.Nil(t, f.analyze(beacon("1000", "1060")))
+			assert.Nil(t, f.summarize())
+			_, err = os.Stat(alertsPath)
+			assert.True(t, os.IsNotExist(err))
+
+			assert.Nil(t, f.analyze(beacon("1120", "1180")))
+			assert.Nil(t, f.summarize())
+			assert.Len(t, alerts(), 1)
+			var alert Alert
+			assert.Nil(t, json.Unmarshal([]byte(alerts()[0]), &alert))
+			assert.Equal(t, BeaconAlert, alert.Type)
+
+			assert.Nil(t, f.analyze(beacon("1240")))
+			assert.Nil(t, f.summarize())
+			assert.Nil(t, f.addReported(f.detection.Flush()))
+			assert.Len(t, alerts(), 1)
+		})
+
+		it("returns an error for the options which need all the connections", func() {
+			err := followNetworkAnalysis(context.Background(), analysisOptions{connectionsPaths: []string{"connections.csv"}, graphPath: "graph.dot"})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "can't be used with --follow")
+
+			err = followNetworkAnalysis(context.Background(), analysisOptions{connectionsPaths: []string{StdStream}})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "--follow needs a connections file")
+
+			err = followNetworkAnalysis(context.Background(), analysisOptions{connectionsPaths: []string{"first.csv", "second.csv"}})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "--follow follows a single connections file, rather than 2")
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultFollowInterval is how often a followed file is checked for new lines, see Follower.Interval
+var DefaultFollowInterval = time.Second
+
+// maxFollowRead is the most a Follower reads from its file at once, so that a large backlog is read in several batches
+var maxFollowRead = 1024 * 1024
+
+// Follower tails a growing file like `tail -F`, returning the lines which are appended to it. It survives the file
+// being truncated (reading it again from its start), and being rotated (reading the rest of the old file, then the new
+// file from its start), and waits for a file which doesn't exist yet.
+type Follower struct {
+	Path string
+	// Interval is how often the file is checked for new lines, and is the DefaultFollowInterval when it is zero
+	Interval time.Duration
+	file *os.File
+	offset int64
+	// partial is the last line read, until the rest of it is appended
+	partial []byte
+	// fresh is set while no lines were returned from the file since it was opened or truncated
+	fresh bool
+	buf []byte
+}
+
+// Next waits until complete lines are appended to the file, and returns them together with true if they begin a new
+// file (the first one, or one which was truncated or rotated), whose header must be read again. It returns the
+// context's error once it is done, and may be called again with another context to keep following the file.
+func (f *Follower) Next(ctx context.Context) ([]byte, bool, error) {
+	interval := f.Interval
+	if interval == 0 {
+		interval = DefaultFollowInterval
+	}
+
+	for {
+		if f.file == nil {
+			file, err := os.Open(f.Path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, false, errors.Wrapf(err, "failed to follow %s", f.Path)
+			}
+			if err == nil {
+				f.file, f.offset, f.partial, f.fresh = file, 0, nil, true
+			}
+		}
+
+		if f.file != nil {
+			newFile := f.fresh
+			if lines, err := f.read(); err != nil || len(lines) != 0 {
+				f.fresh = false
+				return lines, newFile, err
+			}
+
+			rotated, err := f.rotated()
+			if err != nil {
+				return nil, false, err
+			}
+			if rotated {
+				// The old file was read to its end, and its last line is complete even without a newline
+				f.file.Close()
+				f.file = nil
+				if len(f.partial) != 0 {
+					return append(f.partial, '\n'), newFile, nil
+				}
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Close closes the followed file
+func (f *Follower) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// read returns the complete lines which were appended since the last read, keeping the partial line which follows them
+func (f *Follower) read() ([]byte, error) {
+	if f.buf == nil {
+		f.buf = make([]byte, maxFollowRead)
+	}
+	n, err := io.ReadFull(f.file, f.buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, errors.Wrapf(err, "failed to follow %s", f.Path)
+	}
+	f.offset += int64(n)
+	data := append(f.partial, f.buf[:n]...)
+
+	end := bytes.LastIndexByte(data, '\n') + 1
+	f.partial = append([]byte{}, data[end:]...)
+	return data[:end], nil
+}
+
+// rotated returns true if the path no longer holds the followed file (it was moved or removed). A file which was
+// truncated is read again from its start.
+func (f *Follower) rotated() (bool, error) {
+	current, err := f.file.Stat()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to follow %s", f.Path)
+	}
+	latest, err := os.Stat(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, errors.Wrapf(err, "failed to follow %s", f.Path)
+	}
+	if !os.SameFile(current, latest) {
+		return true, nil
+	}
+
+	if current.Size() < f.offset {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return false, errors.Wrapf(err, "failed to follow %s", f.Path)
+		}
+		f.offset, f.partial, f.fresh = 0, nil, true
+	}
+	return false, nil
+}
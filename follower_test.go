@@ -0,0 +1,128 @@
+package engine_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestFollower(t *testing.T) {
+	spec.Run(t, "Follower", testFollower, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testFollower(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dir string
+		path string
+		follower *engine.Follower
+	)
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "follower")
+		assert.Nil(t, err)
+		path = filepath.Join(dir, "connections.csv")
+		follower = &engine.Follower{Path: path, Interval: time.Millisecond}
+	})
+
+	it.After(func() {
+		assert.Nil(t, follower.Close())
+		assert.Nil(t, os.RemoveAll(dir))
+	})
+
+	appendTo := func(name, content string) {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		_, err = f.WriteString(content)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+
+	next := func() (string, bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		lines, newFile, err := follower.Next(ctx)
+		return string(lines), newFile, err
+	}
+
+	it("waits for the file, and returns the complete lines appended to it", func() {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			appendTo("connections.csv", "header\nfirst\nsec")
+		}()
+		lines, newFile, err := next()
+		assert.Nil(t, err)
+		assert.Equal(t, "header\nfirst\n", lines)
+		assert.True(t, newFile)
+
+		appendTo("connections.csv", "ond\n")
+		lines, newFile, err = next()
+		assert.Nil(t, err)
+		assert.Equal(t, "second\n", lines)
+		assert.False(t, newFile)
+	})
+
+	it("returns the context's error once it is done, and keeps following the file after it", func() {
+		appendTo("connections.csv", "first\n")
+		_, _, err := next()
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, _, err = follower.Next(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		appendTo("connections.csv", "second\n")
+		lines, newFile, err := next()
+		assert.Nil(t, err)
+		assert.Equal(t, "second\n", lines)
+		assert.False(t, newFile)
+	})
+
+	it("reads a truncated file again from its start", func() {
+		appendTo("connections.csv", "header\nfirst\nsecond\n")
+		_, _, err := next()
+		assert.Nil(t, err)
+
+		assert.Nil(t, ioutil.WriteFile(path, []byte("header\n"), 0644))
+		lines, newFile, err := next()
+		assert.Nil(t, err)
+		assert.Equal(t, "header\n", lines)
+		assert.True(t, newFile)
+	})
+
+	it("reads the rest of a rotated file, and then the new file from its start", func() {
+		appendTo("connections.csv", "header\nfirst\n")
+		_, _, err := next()
+		assert.Nil(t, err)
+
+		appendTo("connections.csv", "second\nlast")
+		assert.Nil(t, os.Rename(path, filepath.Join(dir, "connections.csv.1")))
+		appendTo("connections.csv", "header\nthird\n")
+
+		lines, newFile, err := next()
+		assert.Nil(t, err)
+		assert.Equal(t, "second\n", lines)
+		assert.False(t, newFile)
+
+		// The last line of the rotated file is complete, even without a newline
+		lines, newFile, err = next()
+		assert.Nil(t, err)
+		assert.Equal(t, "last\n", lines)
+		assert.False(t, newFile)
+
+		lines, newFile, err = next()
+		assert.Nil(t, err)
+		assert.Equal(t, "header\nthird\n", lines)
+		assert.True(t, newFile)
+	})
+}
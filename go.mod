@@ -3,8 +3,10 @@ module github.com/dfreilich/guardicore-policy-engine
 go 1.14
 
 require (
+	github.com/klauspost/compress v1.11.13
 	github.com/pkg/errors v0.9.1
 	github.com/sclevine/spec v1.4.0
 	github.com/spf13/cobra v1.1.1
 	github.com/stretchr/testify v1.6.1
+	go.etcd.io/bbolt v1.3.6
 )
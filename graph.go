@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultInternalNetworks are the private and link-local ranges, which are considered internal unless configured
+// otherwise
+var DefaultInternalNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "fc00::/7", "fe80::/10"}
+
+// ParseNetworks parses a list of CIDRs, returning an error for the first invalid one
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid network %s", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Graph is a host communication graph, whose nodes are addresses, and whose edges are the Connections between them,
+// aggregated by destination port and protocol
+type Graph struct {
+	internal []*net.IPNet
+	nodes    map[string]*GraphNode
+	edges    map[string]*GraphEdge
+	// assets and subnetBits group the nodes, see WithGroups
+	assets     []Asset
+	subnetBits int
+}
+
+// GraphNode is a single address in the Graph
+type GraphNode struct {
+	Address     string `json:"address"`
+	Internal    bool   `json:"internal"`
+	Group       string `json:"group,omitempty"`
+	Connections int    `json:"connections"`
+	// OutPeers and InPeers are the amount of distinct addresses the node connected to, and was connected from
+	OutPeers int `json:"out_peers"`
+	InPeers  int `json:"in_peers"`
+
+	outPeers map[string]interface{}
+	inPeers  map[string]interface{}
+}
+
+// GraphEdge aggregates the Connections from a source to a destination, on a single port and protocol
+type GraphEdge struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Port        int    `json:"port"`
+	Protocol    string `json:"protocol"`
+	Count       int    `json:"count"`
+	FirstSeen   string `json:"first_seen"`
+	LastSeen    string `json:"last_seen"`
+	// Rules are the names of the rules matched by the edge's Connections, when they were added by AddMatched
+	Rules []string `json:"rules,omitempty"`
+
+	first time.Time
+	last  time.Time
+	// sample is the first Connection of the edge, which is kept as evidence for alerts
+	sample Connection
+}
+
+// NewGraph returns an empty Graph, in which addresses within the internal networks are marked as internal
+func NewGraph(internal []*net.IPNet) *Graph {
+	return &Graph{internal: internal, nodes: map[string]*GraphNode{}, edges: map[string]*GraphEdge{}}
+}
+
+// WithGroups groups the nodes which are added to the Graph by the label of the most specific Asset containing them, or
+// otherwise by their subnet, of subnetBits for IPv4 addresses (and /64 for IPv6 addresses). A subnetBits of 0 leaves
+// the nodes without an Asset ungrouped.
+func (g *Graph) WithGroups(assets []Asset, subnetBits int) *Graph {
+	g.assets = assets
+	g.subnetBits = subnetBits
+	return g
+}
+
+// IsInternal returns true if the address is within one of the Graph's internal networks
+func (g *Graph) IsInternal(ip net.IP) bool {
+	return containsIP(g.internal, ip)
+}
+
+// containsIP returns true if the address is within one of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a Connection to the Graph, returning its edge. Connections without a valid timestamp are counted, but don't
+// affect the time span of the edge.
+func (g *Graph) Add(conn Connection) *GraphEdge {
+	source := g.node(conn.Source)
+	destination := g.node(conn.Destination)
+	source.Connections++
+	source.outPeers[destination.Address] = nil
+	source.OutPeers = len(source.outPeers)
+	destination.inPeers[source.Address] = nil
+	destination.InPeers = len(destination.inPeers)
+
+	key := fmt.Sprintf("%s|%s|%d|%s", source.Address, destination.Address, conn.DestinationPort, conn.Protocol)
+	edge, ok := g.edges[key]
+	if !ok {
+		edge = &GraphEdge{
+			Source:      source.Address,
+			Destination: destination.Address,
+			Port:        conn.DestinationPort,
+			Protocol:    conn.Protocol,
+			sample:      conn,
+		}
+		g.edges[key] = edge
+	}
+	edge.Count++
+
+	if ts, err := conn.Time(); err == nil {
+		if edge.FirstSeen == "" || ts.Before(edge.first) {
+			edge.first, edge.FirstSeen = ts, conn.Timestamp
+		}
+		if edge.LastSeen == "" || ts.After(edge.last) {
+			edge.last, edge.LastSeen = ts, conn.Timestamp
+		}
+	}
+	return edge
+}
+
+// AddMatched adds a Connection to the Graph, together with the names of the Policies it matched
+func (g *Graph) AddMatched(conn Connection, matched []Policy) *GraphEdge {
+	edge := g.Add(conn)
+	for _, policy := range matched {
+		if !containsString(edge.Rules, policy.Name) {
+			edge.Rules = append(edge.Rules, policy.Name)
+		}
+	}
+	return edge
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// group returns the label of the most specific Asset containing the address, or otherwise its subnet
+func (g *Graph) group(ip net.IP) string {
+	if asset, ok := FindAsset(g.assets, ip); ok {
+		return asset.Label
+	}
+	if g.subnetBits <= 0 || ip == nil {
+		return ""
+	}
+
+	mask := net.CIDRMask(g.subnetBits, 32)
+	if ip.To4() == nil {
+		mask = net.CIDRMask(64, 128)
+	}
+	subnet := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	return subnet.String()
+}
+
+func (g *Graph) node(ip net.IP) *GraphNode {
+	address := ip.String()
+	node, ok := g.nodes[address]
+	if !ok {
+		node = &GraphNode{
+			Address:  address,
+			Internal: g.IsInternal(ip),
+			Group:    g.group(ip),
+			outPeers: map[string]interface{}{},
+			inPeers:  map[string]interface{}{},
+		}
+		g.nodes[address] = node
+	}
+	return node
+}
+
+// Nodes returns the nodes of the Graph, ordered by address
+func (g *Graph) Nodes() []*GraphNode {
+	nodes := make([]*GraphNode, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Address < nodes[j].Address
+	})
+	return nodes
+}
+
+// Edges returns the edges of the Graph, ordered by source, destination, port and protocol
+func (g *Graph) Edges() []*GraphEdge {
+	edges := make([]*GraphEdge, 0, len(g.edges))
+	for _, edge := range g.edges {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Destination != b.Destination {
+			return a.Destination < b.Destination
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Protocol < b.Protocol
+	})
+	return edges
+}
+
+// evictEdges removes the least recently seen edges, until only keep are left, and then the nodes left without edges.
+// The peer counts of the remaining nodes still include the peers of the removed edges.
+func (g *Graph) evictEdges(keep int) {
+	excess := len(g.edges) - keep
+	if excess <= 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(g.edges))
+	for key := range g.edges {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return g.edges[keys[i]].last.Before(g.edges[keys[j]].last)
+	})
+	for _, key := range keys[:excess] {
+		delete(g.edges, key)
+	}
+
+	linked := map[string]bool{}
+	for _, edge := range g.edges {
+		linked[edge.Source], linked[edge.Destination] = true, true
+	}
+	for address := range g.nodes {
+		if !linked[address] {
+			delete(g.nodes, address)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package engine_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestGraph(t *testing.T) {
+	spec.Run(t, "Graph", testGraph, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testGraph(t *testing.T, when spec.G, it spec.S) {
+	var graph *engine.Graph
+
+	it.Before(func() {
+		networks, err := engine.ParseNetworks([]string{"10.0.0.0/8"})
+		assert.Nil(t, err)
+		graph = engine.NewGraph(networks)
+
+		for _, conn := range []engine.Connection{
+			{Timestamp: "1000.5", Source: net.ParseIP("10.0.0.1"), SourcePort: 40000, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 22, Protocol: "TCP"},
+			{Timestamp: "1100.5", Source: net.ParseIP("10.0.0.1"), SourcePort: 40001, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 22, Protocol: "TCP"},
+			{Timestamp: "900.5", Source: net.ParseIP("10.0.0.1"), SourcePort: 40002, Destination: net.ParseIP("10.0.0.2"), DestinationPort: 22, Protocol: "TCP"},
+			{Timestamp: "1200.5", Source: net.ParseIP("10.0.0.1"), SourcePort: 40003, Destination: net.ParseIP("8.8.8.8"), DestinationPort: 53, Protocol: "UDP"},
+		} {
+			graph.Add(conn)
+		}
+	})
+
+	when("#ParseNetworks", func() {
+		it("returns a clear error for an invalid network", func() {
+			_, err := engine.ParseNetworks([]string{"10.0.0.0/8", "not-a-network"})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "invalid network not-a-network")
+		})
+	})
+
+	when("#Add", func() {
+		it("aggregates the connections into nodes and edges", func() {
+			assert.Equal(t, []*engine.GraphNode{
+				{Address: "10.0.0.1", Internal: true, Connections: 4, OutPeers: 2},
+				{Address: "10.0.0.2", Internal: true, InPeers: 1},
+				{Address: "8.8.8.8", Internal: false, InPeers: 1},
+			}, stripNodes(graph.Nodes()))
+
+			edges := graph.Edges()
+			assert.Equal(t, 2, len(edges))
+			assert.Equal(t, "10.0.0.2", edges[0].Destination)
+			assert.Equal(t, 22, edges[0].Port)
+			assert.Equal(t, 3, edges[0].Count)
+			assert.Equal(t, "900.5", edges[0].FirstSeen)
+			assert.Equal(t, "1100.5", edges[0].LastSeen)
+			assert.Equal(t, "8.8.8.8", edges[1].Destination)
+		})
+	})
+
+	when("GraphWriter#Write", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "graph")
+			assert.Nil(t, err)
+		})
+
+		it.After(func() {
+			assert.Nil(t, os.RemoveAll(tmpDir))
+		})
+
+		it("exports the nodes and edges", func() {
+			path := filepath.Join(tmpDir, "out", "graph.json")
+			assert.Nil(t, engine.GraphWriter{}.Write(graph, path))
+
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			var exported struct {
+				Nodes []map[string]interface{} `json:"nodes"`
+				Edges []map[string]interface{} `json:"edges"`
+			}
+			assert.Nil(t, json.Unmarshal(content, &exported))
+			assert.Equal(t, 3, len(exported.Nodes))
+			assert.Equal(t, 2, len(exported.Edges))
+			assert.Equal(t, map[string]interface{}{
+				"source":      "10.0.0.1",
+				"destination": "10.0.0.2",
+				"port":        float64(22),
+				"protocol":    "TCP",
+				"count":       float64(3),
+				"first_seen":  "900.5",
+				"last_seen":   "1100.5",
+			}, exported.Edges[0])
+		})
+	})
+}
+
+// stripNodes copies the exported fields of the nodes, so that they can be compared
+func stripNodes(nodes []*engine.GraphNode) []*engine.GraphNode {
+	var stripped []*engine.GraphNode
+	for _, node := range nodes {
+		stripped = append(stripped, &engine.GraphNode{
+			Address:     node.Address,
+			Internal:    node.Internal,
+			Connections: node.Connections,
+			OutPeers:    node.OutPeers,
+			InPeers:     node.InPeers,
+		})
+	}
+	return stripped
+}
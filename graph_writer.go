@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GraphWriter writes a Graph for further analysis, in a format chosen by the extension of the output path:
+// Graphviz DOT for `.dot` and `.gv`, GraphML for `.graphml`, and JSON otherwise.
+type GraphWriter struct {
+	// MinEdgeCount collapses the edges between a pair of nodes with fewer Connections than it into a single edge, to
+	// keep large graphs readable
+	MinEdgeCount int
+}
+
+type graphJson struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+// Write a Graph to the output path
+func (w GraphWriter) Write(graph *Graph, path string) error {
+	fmt.Fprintf(statusOutput, "Writing graph file to %s\n", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating directory %s", filepath.Dir(path))
+	}
+
+	nodes := graph.Nodes()
+	edges := collapseEdges(graph.Edges(), w.MinEdgeCount)
+
+	var content []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		content = toDOT(nodes, edges)
+	case ".graphml":
+		content, err = toGraphML(nodes, edges)
+	default:
+		content, err = json.MarshalIndent(graphJson{Nodes: nodes, Edges: edges}, "", "  ")
+	}
+	if err != nil {
+		return errors.Wrap(err, "marshaling graph")
+	}
+
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrapf(err, "writing file %s", path)
+	}
+
+	log.Println("Successfully wrote file.")
+	return nil
+}
+
+// collapseEdges merges the edges between each pair of nodes which have fewer than minCount Connections into a single
+// edge, whose protocol is `other`, and whose port is 0. A pair with a single such edge keeps it as is, since there is
+// nothing to merge it with.
+func collapseEdges(edges []*GraphEdge, minCount int) []*GraphEdge {
+	if minCount <= 1 {
+		return edges
+	}
+
+	low := map[string]int{}
+	for _, edge := range edges {
+		if edge.Count < minCount {
+			low[edge.Source+"|"+edge.Destination]++
+		}
+	}
+
+	var collapsed []*GraphEdge
+	others := map[string]*GraphEdge{}
+	for _, edge := range edges {
+		key := edge.Source + "|" + edge.Destination
+		if edge.Count >= minCount || low[key] < 2 {
+			collapsed = append(collapsed, edge)
+			continue
+		}
+
+		other, ok := others[key]
+		if !ok {
+			copied := *edge
+			copied.Port, copied.Protocol = 0, "other"
+			copied.Rules = append([]string{}, edge.Rules...)
+			others[key] = &copied
+			collapsed = append(collapsed, &copied)
+			continue
+		}
+
+		other.Count += edge.Count
+		if edge.FirstSeen != "" && (other.FirstSeen == "" || edge.first.Before(other.first)) {
+			other.first, other.FirstSeen = edge.first, edge.FirstSeen
+		}
+		if edge.LastSeen != "" && (other.LastSeen == "" || edge.last.After(other.last)) {
+			other.last, other.LastSeen = edge.last, edge.LastSeen
+		}
+		for _, rule := range edge.Rules {
+			if !containsString(other.Rules, rule) {
+				other.Rules = append(other.Rules, rule)
+			}
+		}
+	}
+	return collapsed
+}
+
+// label describes the edge in a single line, e.g. `22/TCP x3 (inspect SSH)`
+func (e GraphEdge) label() string {
+	label := fmt.Sprintf("%d/%s x%d", e.Port, e.Protocol, e.Count)
+	if e.Protocol == "other" && e.Port == 0 {
+		label = fmt.Sprintf("other ports x%d", e.Count)
+	}
+	if len(e.Rules) != 0 {
+		label = fmt.Sprintf("%s (%s)", label, strings.Join(e.Rules, ", "))
+	}
+	return label
+}
+
+// toDOT renders the nodes and edges as a Graphviz digraph, in which the nodes of each group are clustered together
+func toDOT(nodes []*GraphNode, edges []*GraphEdge) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("digraph connections {\n  rankdir=LR;\n  node [shape=box];\n")
+
+	groups := map[string][]*GraphNode{}
+	var groupNames []string
+	for _, node := range nodes {
+		if _, ok := groups[node.Group]; !ok {
+			groupNames = append(groupNames, node.Group)
+		}
+		groups[node.Group] = append(groups[node.Group], node)
+	}
+	sort.Strings(groupNames)
+
+	for i, group := range groupNames {
+		indent := "  "
+		if group != "" {
+			fmt.Fprintf(&buf, "  subgraph cluster_%d {\n    label=%s;\n", i, dotQuote(group))
+			indent = "    "
+		}
+		for _, node := range groups[group] {
+			style := ""
+			if !node.Internal {
+				style = " style=dashed"
+			}
+			fmt.Fprintf(&buf, "%s%s [label=%s%s];\n", indent, dotQuote(node.Address), dotQuote(node.Address), style)
+		}
+		if group != "" {
+			buf.WriteString("  }\n")
+		}
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&buf, "  %s -> %s [label=%s];\n", dotQuote(edge.Source), dotQuote(edge.Destination), dotQuote(edge.label()))
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func dotQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// toGraphML renders the nodes and edges as a GraphML document, in which the group of each node is kept as an attribute
+func toGraphML(nodes []*GraphNode, edges []*GraphEdge) ([]byte, error) {
+	doc := graphMLDocument{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "internal", For: "node", Name: "internal", Type: "boolean"},
+			{ID: "group", For: "node", Name: "group", Type: "string"},
+			{ID: "connections", For: "node", Name: "connections", Type: "int"},
+			{ID: "port", For: "edge", Name: "port", Type: "int"},
+			{ID: "protocol", For: "edge", Name: "protocol", Type: "string"},
+			{ID: "count", For: "edge", Name: "count", Type: "int"},
+			{ID: "rules", For: "edge", Name: "rules", Type: "string"},
+			{ID: "first_seen", For: "edge", Name: "first_seen", Type: "string"},
+			{ID: "last_seen", For: "edge", Name: "last_seen", Type: "string"},
+			{ID: "label", For: "edge", Name: "label", Type: "string"},
+		},
+		Graph: graphMLGraph{ID: "connections", EdgeDefault: "directed"},
+	}
+
+	for _, node := range nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: node.Address,
+			Data: []graphMLData{
+				{Key: "internal", Value: strconv.FormatBool(node.Internal)},
+				{Key: "group", Value: node.Group},
+				{Key: "connections", Value: strconv.Itoa(node.Connections)},
+			},
+		})
+	}
+
+	for i, edge := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     fmt.Sprintf("e%d", i),
+			Source: edge.Source,
+			Target: edge.Destination,
+			Data: []graphMLData{
+				{Key: "port", Value: strconv.Itoa(edge.Port)},
+				{Key: "protocol", Value: edge.Protocol},
+				{Key: "count", Value: strconv.Itoa(edge.Count)},
+				{Key: "rules", Value: strings.Join(edge.Rules, ", ")},
+				{Key: "first_seen", Value: edge.FirstSeen},
+				{Key: "last_seen", Value: edge.LastSeen},
+				{Key: "label", Value: edge.label()},
+			},
+		})
+	}
+
+	content, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
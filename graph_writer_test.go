@@ -0,0 +1,142 @@
+package engine_test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestGraphWriter(t *testing.T) {
+	spec.Run(t, "GraphWriter", testGraphWriter, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testGraphWriter(t *testing.T, when spec.G, it spec.S) {
+	var (
+		graph  *engine.Graph
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "graph-writer")
+		assert.Nil(t, err)
+
+		networks, err := engine.ParseNetworks([]string{"10.0.0.0/8"})
+		assert.Nil(t, err)
+		assets, err := engine.AssetReader{}.Read(filepath.Join(testdataPath, "assets.json"))
+		assert.Nil(t, err)
+		graph = engine.NewGraph(networks).WithGroups(assets, 24)
+
+		ssh := engine.Policy{ID: "1", Name: "inspect SSH", Verdict: engine.InspectVerdict}
+		for _, conn := range []engine.Connection{
+			{Timestamp: "1000", Source: net.ParseIP("10.0.1.1"), SourcePort: 40000, Destination: net.ParseIP("10.0.2.2"), DestinationPort: 22, Protocol: "TCP"},
+			{Timestamp: "1001", Source: net.ParseIP("10.0.1.1"), SourcePort: 40001, Destination: net.ParseIP("10.0.2.2"), DestinationPort: 22, Protocol: "TCP"},
+			{Timestamp: "1002", Source: net.ParseIP("10.0.1.1"), SourcePort: 40002, Destination: net.ParseIP("10.0.2.2"), DestinationPort: 80, Protocol: "TCP"},
+			{Timestamp: "1003", Source: net.ParseIP("10.0.1.1"), SourcePort: 40003, Destination: net.ParseIP("10.0.2.2"), DestinationPort: 53, Protocol: "UDP"},
+			{Timestamp: "1004", Source: net.ParseIP("10.0.1.1"), SourcePort: 40004, Destination: net.ParseIP("192.168.7.9"), DestinationPort: 443, Protocol: "TCP"},
+		} {
+			var matched []engine.Policy
+			if conn.DestinationPort == 22 {
+				matched = []engine.Policy{ssh}
+			}
+			graph.AddMatched(conn, matched)
+		}
+	})
+
+	it.After(func() {
+		assert.Nil(t, os.RemoveAll(tmpDir))
+	})
+
+	when("DOT output", func() {
+		it("labels the edges, and clusters the nodes by asset or subnet", func() {
+			path := filepath.Join(tmpDir, "graph.dot")
+			assert.Nil(t, engine.GraphWriter{}.Write(graph, path))
+
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Equal(t, `digraph connections {
+  rankdir=LR;
+  node [shape=box];
+  subgraph cluster_0 {
+    label="192.168.7.0/24";
+    "192.168.7.9" [label="192.168.7.9" style=dashed];
+  }
+  subgraph cluster_1 {
+    label="database servers";
+    "10.0.1.1" [label="10.0.1.1"];
+  }
+  subgraph cluster_2 {
+    label="datacenter";
+    "10.0.2.2" [label="10.0.2.2"];
+  }
+  "10.0.1.1" -> "10.0.2.2" [label="22/TCP x2 (inspect SSH)"];
+  "10.0.1.1" -> "10.0.2.2" [label="53/UDP x1"];
+  "10.0.1.1" -> "10.0.2.2" [label="80/TCP x1"];
+  "10.0.1.1" -> "192.168.7.9" [label="443/TCP x1"];
+}
+`, string(content))
+		})
+
+		it("collapses the low-volume edges between each pair, but keeps the port of a single one", func() {
+			path := filepath.Join(tmpDir, "graph.gv")
+			assert.Nil(t, engine.GraphWriter{MinEdgeCount: 2}.Write(graph, path))
+
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Contains(t, string(content), `"10.0.1.1" -> "10.0.2.2" [label="22/TCP x2 (inspect SSH)"];`)
+			assert.Contains(t, string(content), `"10.0.1.1" -> "10.0.2.2" [label="other ports x2"];`)
+			assert.Contains(t, string(content), `"10.0.1.1" -> "192.168.7.9" [label="443/TCP x1"];`)
+			assert.NotContains(t, string(content), "53/UDP")
+			assert.Equal(t, 1, strings.Count(string(content), "other ports"))
+		})
+	})
+
+	when("GraphML output", func() {
+		it("writes a valid document with the node and edge attributes", func() {
+			path := filepath.Join(tmpDir, "graph.graphml")
+			assert.Nil(t, engine.GraphWriter{}.Write(graph, path))
+
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			var doc struct {
+				Nodes []struct {
+					ID   string `xml:"id,attr"`
+					Data []struct {
+						Key   string `xml:"key,attr"`
+						Value string `xml:",chardata"`
+					} `xml:"data"`
+				} `xml:"graph>node"`
+				Edges []struct {
+					Source string `xml:"source,attr"`
+					Target string `xml:"target,attr"`
+					Data   []struct {
+						Key   string `xml:"key,attr"`
+						Value string `xml:",chardata"`
+					} `xml:"data"`
+				} `xml:"graph>edge"`
+			}
+			assert.Nil(t, xml.Unmarshal(content, &doc))
+			assert.Equal(t, 3, len(doc.Nodes))
+			assert.Equal(t, 4, len(doc.Edges))
+			assert.Equal(t, "10.0.1.1", doc.Edges[0].Source)
+			assert.Equal(t, "10.0.2.2", doc.Edges[0].Target)
+			values := map[string]string{}
+			for _, data := range doc.Edges[0].Data {
+				values[data.Key] = data.Value
+			}
+			assert.Equal(t, "22", values["port"])
+			assert.Equal(t, "2", values["count"])
+			assert.Equal(t, "inspect SSH", values["rules"])
+		})
+	})
+}
@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The fields suspicious Connections can be grouped into incidents by. The rule of a Connection is the set of INSPECT
+// rules it matched.
+var (
+	SourceIncidentKey      = "source"
+	DestinationIncidentKey = "destination"
+	RuleIncidentKey        = "rule"
+	PortIncidentKey        = "port"
+	ProtocolIncidentKey    = "protocol"
+)
+
+var incidentKeys = []string{SourceIncidentKey, DestinationIncidentKey, RuleIncidentKey, PortIncidentKey, ProtocolIncidentKey}
+
+// incidentSampleSize is the amount of Connections which are kept for each incident, as a sample of its rows
+var incidentSampleSize = 5
+
+// Incident groups related suspicious Connections, so they can be reviewed together rather than one by one
+type Incident struct {
+	ID string `json:"id"`
+	// Key describes what the incident was grouped by, e.g. `source 192.0.0.3, rule inspect SSH`
+	Key       string `json:"key"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+	Count     int    `json:"count"`
+	// Peers and Ports are the amount of distinct destinations and destination ports of the incident's Connections
+	Peers int      `json:"peers"`
+	Ports int      `json:"ports"`
+	Rules []string `json:"rules,omitempty"`
+	// Severity is the highest risk score of the incident's Connections
+	Severity float64      `json:"severity"`
+	Sample   []Connection `json:"sample"`
+
+	first time.Time
+	last  time.Time
+	peers map[string]interface{}
+	ports map[int]interface{}
+}
+
+// IncidentGrouper groups suspicious Connections with the same key into incidents, as long as each follows the previous
+// one within the inactivity gap
+type IncidentGrouper struct {
+	keys      []string
+	gap       time.Duration
+	incidents []*Incident
+	// open holds the latest incident of each key
+	open map[string]*Incident
+}
+
+// NewIncidentGrouper returns an IncidentGrouper, which groups by the given keys (e.g. `source` and `rule`), and starts
+// a new incident for a key once it was inactive for longer than gap
+func NewIncidentGrouper(keys []string, gap time.Duration) (*IncidentGrouper, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no incident keys")
+	}
+	for _, key := range keys {
+		if !containsString(incidentKeys, key) {
+			return nil, errors.Errorf("unknown incident key %s, expected one of %s", key, strings.Join(incidentKeys, ", "))
+		}
+	}
+	return &IncidentGrouper{keys: keys, gap: gap, open: map[string]*Incident{}}, nil
+}
+
+// Add a suspicious Connection, together with the Policies it matched and its risk score, and return the ID of the
+// incident it was grouped into. Connections are expected in timestamp order, and those without a valid timestamp join
+// the latest incident of their key.
+func (g *IncidentGrouper) Add(conn Connection, matched []Policy, severity float64) string {
+	var rules []string
+	for _, policy := range matched {
+		if policy.Verdict == InspectVerdict {
+			rules = append(rules, policy.Name)
+		}
+	}
+
+	key := g.key(conn, rules)
+	ts, err := conn.Time()
+	incident, ok := g.open[key]
+	if !ok || (err == nil && !incident.last.IsZero() && ts.Sub(incident.last) > g.gap) {
+		incident = &Incident{
+			ID:    fmt.Sprintf("incident-%d", len(g.incidents)+1),
+			Key:   key,
+			Rules: rules,
+			peers: map[string]interface{}{},
+			ports: map[int]interface{}{},
+		}
+		g.incidents = append(g.incidents, incident)
+		g.open[key] = incident
+	}
+
+	incident.Count++
+	incident.peers[conn.Destination.String()] = nil
+	incident.Peers = len(incident.peers)
+	incident.ports[conn.DestinationPort] = nil
+	incident.Ports = len(incident.ports)
+	if incident.Count == 1 || severity > incident.Severity {
+		incident.Severity = severity
+	}
+	if len(incident.Sample) < incidentSampleSize {
+		incident.Sample = append(incident.Sample, conn)
+	}
+	for _, rule := range rules {
+		if !containsString(incident.Rules, rule) {
+			incident.Rules = append(incident.Rules, rule)
+		}
+	}
+
+	if err == nil {
+		if incident.FirstSeen == "" || ts.Before(incident.first) {
+			incident.first, incident.FirstSeen = ts, conn.Timestamp
+		}
+		if incident.LastSeen == "" || ts.After(incident.last) {
+			incident.last, incident.LastSeen = ts, conn.Timestamp
+		}
+	}
+	return incident.ID
+}
+
+// key describes the Connection by the grouper's keys, e.g. `source 192.0.0.3, rule inspect SSH`
+func (g *IncidentGrouper) key(conn Connection, rules []string) string {
+	var parts []string
+	for _, key := range g.keys {
+		var value string
+		switch key {
+		case SourceIncidentKey:
+			value = conn.Source.String()
+		case DestinationIncidentKey:
+			value = conn.Destination.String()
+		case RuleIncidentKey:
+			sorted := append([]string{}, rules...)
+			sort.Strings(sorted)
+			value = strings.Join(sorted, " + ")
+			if value == "" {
+				value = "none"
+			}
+		case PortIncidentKey:
+			value = strconv.Itoa(conn.DestinationPort)
+		case ProtocolIncidentKey:
+			value = conn.Protocol
+		}
+		parts = append(parts, key+" "+value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Incidents returns the incidents, in the order they were started
+func (g *IncidentGrouper) Incidents() []Incident {
+	incidents := make([]Incident, 0, len(g.incidents))
+	for _, incident := range g.incidents {
+		incidents = append(incidents, *incident)
+	}
+	return incidents
+}
+
+// IncidentsWriter writes Incidents to a `.json` file
+type IncidentsWriter struct{}
+
+// Write an Incident slice to the output path
+func (w IncidentsWriter) Write(incidents []Incident, path string) error {
+	fmt.Fprintf(statusOutput, "Writing incidents file to %s\n", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating directory %s", filepath.Dir(path))
+	}
+
+	content, err := json.MarshalIndent(incidents, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling incidents")
+	}
+
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrapf(err, "writing file %s", path)
+	}
+
+	log.Println("Successfully wrote file.")
+	return nil
+}
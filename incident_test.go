@@ -0,0 +1,103 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestIncidentGrouper(t *testing.T) {
+	spec.Run(t, "IncidentGrouper", testIncidentGrouper, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testIncidentGrouper(t *testing.T, when spec.G, it spec.S) {
+	ssh := engine.Policy{ID: "1", Name: "inspect SSH", Verdict: engine.InspectVerdict}
+	rdp := engine.Policy{ID: "2", Name: "inspect RDP", Verdict: engine.InspectVerdict}
+
+	connection := func(timestamp, source, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       timestamp,
+			Source:          net.ParseIP(source),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	when("#Add", func() {
+		it("groups connections by source and rule within the gap", func() {
+			grouper, err := engine.NewIncidentGrouper([]string{engine.SourceIncidentKey, engine.RuleIncidentKey}, time.Minute)
+			assert.Nil(t, err)
+
+			first := connection("1000", "10.0.0.1", "10.0.0.2", 22)
+			assert.Equal(t, "incident-1", grouper.Add(first, []engine.Policy{ssh}, 1))
+			assert.Equal(t, "incident-1", grouper.Add(connection("1030", "10.0.0.1", "10.0.0.3", 22), []engine.Policy{ssh}, 3))
+			assert.Equal(t, "incident-2", grouper.Add(connection("1040", "10.0.0.1", "10.0.0.3", 3389), []engine.Policy{rdp}, 1))
+			assert.Equal(t, "incident-3", grouper.Add(connection("1050", "10.0.0.9", "10.0.0.3", 22), []engine.Policy{ssh}, 1))
+			assert.Equal(t, "incident-1", grouper.Add(connection("1080", "10.0.0.1", "10.0.0.3", 22), []engine.Policy{ssh}, 2))
+			assert.Equal(t, "incident-4", grouper.Add(connection("1200", "10.0.0.1", "10.0.0.2", 22), []engine.Policy{ssh}, 1))
+
+			incidents := grouper.Incidents()
+			assert.Equal(t, 4, len(incidents))
+			incident := incidents[0]
+			assert.Equal(t, "incident-1", incident.ID)
+			assert.Equal(t, "source 10.0.0.1, rule inspect SSH", incident.Key)
+			assert.Equal(t, "1000", incident.FirstSeen)
+			assert.Equal(t, "1080", incident.LastSeen)
+			assert.Equal(t, 3, incident.Count)
+			assert.Equal(t, 2, incident.Peers)
+			assert.Equal(t, 1, incident.Ports)
+			assert.Equal(t, []string{"inspect SSH"}, incident.Rules)
+			assert.Equal(t, 3.0, incident.Severity)
+			assert.Equal(t, first, incident.Sample[0])
+		})
+
+		it("groups by the configured keys", func() {
+			grouper, err := engine.NewIncidentGrouper([]string{engine.DestinationIncidentKey}, time.Minute)
+			assert.Nil(t, err)
+
+			assert.Equal(t, "incident-1", grouper.Add(connection("1000", "10.0.0.1", "10.0.0.3", 22), []engine.Policy{ssh}, 1))
+			assert.Equal(t, "incident-1", grouper.Add(connection("1010", "10.0.0.9", "10.0.0.3", 3389), []engine.Policy{rdp}, 1))
+			assert.Equal(t, "incident-2", grouper.Add(connection("1020", "10.0.0.9", "10.0.0.4", 3389), nil, 1))
+
+			incidents := grouper.Incidents()
+			assert.Equal(t, "destination 10.0.0.3", incidents[0].Key)
+			assert.Equal(t, []string{"inspect SSH", "inspect RDP"}, incidents[0].Rules)
+			assert.Equal(t, 2, incidents[0].Ports)
+		})
+
+		it("returns an error for an unknown key", func() {
+			_, err := engine.NewIncidentGrouper([]string{"country"}, time.Minute)
+			assert.Error(t, err)
+		})
+	})
+
+	when("IncidentsWriter#Write", func() {
+		it("writes the incidents", func() {
+			tmpDir, err := ioutil.TempDir("", "incidents")
+			assert.Nil(t, err)
+			defer os.RemoveAll(tmpDir)
+
+			grouper, err := engine.NewIncidentGrouper([]string{engine.SourceIncidentKey}, time.Minute)
+			assert.Nil(t, err)
+			grouper.Add(connection("1000", "10.0.0.1", "10.0.0.2", 22), []engine.Policy{ssh}, 1)
+
+			path := filepath.Join(tmpDir, "incidents.json")
+			assert.Nil(t, engine.IncidentsWriter{}.Write(grouper.Incidents(), path))
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Contains(t, string(content), `"id": "incident-1"`)
+			assert.Contains(t, string(content), `"key": "source 10.0.0.1"`)
+		})
+	})
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"container/heap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExpandInputs expands the connections inputs into the paths of the files they name, in order. A directory is expanded
+// into its files (leaving out hidden files and subdirectories), and a glob (e.g. `sensors/*.csv`) into the files it
+// matches, both sorted by name. A file named by more than one input is only read once, and StdStream is kept as is.
+func ExpandInputs(inputs []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, input := range inputs {
+		if input == StdStream {
+			add(input)
+			continue
+		}
+
+		if strings.ContainsAny(input, "*?[") {
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid glob %s", input)
+			}
+			var files []string
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+					files = append(files, match)
+				}
+			}
+			if len(files) == 0 {
+				return nil, errors.Errorf("no connections files match %s", input)
+			}
+			for _, file := range files {
+				add(file)
+			}
+			continue
+		}
+
+		// A file which doesn't exist is kept, so that reading it returns the usual error
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			add(input)
+			continue
+		}
+		entries, err := ioutil.ReadDir(input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read directory %s", input)
+		}
+		var files int
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			add(filepath.Join(input, entry.Name()))
+			files++
+		}
+		if files == 0 {
+			return nil, errors.Errorf("directory %s has no connections files", input)
+		}
+	}
+	return paths, nil
+}
+
+// InputStats are the statistics of a connections file which was read
+type InputStats struct {
+	Path string
+	Connections int
+	// First and Last are the earliest and the latest timestamps of the file's Connections, and are zero when none of
+	// them has a valid timestamp
+	First time.Time
+	Last time.Time
+	// OutOfOrder is the amount of Connections whose timestamp is earlier than the one before them
+	OutOfOrder int
+}
+
+// ReadAll reads the connections files, and returns their Connections together with the statistics of each file. The
+// Connections are concatenated in the order of the files, unless they are merged, in which case they are interleaved
+// by their timestamps into a single ordered stream (so that windowed and stateful detectors see the files as one).
+// Connections with the same timestamp keep the order of their files, and a Connection without a valid timestamp keeps
+// its place after the one before it in its file.
+func (c ConnectionsReadWriter) ReadAll(paths []string, merge bool) ([]Connection, []InputStats, error) {
+	inputs := make([][]Connection, len(paths))
+	times := make([][]time.Time, len(paths))
+	stats := make([]InputStats, len(paths))
+	for i, path := range paths {
+		connections, err := c.Read(path)
+		if err != nil {
+			return nil, stats[:i], errors.Wrapf(err, "parsing connections file %s", path)
+		}
+		inputs[i], times[i] = connections, connectionTimes(connections)
+		stats[i] = newInputStats(path, connections, times[i])
+	}
+
+	if !merge || len(inputs) == 1 {
+		var connections []Connection
+		for _, input := range inputs {
+			connections = append(connections, input...)
+		}
+		return connections, stats, nil
+	}
+
+	for i := range inputs {
+		// The merge expects each file to be ordered, which isn't the case for every collector
+		if stats[i].OutOfOrder != 0 {
+			sortByTimes(inputs[i], times[i])
+		}
+	}
+	return mergeByTime(inputs, times), stats, nil
+}
+
+// connectionTimes returns the time of each Connection, which is the time of the Connection before it when its timestamp
+// isn't valid
+func connectionTimes(connections []Connection) []time.Time {
+	times := make([]time.Time, len(connections))
+	var last time.Time
+	for i, conn := range connections {
+		if t, err := conn.Time(); err == nil {
+			last = t
+		}
+		times[i] = last
+	}
+	return times
+}
+
+func newInputStats(path string, connections []Connection, times []time.Time) InputStats {
+	stats := InputStats{Path: path, Connections: len(connections)}
+	for i, t := range times {
+		if i > 0 && t.Before(times[i-1]) {
+			stats.OutOfOrder++
+		}
+		if t.IsZero() {
+			continue
+		}
+		if stats.First.IsZero() || t.Before(stats.First) {
+			stats.First = t
+		}
+		if t.After(stats.Last) {
+			stats.Last = t
+		}
+	}
+	return stats
+}
+
+// sortByTimes stably sorts the Connections of a file, together with their times
+func sortByTimes(connections []Connection, times []time.Time) {
+	order := make([]int, len(connections))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return times[order[i]].Before(times[order[j]])
+	})
+
+	sortedConns := make([]Connection, len(connections))
+	sortedTimes := make([]time.Time, len(times))
+	for i, index := range order {
+		sortedConns[i], sortedTimes[i] = connections[index], times[index]
+	}
+	copy(connections, sortedConns)
+	copy(times, sortedTimes)
+}
+
+// mergeByTime k-way merges the ordered Connections of the files by their times
+func mergeByTime(inputs [][]Connection, times [][]time.Time) []Connection {
+	var total int
+	h := &mergeHeap{times: times}
+	for i, input := range inputs {
+		total += len(input)
+		if len(input) != 0 {
+			h.cursors = append(h.cursors, mergeCursor{input: i})
+		}
+	}
+	heap.Init(h)
+
+	merged := make([]Connection, 0, total)
+	for h.Len() != 0 {
+		cursor := &h.cursors[0]
+		merged = append(merged, inputs[cursor.input][cursor.next])
+		cursor.next++
+		if cursor.next == len(inputs[cursor.input]) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return merged
+}
+
+// mergeCursor is the next Connection of a file which is merged
+type mergeCursor struct {
+	input int
+	next int
+}
+
+// mergeHeap orders the cursors of the merged files by the time of their next Connection, and then by the order of the
+// files
+type mergeHeap struct {
+	cursors []mergeCursor
+	times [][]time.Time
+}
+
+func (h mergeHeap) Len() int {
+	return len(h.cursors)
+}
+
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	ta, tb := h.times[a.input][a.next], h.times[b.input][b.next]
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	return a.input < b.input
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	h.cursors = append(h.cursors, x.(mergeCursor))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
@@ -0,0 +1,140 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestInputs(t *testing.T) {
+	spec.Run(t, "Inputs", testInputs, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testInputs(t *testing.T, when spec.G, it spec.S) {
+	var dir string
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "inputs")
+		assert.Nil(t, err)
+	})
+
+	it.After(func() {
+		assert.Nil(t, os.RemoveAll(dir))
+	})
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	timestamps := func(connections []engine.Connection) []string {
+		var values []string
+		for _, conn := range connections {
+			values = append(values, conn.Timestamp)
+		}
+		return values
+	}
+
+	when("#ExpandInputs", func() {
+		it("expands directories and globs into their files, sorted and only once", func() {
+			b := write(filepath.Join("sensors", "b.csv"), "")
+			a := write(filepath.Join("sensors", "a.csv"), "")
+			write(filepath.Join("sensors", ".hidden"), "")
+			assert.Nil(t, os.MkdirAll(filepath.Join(dir, "sensors", "nested"), os.ModePerm))
+			other := write("other.log", "")
+
+			paths, err := engine.ExpandInputs([]string{other, filepath.Join(dir, "sensors"), filepath.Join(dir, "sensors", "*.csv"), engine.StdStream})
+			assert.Nil(t, err)
+			assert.Equal(t, []string{other, a, b, engine.StdStream}, paths)
+		})
+
+		it("keeps a file which doesn't exist, but returns an error for a glob without matches", func() {
+			missing := filepath.Join(dir, "missing.csv")
+			paths, err := engine.ExpandInputs([]string{missing})
+			assert.Nil(t, err)
+			assert.Equal(t, []string{missing}, paths)
+
+			_, err = engine.ExpandInputs([]string{filepath.Join(dir, "*.csv")})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "no connections files match")
+
+			_, err = engine.ExpandInputs([]string{dir})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "has no connections files")
+		})
+	})
+
+	when("#ReadAll", func() {
+		var first, second string
+
+		it.Before(func() {
+			first = write("first.csv", "timestamp,source,source_port,destination,destination_port,protocol\n"+
+				"1599665118,192.0.0.2,5000,192.128.0.32,22,TCP\n"+
+				"1599665121,192.0.0.2,5001,192.128.0.32,22,TCP\n")
+			second = write("second.jsonl", `{"timestamp":"1599665119","source":"192.0.0.3","destination":"192.128.0.32","protocol":"UDP"}`+"\n"+
+				`{"timestamp":"1599665121","source":"192.0.0.3","destination":"192.128.0.33","protocol":"UDP"}`+"\n"+
+				`{"timestamp":"1599665120","source":"192.0.0.3","destination":"192.128.0.34","protocol":"UDP"}`+"\n")
+		})
+
+		it("reads the files one after the other, with their statistics", func() {
+			connections, stats, err := engine.ConnectionsReadWriter{}.ReadAll([]string{first, second}, false)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"1599665118", "1599665121", "1599665119", "1599665121", "1599665120"}, timestamps(connections))
+			assert.Nil(t, connections[0].Origin)
+
+			assert.Equal(t, []engine.InputStats{
+				{Path: first, Connections: 2, First: time.Unix(1599665118, 0).UTC(), Last: time.Unix(1599665121, 0).UTC()},
+				{Path: second, Connections: 3, First: time.Unix(1599665119, 0).UTC(), Last: time.Unix(1599665121, 0).UTC(), OutOfOrder: 1},
+			}, stats)
+		})
+
+		it("merges the files by their timestamps, keeping the order of the files for the same timestamp", func() {
+			connections, _, err := engine.ConnectionsReadWriter{}.ReadAll([]string{first, second}, true)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"1599665118", "1599665119", "1599665120", "1599665121", "1599665121"}, timestamps(connections))
+			assert.Equal(t, 5001, connections[3].SourcePort)
+			assert.Equal(t, "192.128.0.33", connections[4].Destination.String())
+		})
+
+		it("tracks the file and line each connection was read from", func() {
+			connections, _, err := engine.ConnectionsReadWriter{TrackOrigin: true}.ReadAll([]string{first, second}, true)
+			assert.Nil(t, err)
+			assert.Equal(t, &engine.Origin{File: first, Line: 2}, connections[0].Origin)
+			assert.Equal(t, &engine.Origin{File: second, Line: 1}, connections[1].Origin)
+			assert.Equal(t, &engine.Origin{File: second, Line: 3}, connections[2].Origin)
+			assert.Equal(t, &engine.Origin{File: first, Line: 3}, connections[3].Origin)
+		})
+
+		it("tracks the line of a .csv file, rather than the record, past blank, comment and quoted multi-line rows", func() {
+			path := write("lines.csv", "# exported by the sensor\n"+
+				"timestamp,source,source_port,destination,destination_port,protocol,note\n"+
+				"\n"+
+				"1599665118,192.0.0.2,5000,192.128.0.32,22,TCP,\"first\nsecond\"\n"+
+				"# a comment\n"+
+				"1599665121,192.0.0.2,5001,192.128.0.32,22,TCP,\n")
+			rw := engine.ConnectionsReadWriter{TrackOrigin: true, Schema: engine.Schema{Comment: '#'}}
+			connections, _, err := rw.ReadAll([]string{path}, false)
+			assert.Nil(t, err)
+			assert.Equal(t, &engine.Origin{File: path, Line: 4}, connections[0].Origin)
+			assert.Equal(t, &engine.Origin{File: path, Line: 7}, connections[1].Origin)
+		})
+
+		it("returns an error naming the file which couldn't be read", func() {
+			missing := filepath.Join(dir, "missing.csv")
+			_, _, err := engine.ConnectionsReadWriter{}.ReadAll([]string{first, missing}, true)
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "parsing connections file "+missing)
+		})
+	})
+}
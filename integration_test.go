@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sclevine/spec"
@@ -41,6 +42,107 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("test", func() {
+		it("passes with matching fixtures", func() {
+			cmd.SetArgs([]string{"test", "-p", filepath.Join("testdata", "fixture_policy.json")})
+			assert.Nil(t, cmd.Execute())
+			assert.Contains(t, outBuf.String(), "PASS ssh from the laptop is suspicious")
+			assert.Contains(t, outBuf.String(), "3 passed, 0 failed")
+		})
+
+		it("fails with a diff of the failing fixtures", func() {
+			cmd.SetArgs([]string{"test", "-p", filepath.Join("testdata", "fixture_policy.json"), filepath.Join("testdata", "failing_fixtures.json")})
+			assert.NotNil(t, cmd.Execute())
+			output := outBuf.String()
+			assert.Contains(t, output, "FAIL web traffic is suspicious")
+			assert.Contains(t, output, "verdict: expected CLEAN, got SUSPICIOUS")
+			assert.Contains(t, output, "0 passed, 1 failed")
+		})
+
+		it("evaluates the fixtures with the rules which apply by their lifecycle", func() {
+			args := []string{"test", "-p", filepath.Join("testdata", "lifecycle_policy.json"), filepath.Join("testdata", "lifecycle_fixtures.json")}
+			cmd.SetArgs(args)
+			assert.NotNil(t, cmd.Execute())
+			output := outBuf.String()
+			assert.Contains(t, output, "Rule 'inspect contractor laptop' was skipped, as it expired at 2021-01-01T00:00:00Z")
+			assert.Contains(t, output, "Rule 'inspect migration' was skipped, as it has an improper expires_at soon")
+			assert.Contains(t, output, "FAIL the contractor laptop during the contract")
+			assert.Contains(t, output, "PASS the migration rule with an improper expiry never matches")
+
+			outBuf.Reset()
+			cmd.SetArgs(append(args, "--lifecycle-clock", "connection"))
+			assert.Nil(t, cmd.Execute())
+			assert.Contains(t, outBuf.String(), "2 passed, 0 failed")
+		})
+	})
+
+	when("learn", func() {
+		it("records the new tuples in the baseline", func() {
+			baseline := filepath.Join("out", "baseline.db")
+			assert.Nil(t, os.MkdirAll("out", os.ModePerm))
+			args := []string{"learn", "-c", filepath.Join("testdata", "baseline_connections.csv"), "--baseline", baseline}
+
+			cmd.SetArgs(args)
+			assert.Nil(t, cmd.Execute())
+			assert.Contains(t, outBuf.String(), "Learned 2 new tuple(s), expired 0, and the baseline now holds 2")
+
+			cmd = NewRunCommand()
+			cmd.SetOut(&outBuf)
+			cmd.SetArgs(args)
+			assert.Nil(t, cmd.Execute())
+			assert.Contains(t, outBuf.String(), "Learned 0 new tuple(s), expired 0, and the baseline now holds 2")
+		})
+	})
+
+	when("suggest", func() {
+		it("writes a policy of the suggested rules, with their coverage", func() {
+			output := filepath.Join("out", "suggested_policy.json")
+			cmd.SetArgs([]string{"suggest", "-c", filepath.Join("testdata", "baseline_connections.csv"), "-o", output})
+			assert.Nil(t, cmd.Execute())
+			assert.Contains(t, outBuf.String(), "suggested-1: ignore TCP 22 to 10.0.0.2/32 covers 2 connection(s) (66.67%)")
+			assert.Contains(t, outBuf.String(), "2 rule(s) cover 3 of 3 connection(s) (100.00%)")
+
+			policies, err := PolicyReader{}.Read(output)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(policies))
+		})
+	})
+
+	when("profile", func() {
+		it("writes the trained profiles", func() {
+			output := filepath.Join("out", "profiles.json")
+			cmd.SetArgs([]string{"profile", "-c", filepath.Join("testdata", "baseline_connections.csv"), "-o", output, "--window", "1m"})
+			assert.Nil(t, cmd.Execute())
+			assert.Contains(t, outBuf.String(), "Profiled 1 host(s) over 1 window(s) of 1m0s")
+
+			profiles, err := ProfilesReadWriter{}.Read(output)
+			assert.Nil(t, err)
+			assert.Equal(t, 3.0, profiles.Hosts["10.0.0.1"].Features[ConnectionsFeature].Mean)
+		})
+	})
+
+	when("lint", func() {
+		it("reports the expired suppressions", func() {
+			cmd.SetArgs([]string{"lint", "--suppressions", filepath.Join("testdata", "suppressions.json")})
+			assert.NotNil(t, cmd.Execute())
+			output := outBuf.String()
+			assert.Contains(t, output, "suppression 'migration': expired at 2020-01-01T00:00:00Z, and no longer applies")
+			assert.Contains(t, output, "suppression 'forever': has no valid expires_at, so it never applies")
+			assert.Contains(t, output, "suppression 'typo': has improper source 10.0.0.5, so it never applies")
+			assert.NotContains(t, output, "suppression 'pentest'")
+			assert.Contains(t, output, "5 issue(s) found")
+		})
+
+		it("reports the expired rules", func() {
+			cmd.SetArgs([]string{"lint", "-p", filepath.Join("testdata", "lifecycle_policy.json")})
+			assert.NotNil(t, cmd.Execute())
+			output := outBuf.String()
+			assert.Contains(t, output, "rule 'inspect contractor laptop': expired at 2021-01-01T00:00:00Z, and no longer applies")
+			assert.Contains(t, output, "rule 'inspect migration': has an improper expires_at soon, so it never applies")
+			assert.Contains(t, output, "2 issue(s) found")
+		})
+	})
+
 	when("default inputs", func() {
 		it.After(func() {
 			assert.Nil(t, os.Remove(outputPath))
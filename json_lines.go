@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The formats of the written connections files, see ConnectionsReadWriter.Format
+var (
+	CSVFormat = "csv"
+	JSONLinesFormat = "jsonl"
+)
+
+// The extensions of the JSON Lines files, which are written in the JSONLinesFormat unless another format is set
+var jsonLinesExtensions = []string{".jsonl", ".ndjson"}
+
+// maxJSONLineSize is the longest line of a JSON Lines file which is read, so that a file which isn't line delimited
+// fails clearly rather than being buffered in full
+var maxJSONLineSize = 1024 * 1024
+
+// formatOf returns the format of a connections file by its extension, ignoring the compression's extension (e.g.
+// `suspicious.jsonl.gz` is a JSON Lines file). Any other extension is a CSV file.
+func formatOf(path string) string {
+	ext := filepath.Ext(path)
+	if ext == GzipExtension || ext == ZstdExtension {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext))
+	}
+	if containsString(jsonLinesExtensions, strings.ToLower(ext)) {
+		return JSONLinesFormat
+	}
+	return CSVFormat
+}
+
+// isJSONLines peeks at the beginning of a stream, and returns true if its first character is the opening brace of a JSON
+// object, rather than the beginning of a CSV row
+func isJSONLines(r *bufio.Reader) bool {
+	return peekFirstByte(r) == '{'
+}
+
+// peekFirstByte peeks at the beginning of a stream, and returns its first character after a byte order mark and
+// whitespace, or 0 if the stream is empty or only holds whitespace
+func peekFirstByte(r *bufio.Reader) byte {
+	for size := 64; ; size *= 2 {
+		peeked, err := r.Peek(size)
+		trimmed := bytes.TrimLeft(bytes.TrimPrefix(peeked, []byte(byteOrderMark)), " \t\r\n")
+		if len(trimmed) != 0 {
+			return trimmed[0]
+		}
+		// The stream is empty or only holds whitespace, so it is as good as an empty CSV file
+		if err != nil || size >= maxJSONLineSize {
+			return 0
+		}
+	}
+}
+
+// readJSONLines reads a connection object from each line of the stream, whose fields are named as the columns of a CSV
+// header (including their aliases, e.g. `src_ip`). Numbers may be given either as JSON numbers or as strings, and blank
+// lines are skipped. The lines of a Zeek JSON log and the entries of a GCP flow log are read as such, see
+// zeekJSONConnection and gcpConnection.
+func (c ConnectionsReadWriter) readJSONLines(r io.Reader) ([]Connection, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+
+	var connections []Connection
+	for line := 1; scanner.Scan(); line++ {
+		content := bytes.TrimSpace(scanner.Bytes())
+		if line == 1 {
+			content = bytes.TrimPrefix(content, []byte(byteOrderMark))
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		// Numbers are kept as written, so that a timestamp isn't reformatted (e.g. to 1.599665118593452e+09)
+		var object map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return connections, errors.Wrapf(err, "line %d isn't a valid JSON object", line)
+		}
+		if _, ok := object[zeekJSONKey]; ok {
+			conn, err := zeekJSONConnection(object)
+			if err != nil {
+				return connections, errors.Wrapf(err, "line %d", line)
+			}
+			conn.Origin = c.origin(line)
+			connections = append(connections, conn)
+			continue
+		}
+		if _, ok := object[gcpJSONKey]; ok {
+			conn, err := gcpConnection(object)
+			if err != nil {
+				return connections, errors.Wrapf(err, "line %d", line)
+			}
+			conn.Origin = c.origin(line)
+			connections = append(connections, conn)
+			continue
+		}
+
+		row := make([]string, len(headerRow))
+		found := make([]bool, len(headerRow))
+		var fields map[string]string
+		for key, value := range object {
+			// The extra fields of a Connection are kept, so that the JSON Lines output is read back the same
+			if nested, ok := value.(map[string]interface{}); ok && key == "fields" {
+				fields = map[string]string{}
+				for name, v := range nested {
+					fields[name] = stringOrEmpty(v)
+				}
+				continue
+			}
+			field, ok := fieldAliases[normalizeColumn(key)]
+			if !ok {
+				continue
+			}
+			index := indexOf(headerRow, field)
+			row[index], found[index] = stringOrEmpty(value), true
+		}
+
+		var missing []string
+		for _, field := range requiredFields {
+			if !found[indexOf(headerRow, field)] {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) != 0 {
+			return connections, errors.Errorf("line %d is missing the %s field(s)", line, strings.Join(missing, ", "))
+		}
+		conn := NewConnection(row)
+		conn.Fields = fields
+		conn.Origin = c.origin(line)
+		connections = append(connections, conn)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return connections, errors.Wrap(err, "failed to read JSON Lines connection file")
+	}
+	return connections, nil
+}
+
+// writeJSONLines writes each Connection as a JSON object on its own line, with the fields of the CSV header (and its
+// extra fields, if any) followed by the additional Columns, in order
+func writeJSONLines(w io.Writer, connections []Connection, columns ...Column) error {
+	writer := bufio.NewWriter(w)
+	for i, conn := range connections {
+		line, err := json.Marshal(conn)
+		if err != nil {
+			return errors.Wrapf(err, "writing value %+v to file", conn)
+		}
+		// The Connection's closing brace is replaced by the Columns, so the fields keep their order
+		line = line[:len(line)-1]
+		for _, column := range columns {
+			name, err := json.Marshal(column.jsonName())
+			if err != nil {
+				return errors.Wrapf(err, "writing column %s to file", column.Name)
+			}
+			value, err := json.Marshal(column.jsonValue(i, conn))
+			if err != nil {
+				return errors.Wrapf(err, "writing column %s of value %+v to file", column.Name, conn)
+			}
+			line = append(append(append(append(line, ','), name...), ':'), value...)
+		}
+		line = append(line, '}', '\n')
+
+		if _, err := writer.Write(line); err != nil {
+			return errors.Wrap(err, "writing to file")
+		}
+	}
+	return errors.Wrap(writer.Flush(), "writing to file")
+}
@@ -0,0 +1,119 @@
+package engine_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestJSONLines(t *testing.T) {
+	spec.Run(t, "JSONLines", testJSONLines, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testJSONLines(t *testing.T, when spec.G, it spec.S) {
+	var connectionRW = engine.ConnectionsReadWriter{}
+	conn := engine.Connection{
+		Timestamp: "1599665118.593452",
+		Source: net.ParseIP("192.0.0.2"),
+		SourcePort: 5000,
+		Destination: net.ParseIP("192.128.0.32"),
+		DestinationPort: 51000,
+		Protocol: "TCP",
+	}
+
+	when("reading", func() {
+		it("detects JSON Lines, and reads numbers as written or as strings", func() {
+			content := `{"timestamp":1599665118.593452,"source":"192.0.0.2","source_port":5000,"destination":"192.128.0.32","destination_port":51000,"protocol":"TCP"}` + "\n" +
+				"\n" +
+				`{"ts":"1599665118.593452","src_ip":"192.0.0.2","sport":"5000","dst_ip":"192.128.0.32","dport":"51000","proto":"TCP","bytes":1024}` + "\n"
+			connections, err := connectionRW.ReadStream(bytes.NewBufferString(content))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn, conn}, connections)
+		})
+
+		it("returns an error for a line which isn't a JSON object", func() {
+			content := `{"timestamp":"1","source":"192.0.0.2","destination":"192.128.0.32","protocol":"TCP"}` + "\n" + "{\"timestamp\":\n"
+			_, err := connectionRW.ReadStream(bytes.NewBufferString(content))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 2 isn't a valid JSON object")
+		})
+
+		it("returns an error for a line missing a required field", func() {
+			_, err := connectionRW.ReadStream(bytes.NewBufferString(`{"timestamp":"1","source":"192.0.0.2"}`))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 1 is missing the destination, protocol field(s)")
+		})
+	})
+
+	when("writing", func() {
+		it("writes the connection fields followed by the columns, with their JSON names and values", func() {
+			columns := []engine.Column{
+				{Name: "verdict", Value: func(i int, conn engine.Connection) string {
+					return engine.SuspiciousVerdict
+				}},
+				{Name: "score", JSONName: "severity", Value: func(i int, conn engine.Connection) string {
+					return strconv.Itoa(i)
+				}, JSONValue: func(i int, conn engine.Connection) interface{} {
+					return 2.5
+				}},
+				{Name: "rules", Value: func(i int, conn engine.Connection) string {
+					return "1;2"
+				}, JSONValue: func(i int, conn engine.Connection) interface{} {
+					return []string{"1", "2"}
+				}},
+			}
+
+			var buf bytes.Buffer
+			jsonRW := engine.ConnectionsReadWriter{Format: engine.JSONLinesFormat}
+			assert.Nil(t, jsonRW.WriteStream(&buf, []engine.Connection{conn}, columns...))
+			assert.Equal(t, `{"timestamp":"1599665118.593452","source":"192.0.0.2","source_port":5000,"destination":"192.128.0.32","destination_port":51000,"protocol":"TCP","verdict":"SUSPICIOUS","severity":2.5,"rules":["1","2"]}` + "\n", buf.String())
+		})
+
+		it("returns an error for an unknown format", func() {
+			var buf bytes.Buffer
+			xmlRW := engine.ConnectionsReadWriter{Format: "xml"}
+			err := xmlRW.WriteStream(&buf, []engine.Connection{conn})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "unknown output format xml")
+		})
+
+		when("the format is detected by the extension", func() {
+			var tmpDir string
+
+			it.Before(func() {
+				var err error
+				tmpDir, err = ioutil.TempDir("", "connections")
+				assert.Nil(t, err)
+			})
+
+			it.After(func() {
+				assert.Nil(t, os.RemoveAll(tmpDir))
+			})
+
+			it("writes JSON Lines files, which are read back", func() {
+				for _, name := range []string{"suspicious.jsonl", "suspicious.ndjson", "suspicious.jsonl.gz"} {
+					path := filepath.Join(tmpDir, name)
+					assert.Nil(t, connectionRW.Write([]engine.Connection{conn}, path))
+
+					read, err := connectionRW.Read(path)
+					assert.Nil(t, err)
+					assert.Equal(t, []engine.Connection{conn}, read)
+				}
+
+				content, err := ioutil.ReadFile(filepath.Join(tmpDir, "suspicious.jsonl"))
+				assert.Nil(t, err)
+				assert.Equal(t, byte('{'), content[0])
+			})
+		})
+	})
+}
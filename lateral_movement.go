@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	FanOutHubAlert    = "FANOUT_HUB"
+	SuddenFanOutAlert = "SUDDEN_FANOUT"
+	AdminChainAlert   = "ADMIN_CHAIN"
+)
+
+// DefaultAdminPorts are the ports of SSH, SMB and RDP, which are commonly used to move laterally
+var DefaultAdminPorts = []int{22, 445, 3389}
+
+// maxChainHops bounds the length of the admin chains which are searched for
+var maxChainHops = 6
+
+// LateralMovementConfig configures the analysis of the LateralMovementDetector
+type LateralMovementConfig struct {
+	InternalNetworks []*net.IPNet
+	AdminPorts       []int
+	// HubPeers is the amount of distinct internal peers, above which a host is flagged as a fan-out hub
+	HubPeers int
+	// FanOutPeers is the amount of new internal peers within FanOutWindow, above which a host is flagged as suddenly
+	// fanning out
+	FanOutPeers  int
+	FanOutWindow time.Duration
+	// MinChainHops is the minimal amount of consecutive internal admin port hops, which is flagged as a chain
+	MinChainHops int
+}
+
+// LateralMovementDetector builds a host communication Graph from the Connections, and analyzes it for lateral movement:
+// hosts becoming fan-out hubs, hosts suddenly reaching many new internal peers, and chains of internal hops on admin
+// ports
+type LateralMovementDetector struct {
+	config     LateralMovementConfig
+	adminPorts map[int]interface{}
+	graph      *Graph
+	hosts      map[string]windowGroup
+}
+
+// fanOutState tracks the internal peers of a single source host
+type fanOutState struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	// peers holds the first Connection to each internal peer, as evidence for a fan-out hub alert
+	peers map[string]Connection
+	hub   bool
+	// recent holds the Connections to new internal peers within the fan-out window
+	recent []Connection
+	times  []time.Time
+}
+
+func (f *fanOutState) last() time.Time {
+	return f.lastSeen
+}
+
+// NewLateralMovementDetector returns a LateralMovementDetector with the given configuration
+func NewLateralMovementDetector(config LateralMovementConfig) *LateralMovementDetector {
+	adminPorts := map[int]interface{}{}
+	for _, port := range config.AdminPorts {
+		adminPorts[port] = nil
+	}
+
+	return &LateralMovementDetector{
+		config:     config,
+		adminPorts: adminPorts,
+		graph:      NewGraph(config.InternalNetworks),
+		hosts:      map[string]windowGroup{},
+	}
+}
+
+// Graph returns the host communication Graph which was built from the observed Connections
+func (l *LateralMovementDetector) Graph() *Graph {
+	return l.graph
+}
+
+// Observe adds the Connection to the Graph, and returns an Alert if its source became a fan-out hub, or suddenly
+// reached many new internal peers. Like the other detectors, the Graph, the hosts and the peers of each host are bounded
+// by maxDetectorGroups, and the least recently seen ones are evicted (or, for the peers, no longer added) beyond it.
+func (l *LateralMovementDetector) Observe(conn Connection) []Alert {
+	if len(l.graph.edges) >= maxDetectorGroups {
+		l.graph.evictEdges(maxDetectorGroups * 3 / 4)
+	}
+	l.graph.Add(conn)
+	if !l.graph.IsInternal(conn.Destination) {
+		return nil
+	}
+	ts, err := conn.Time()
+	if err != nil {
+		return nil
+	}
+
+	source := conn.Source.String()
+	group, ok := l.hosts[source]
+	if !ok {
+		if len(l.hosts) >= maxDetectorGroups {
+			// The hubs are based on all the peers of a host, so only the least recently seen hosts are evicted
+			evictGroups(l.hosts, time.Time{})
+		}
+		group = &fanOutState{firstSeen: ts, peers: map[string]Connection{}}
+		l.hosts[source] = group
+	}
+	host := group.(*fanOutState)
+	if ts.After(host.lastSeen) {
+		host.lastSeen = ts
+	}
+
+	peer := conn.Destination.String()
+	if _, ok := host.peers[peer]; ok || len(host.peers) >= maxDetectorGroups {
+		return nil
+	}
+	host.peers[peer] = conn
+
+	var alerts []Alert
+	if !host.hub && l.config.HubPeers > 0 && len(host.peers) > l.config.HubPeers {
+		host.hub = true
+		alerts = append(alerts, newGroupAlert(FanOutHubAlert, "source "+source, host.peers))
+	}
+
+	// A host's first peers are all new, so it is only considered sudden once the host has been seen for a full window
+	if l.config.FanOutPeers <= 0 || ts.Sub(host.firstSeen) < l.config.FanOutWindow {
+		return alerts
+	}
+
+	start := ts.Add(-l.config.FanOutWindow)
+	drop := 0
+	for drop < len(host.times) && host.times[drop].Before(start) {
+		drop++
+	}
+	host.recent = append(host.recent[drop:], conn)
+	host.times = append(host.times[drop:], ts)
+	if len(host.recent) > l.config.FanOutPeers {
+		alerts = append(alerts, Alert{
+			Type:        SuddenFanOutAlert,
+			Key:         "source " + source,
+			Count:       len(host.recent),
+			FirstSeen:   host.recent[0].Timestamp,
+			LastSeen:    conn.Timestamp,
+			Connections: host.recent,
+		})
+		host.recent, host.times = nil, nil
+	}
+	return alerts
+}
+
+// Report returns the Alerts of the chains of the Graph so far, see Flush
+func (l *LateralMovementDetector) Report() []Alert {
+	return l.Flush()
+}
+
+// Flush analyzes the complete Graph for chains of internal hops on admin ports
+func (l *LateralMovementDetector) Flush() []Alert {
+	if l.config.MinChainHops <= 0 {
+		return nil
+	}
+
+	// Only the internal edges on admin ports, with a valid time span, can be part of a chain
+	var hops []*GraphEdge
+	outgoing := map[string][]*GraphEdge{}
+	incoming := map[string][]*GraphEdge{}
+	for _, edge := range l.graph.Edges() {
+		if _, ok := l.adminPorts[edge.Port]; !ok || edge.FirstSeen == "" {
+			continue
+		}
+		if !l.graph.nodes[edge.Source].Internal || !l.graph.nodes[edge.Destination].Internal {
+			continue
+		}
+		hops = append(hops, edge)
+		outgoing[edge.Source] = append(outgoing[edge.Source], edge)
+		incoming[edge.Destination] = append(incoming[edge.Destination], edge)
+	}
+
+	var alerts []Alert
+	for _, edge := range hops {
+		// Chains are only started from an edge which doesn't continue an earlier hop, so each chain is reported once,
+		// rather than once for each of its suffixes
+		continues := false
+		for _, previous := range incoming[edge.Source] {
+			if follows(previous, edge) && previous.Source != edge.Destination {
+				continues = true
+				break
+			}
+		}
+		if continues {
+			continue
+		}
+
+		if chain := longestChain(edge, outgoing); len(chain) >= l.config.MinChainHops {
+			alerts = append(alerts, newChainAlert(chain))
+		}
+	}
+	return alerts
+}
+
+// follows returns true if the next hop was still active after the previous hop started
+func follows(previous, next *GraphEdge) bool {
+	return !next.last.Before(previous.first)
+}
+
+// longestChain returns the longest chain which starts with the edge, found by a depth-first search of the chains which
+// visit each host once. The search is bounded by maxChainHops, and stops once a chain reaches it, so that a mesh of
+// admin hops (e.g. a jump host reaching tens of hosts over SSH) isn't searched exhaustively.
+func longestChain(start *GraphEdge, outgoing map[string][]*GraphEdge) []*GraphEdge {
+	visited := map[string]bool{start.Source: true, start.Destination: true}
+	var search func(chain []*GraphEdge) []*GraphEdge
+	search = func(chain []*GraphEdge) []*GraphEdge {
+		longest := chain
+		if len(chain) >= maxChainHops {
+			return longest
+		}
+
+		last := chain[len(chain)-1]
+		for _, next := range outgoing[last.Destination] {
+			if !follows(last, next) || visited[next.Destination] {
+				continue
+			}
+			visited[next.Destination] = true
+			if extended := search(append(append([]*GraphEdge{}, chain...), next)); len(extended) > len(longest) {
+				longest = extended
+			}
+			// The host may be part of a longer chain through another branch
+			visited[next.Destination] = false
+			if len(longest) >= maxChainHops {
+				break
+			}
+		}
+		return longest
+	}
+	return search([]*GraphEdge{start})
+}
+
+// newChainAlert builds an Alert from a chain of hops, with the first Connection of each hop as evidence
+func newChainAlert(chain []*GraphEdge) Alert {
+	var path []string
+	var conns []Connection
+	for _, edge := range chain {
+		path = append(path, fmt.Sprintf("%s -> %s:%d/%s", edge.Source, edge.Destination, edge.Port, edge.Protocol))
+		conns = append(conns, edge.sample)
+	}
+
+	first, last := chain[0], chain[0]
+	for _, edge := range chain {
+		if edge.last.After(last.last) {
+			last = edge
+		}
+	}
+
+	return Alert{
+		Type:        AdminChainAlert,
+		Key:         strings.Join(path, ", then "),
+		Count:       len(chain),
+		FirstSeen:   first.FirstSeen,
+		LastSeen:    last.LastSeen,
+		Connections: conns,
+	}
+}
@@ -0,0 +1,160 @@
+package engine_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestLateralMovementDetector(t *testing.T) {
+	spec.Run(t, "LateralMovementDetector", testLateralMovementDetector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testLateralMovementDetector(t *testing.T, when spec.G, it spec.S) {
+	var config engine.LateralMovementConfig
+
+	it.Before(func() {
+		networks, err := engine.ParseNetworks([]string{"10.0.0.0/8"})
+		assert.Nil(t, err)
+		config = engine.LateralMovementConfig{
+			InternalNetworks: networks,
+			AdminPorts:       engine.DefaultAdminPorts,
+			FanOutWindow:     time.Hour,
+		}
+	})
+
+	connection := func(timestamp int, source, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       fmt.Sprintf("%d.000000", timestamp),
+			Source:          net.ParseIP(source),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	when("a host reaches many internal peers", func() {
+		it("flags it as a fan-out hub once", func() {
+			config.HubPeers = 2
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", "10.0.0.2", 80),
+				connection(1001, "10.0.0.1", "8.8.8.8", 80),
+				connection(1002, "10.0.0.1", "10.0.0.2", 443),
+				connection(1003, "10.0.0.1", "10.0.0.3", 80),
+				connection(1004, "10.0.0.1", "10.0.0.4", 80),
+				connection(1005, "10.0.0.1", "10.0.0.5", 80),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewLateralMovementDetector(config))
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.FanOutHubAlert,
+				Key:         "source 10.0.0.1",
+				Count:       3,
+				FirstSeen:   conns[0].Timestamp,
+				LastSeen:    conns[4].Timestamp,
+				Connections: []engine.Connection{conns[0], conns[3], conns[4]},
+			}}, result.Alerts)
+		})
+	})
+
+	when("a known host suddenly reaches many new internal peers", func() {
+		it("flags it as a sudden fan-out", func() {
+			config.FanOutPeers = 2
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", "10.0.0.2", 80),
+				connection(2000, "10.0.0.1", "10.0.0.3", 80),
+				connection(2000, "10.0.0.1", "10.0.0.4", 80),
+				connection(5000, "10.0.0.1", "10.0.0.5", 80),
+				connection(5010, "10.0.0.1", "10.0.0.2", 80),
+				connection(5020, "10.0.0.1", "10.0.0.6", 80),
+				connection(5030, "10.0.0.1", "10.0.0.7", 80),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewLateralMovementDetector(config))
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.SuddenFanOutAlert,
+				Key:         "source 10.0.0.1",
+				Count:       3,
+				FirstSeen:   conns[3].Timestamp,
+				LastSeen:    conns[6].Timestamp,
+				Connections: []engine.Connection{conns[3], conns[5], conns[6]},
+			}}, result.Alerts)
+		})
+	})
+
+	when("internal hosts hop on admin ports", func() {
+		it("flags the chain once", func() {
+			config.MinChainHops = 2
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", "10.0.0.2", 22),
+				connection(1100, "10.0.0.2", "10.0.0.3", 3389),
+				connection(1200, "10.0.0.3", "10.0.0.4", 445),
+				connection(1300, "10.0.0.4", "8.8.8.8", 22),
+				connection(1400, "10.0.0.4", "10.0.0.5", 80),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewLateralMovementDetector(config))
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.AdminChainAlert,
+				Key:         "10.0.0.1 -> 10.0.0.2:22/TCP, then 10.0.0.2 -> 10.0.0.3:3389/TCP, then 10.0.0.3 -> 10.0.0.4:445/TCP",
+				Count:       3,
+				FirstSeen:   conns[0].Timestamp,
+				LastSeen:    conns[2].Timestamp,
+				Connections: conns[:3],
+			}}, result.Alerts)
+		})
+
+		it("flags only the longest chain of each first hop, in a mesh of admin hops", func() {
+			config.MinChainHops = 2
+			conns := []engine.Connection{connection(1000, "10.0.0.1", "10.0.1.0", 22)}
+			for i := 0; i < 30; i++ {
+				for j := 0; j < 30; j++ {
+					if i != j {
+						conns = append(conns, connection(1100+i*30+j, fmt.Sprintf("10.0.1.%d", i), fmt.Sprintf("10.0.1.%d", j), 22))
+					}
+				}
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewLateralMovementDetector(config))
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, 6, result.Alerts[0].Count)
+			assert.True(t, strings.HasPrefix(result.Alerts[0].Key, "10.0.0.1 -> 10.0.1.0:22/TCP, then 10.0.1.0 -> 10.0.1.1:22/TCP"))
+		})
+
+		it("flags the longest chain, through a host already reached by a shorter branch", func() {
+			config.MinChainHops = 4
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", "10.0.0.2", 22),
+				connection(1100, "10.0.0.2", "10.0.0.3", 22),
+				connection(1200, "10.0.0.2", "10.0.0.4", 22),
+				connection(1300, "10.0.0.4", "10.0.0.3", 22),
+				connection(1400, "10.0.0.3", "10.0.0.5", 22),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewLateralMovementDetector(config))
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, 4, result.Alerts[0].Count)
+			assert.Equal(t, "10.0.0.1 -> 10.0.0.2:22/TCP, then 10.0.0.2 -> 10.0.0.4:22/TCP, then 10.0.0.4 -> 10.0.0.3:22/TCP, then 10.0.0.3 -> 10.0.0.5:22/TCP", result.Alerts[0].Key)
+		})
+
+		it("doesn't flag hops which ended before the previous one started", func() {
+			config.MinChainHops = 2
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.2", "10.0.0.3", 3389),
+				connection(1100, "10.0.0.1", "10.0.0.2", 22),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewLateralMovementDetector(config))
+			assert.Nil(t, result.Alerts)
+		})
+	})
+}
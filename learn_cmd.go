@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewLearnCommand creates a CLI which records the tuples of a connections file in a baseline, for the detection of
+// tuples which were never seen before (see NoveltyDetector)
+func NewLearnCommand() *cobra.Command {
+	learnConnectionsPaths := networkConnectionsPaths
+	learnBaselinePath := ""
+	learnMaxAge := baselineMaxAge
+	cmd := &cobra.Command{
+		Use:   "learn",
+		Short: "Record the tuples of a connections file in a baseline",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return learnBaseline(cmd.OutOrStdout(), learnBaselinePath, learnConnectionsPaths, learnMaxAge)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&learnConnectionsPaths, "connections", "c", learnConnectionsPaths, "Paths, globs or directories of valid connections files, or - for stdin")
+	cmd.Flags().StringVar(&learnBaselinePath, "baseline", learnBaselinePath, "Path to the baseline file, which is created if it doesn't exist")
+	cmd.Flags().DurationVar(&learnMaxAge, "baseline-max-age", learnMaxAge, "Expire the baseline tuples which weren't seen for this long before the latest connection (0 disables)")
+	_ = cmd.MarkFlagRequired("baseline")
+
+	return cmd
+}
+
+func learnBaseline(out io.Writer, baselinePath string, connectionsPaths []string, maxAge time.Duration) error {
+	connectionsRW, err := newConnectionsReadWriter()
+	if err != nil {
+		return err
+	}
+	connections, _, err := readConnections(connectionsRW, connectionsPaths)
+	if err != nil {
+		return err
+	}
+
+	baseline, err := OpenBaseline(baselinePath)
+	if err != nil {
+		return err
+	}
+	defer baseline.Close()
+
+	added, err := baseline.Learn(connections)
+	if err != nil {
+		return err
+	}
+
+	// Expiry is relative to the learned Connections rather than to the current time, so that older batches can be
+	// learned as well
+	removed := 0
+	var latest time.Time
+	for _, conn := range connections {
+		if ts, err := conn.Time(); err == nil && ts.After(latest) {
+			latest = ts
+		}
+	}
+	if maxAge > 0 && !latest.IsZero() {
+		if removed, err = baseline.Expire(latest.Add(-maxAge)); err != nil {
+			return err
+		}
+	}
+
+	size, err := baseline.Len()
+	if err != nil {
+		return errors.Wrap(err, "failed to read baseline")
+	}
+	fmt.Fprintf(out, "Learned %d new tuple(s), expired %d, and the baseline now holds %d\n", added, removed, size)
+	return nil
+}
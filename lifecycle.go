@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The clocks the valid_from and expires_at of rules can be checked against: the current time, once for the whole run,
+// or the timestamp of each Connection, so that a historical file is analyzed with the rules which applied at the time
+var (
+	WallClock       = "wall"
+	ConnectionClock = "connection"
+)
+
+// SkippedPolicy is a Policy which doesn't apply to a run, because of its lifecycle
+type SkippedPolicy struct {
+	Policy Policy
+	// Reason describes why the Policy was skipped, e.g. `expired at 2020-12-31T23:59:59Z`
+	Reason string
+}
+
+// ApplyLifecycle returns the Policies which apply using the clock, and the ones which were skipped. Disabled Policies,
+// and those with an improper valid_from or expires_at, are always skipped. With the wall clock, Policies which aren't
+// valid at the given time are skipped as well, and with the connection clock, the Policies are matched only against the
+// Connections within their valid_from and expires_at.
+func ApplyLifecycle(policies []Policy, clock string, now time.Time) ([]Policy, []SkippedPolicy, error) {
+	if clock != WallClock && clock != ConnectionClock {
+		return nil, nil, errors.Errorf("unknown lifecycle clock %s, expected %s or %s", clock, WallClock, ConnectionClock)
+	}
+
+	var active []Policy
+	var skipped []SkippedPolicy
+	for _, policy := range policies {
+		if policy.Disabled {
+			skipped = append(skipped, SkippedPolicy{Policy: policy, Reason: "is disabled"})
+			continue
+		}
+		if policy.ImproperLifecycle != nil {
+			skipped = append(skipped, SkippedPolicy{Policy: policy, Reason: "has an improper " + strings.Join(policy.ImproperLifecycle, " and ")})
+			continue
+		}
+
+		if clock == ConnectionClock {
+			policy.connectionClock = !policy.ValidFrom.IsZero() || !policy.ExpiresAt.IsZero()
+		} else if !policy.ExpiresAt.IsZero() && !now.Before(policy.ExpiresAt) {
+			skipped = append(skipped, SkippedPolicy{Policy: policy, Reason: "expired at " + policy.ExpiresAt.Format(time.RFC3339)})
+			continue
+		} else if !policy.ValidFrom.IsZero() && now.Before(policy.ValidFrom) {
+			skipped = append(skipped, SkippedPolicy{Policy: policy, Reason: "isn't valid until " + policy.ValidFrom.Format(time.RFC3339)})
+			continue
+		}
+		active = append(active, policy)
+	}
+	return active, skipped, nil
+}
@@ -0,0 +1,108 @@
+package engine_test
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestLifecycle(t *testing.T) {
+	spec.Run(t, "Lifecycle", testLifecycle, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testLifecycle(t *testing.T, when spec.G, it spec.S) {
+	var policies []engine.Policy
+
+	// connection returns a Connection from the contractor laptop at the time
+	connection := func(at time.Time, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       fmt.Sprintf("%d.000000", at.Unix()),
+			Source:          net.ParseIP("10.0.0.1"),
+			SourcePort:      40000,
+			Destination:     net.ParseIP("10.0.0.2"),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	it.Before(func() {
+		var err error
+		policies, err = engine.PolicyReader{}.Read(filepath.Join("testdata", "lifecycle_policy.json"))
+		assert.Nil(t, err)
+	})
+
+	when("PolicyReader#Read", func() {
+		it("reads the lifecycle of the rules, keeping the improper valid_from and expires_at", func() {
+			assert.False(t, policies[0].Disabled)
+			assert.True(t, policies[0].ExpiresAt.IsZero())
+			assert.Equal(t, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC), policies[1].ValidFrom)
+			assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), policies[1].ExpiresAt)
+			assert.True(t, policies[2].Disabled)
+			assert.False(t, policies[3].Disabled)
+			assert.True(t, policies[3].ExpiresAt.IsZero())
+			assert.Nil(t, policies[1].ImproperLifecycle)
+			assert.Equal(t, []string{"expires_at soon"}, policies[3].ImproperLifecycle)
+		})
+	})
+
+	when("#ActiveAt", func() {
+		it("is active from valid_from until expires_at", func() {
+			assert.False(t, policies[1].ActiveAt(policies[1].ValidFrom.Add(-time.Second)))
+			assert.True(t, policies[1].ActiveAt(policies[1].ValidFrom))
+			assert.False(t, policies[1].ActiveAt(policies[1].ExpiresAt))
+			assert.True(t, policies[0].ActiveAt(time.Now()))
+		})
+	})
+
+	when("#ApplyLifecycle", func() {
+		it("skips the disabled rules, and those which aren't valid on the wall clock", func() {
+			active, skipped, err := engine.ApplyLifecycle(policies, engine.WallClock, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Policy{policies[0]}, active)
+			assert.Equal(t, []engine.SkippedPolicy{
+				{Policy: policies[1], Reason: "expired at 2021-01-01T00:00:00Z"},
+				{Policy: policies[2], Reason: "is disabled"},
+				{Policy: policies[3], Reason: "has an improper expires_at soon"},
+			}, skipped)
+
+			_, skipped, err = engine.ApplyLifecycle(policies, engine.WallClock, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+			assert.Nil(t, err)
+			assert.Equal(t, "isn't valid until 2020-10-01T00:00:00Z", skipped[0].Reason)
+		})
+
+		it("matches the rules against the connections within their lifecycle on the connection clock", func() {
+			active, skipped, err := engine.ApplyLifecycle(policies, engine.ConnectionClock, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(active))
+			assert.Equal(t, 2, len(skipped))
+
+			result := engine.DetectAttacks(active, []engine.Connection{
+				connection(time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC), 80),
+				connection(time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC), 80),
+				connection(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), 80),
+				connection(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), 443),
+			})
+			assert.Equal(t, 1, len(result.Suspicious))
+			assert.Equal(t, map[string]int{"inspect contractor laptop": 1}, result.RuleCount)
+		})
+
+		it("returns an error for an unknown clock", func() {
+			_, _, err := engine.ApplyLifecycle(policies, "sundial", time.Now())
+			assert.Error(t, err)
+		})
+	})
+
+	when("#Matches", func() {
+		it("never matches a disabled rule", func() {
+			assert.False(t, policies[2].Matches(connection(time.Now(), 443)))
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LintIssue is a problem found in a configuration file, which doesn't stop it from being read, but is likely a mistake
+type LintIssue struct {
+	// Subject is what the issue was found in, e.g. `suppression 'pentest'`
+	Subject string
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Subject, i.Message)
+}
+
+// LintSuppressions returns the issues of the Suppressions at the given time: those which expired (or never had a valid
+// expiry), those with improper criteria, and those without a reason or owner
+func LintSuppressions(suppressions []Suppression, now time.Time) []LintIssue {
+	var issues []LintIssue
+	for _, suppression := range suppressions {
+		subject := fmt.Sprintf("suppression '%s'", suppression.ID)
+		if suppression.Expires.IsZero() {
+			issues = append(issues, LintIssue{Subject: subject, Message: "has no valid expires_at, so it never applies"})
+		} else if suppression.Expired(now) {
+			issues = append(issues, LintIssue{Subject: subject, Message: fmt.Sprintf("expired at %s, and no longer applies", suppression.Expires.Format(time.RFC3339))})
+		}
+		if suppression.Improper != nil {
+			issues = append(issues, LintIssue{Subject: subject, Message: fmt.Sprintf("has improper %s, so it never applies", strings.Join(suppression.Improper, ", "))})
+		}
+		if suppression.Reason == "" {
+			issues = append(issues, LintIssue{Subject: subject, Message: "has no reason"})
+		}
+		if suppression.Owner == "" {
+			issues = append(issues, LintIssue{Subject: subject, Message: "has no owner"})
+		}
+	}
+	return issues
+}
+
+// LintPolicies returns the lifecycle issues of the Policies at the given time: those which expired, those which expire
+// within the warning period, and those which are never valid (including those with an improper valid_from or
+// expires_at)
+func LintPolicies(policies []Policy, now time.Time, warning time.Duration) []LintIssue {
+	var issues []LintIssue
+	for _, policy := range policies {
+		subject := fmt.Sprintf("rule '%s'", policy.Name)
+		if policy.ImproperLifecycle != nil {
+			issues = append(issues, LintIssue{Subject: subject, Message: fmt.Sprintf("has an improper %s, so it never applies", strings.Join(policy.ImproperLifecycle, " and "))})
+			continue
+		}
+		if policy.ExpiresAt.IsZero() {
+			continue
+		}
+
+		expiry := policy.ExpiresAt.Format(time.RFC3339)
+		switch {
+		case !policy.ValidFrom.IsZero() && !policy.ValidFrom.Before(policy.ExpiresAt):
+			issues = append(issues, LintIssue{Subject: subject, Message: fmt.Sprintf("is valid from %s, after it expires at %s, so it never applies", policy.ValidFrom.Format(time.RFC3339), expiry)})
+		case !now.Before(policy.ExpiresAt):
+			issues = append(issues, LintIssue{Subject: subject, Message: fmt.Sprintf("expired at %s, and no longer applies", expiry)})
+		case now.Add(warning).After(policy.ExpiresAt):
+			issues = append(issues, LintIssue{Subject: subject, Message: fmt.Sprintf("expires at %s, within %s", expiry, warning)})
+		}
+	}
+	return issues
+}
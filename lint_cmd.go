@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewLintCommand creates a CLI which reports the issues of the configuration files, such as expired rules and
+// suppressions
+func NewLintCommand() *cobra.Command {
+	lintPolicyPath := ""
+	lintSuppressionsPath := suppressionsPath
+	lintExpiryWarning := 14 * 24 * time.Hour
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Report the issues of the configuration files, such as expired rules and suppressions",
+		// Issues are an expected outcome, and shouldn't be followed by the usage text
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLint(cmd.OutOrStdout(), lintPolicyPath, lintSuppressionsPath, time.Now(), lintExpiryWarning)
+		},
+	}
+
+	cmd.Flags().StringVarP(&lintPolicyPath, "policy", "p", lintPolicyPath, "Path to a JSON policy file")
+	cmd.Flags().StringVar(&lintSuppressionsPath, "suppressions", lintSuppressionsPath, "Path to a suppressions JSON file")
+	cmd.Flags().DurationVar(&lintExpiryWarning, "expiry-warning", lintExpiryWarning, "Warn about the rules which expire within this long")
+
+	return cmd
+}
+
+func runLint(out io.Writer, policyPath, suppressionsPath string, now time.Time, expiryWarning time.Duration) error {
+	if policyPath == "" && suppressionsPath == "" {
+		return errors.New("no files to lint, provide --policy or --suppressions")
+	}
+
+	count := 0
+	if policyPath != "" {
+		policyReader := PolicyReader{}
+		policies, err := policyReader.Read(policyPath)
+		if err != nil {
+			return errors.Wrapf(err, "parsing policy file %s", policyPath)
+		}
+		count += printIssues(out, policyPath, LintPolicies(policies, now, expiryWarning))
+	}
+
+	if suppressionsPath != "" {
+		suppressionReader := SuppressionReader{}
+		suppressions, err := suppressionReader.Read(suppressionsPath)
+		if err != nil {
+			return errors.Wrapf(err, "parsing suppressions file %s", suppressionsPath)
+		}
+		count += printIssues(out, suppressionsPath, LintSuppressions(suppressions, now))
+	}
+
+	fmt.Fprintf(out, "\n%d issue(s) found\n", count)
+	if count > 0 {
+		return errors.Errorf("%d issue(s) found", count)
+	}
+	return nil
+}
+
+func printIssues(out io.Writer, path string, issues []LintIssue) int {
+	for _, issue := range issues {
+		fmt.Fprintf(out, "WARN %s: %s\n", path, issue)
+	}
+	return len(issues)
+}
@@ -0,0 +1,71 @@
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestLint(t *testing.T) {
+	spec.Run(t, "Lint", testLint, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testLint(t *testing.T, when spec.G, it spec.S) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	when("#LintSuppressions", func() {
+		it("reports expired suppressions, and those without a reason or owner", func() {
+			suppressions := []engine.Suppression{
+				{ID: "pentest", Reason: "Q3 pen test", Owner: "secops", Expires: now.Add(time.Hour)},
+				{ID: "migration", Reason: "database migration", Owner: "dba", Expires: now.Add(-time.Hour)},
+				{ID: "forever"},
+			}
+
+			issues := engine.LintSuppressions(suppressions, now)
+			assert.Equal(t, []engine.LintIssue{
+				{Subject: "suppression 'migration'", Message: "expired at 2023-12-31T23:00:00Z, and no longer applies"},
+				{Subject: "suppression 'forever'", Message: "has no valid expires_at, so it never applies"},
+				{Subject: "suppression 'forever'", Message: "has no reason"},
+				{Subject: "suppression 'forever'", Message: "has no owner"},
+			}, issues)
+			assert.Equal(t, "suppression 'forever': has no owner", issues[3].String())
+		})
+
+		it("reports suppressions with improper criteria", func() {
+			suppressions := []engine.Suppression{{ID: "typo", Reason: "scanner", Owner: "secops", Expires: now.Add(time.Hour), Improper: []string{"source 10.0.0.5", "port 22"}}}
+			assert.Equal(t, []engine.LintIssue{
+				{Subject: "suppression 'typo'", Message: "has improper source 10.0.0.5, port 22, so it never applies"},
+			}, engine.LintSuppressions(suppressions, now))
+		})
+
+		it("reports nothing for active suppressions", func() {
+			suppressions := []engine.Suppression{{ID: "pentest", Reason: "Q3 pen test", Owner: "secops", Expires: now.Add(time.Hour)}}
+			assert.Empty(t, engine.LintSuppressions(suppressions, now))
+		})
+	})
+
+	when("#LintPolicies", func() {
+		it("reports expired rules, rules which expire soon, and rules which never apply", func() {
+			policies := []engine.Policy{
+				{Name: "forever"},
+				{Name: "expired", ExpiresAt: now.Add(-time.Hour)},
+				{Name: "soon", ExpiresAt: now.Add(24 * time.Hour)},
+				{Name: "later", ExpiresAt: now.Add(30 * 24 * time.Hour)},
+				{Name: "never", ValidFrom: now.Add(48 * time.Hour), ExpiresAt: now.Add(24 * time.Hour)},
+				{Name: "typo", ImproperLifecycle: []string{"expires_at 2020-12-31"}},
+			}
+
+			assert.Equal(t, []engine.LintIssue{
+				{Subject: "rule 'expired'", Message: "expired at 2023-12-31T23:00:00Z, and no longer applies"},
+				{Subject: "rule 'soon'", Message: "expires at 2024-01-02T00:00:00Z, within 168h0m0s"},
+				{Subject: "rule 'never'", Message: "is valid from 2024-01-03T00:00:00Z, after it expires at 2024-01-02T00:00:00Z, so it never applies"},
+				{Subject: "rule 'typo'", Message: "has an improper expires_at 2020-12-31, so it never applies"},
+			}, engine.LintPolicies(policies, now, 7*24*time.Hour))
+		})
+	})
+}
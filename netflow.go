@@ -0,0 +1,485 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// protocolNames maps the IP protocol numbers of flow records and packets to the names the rules use, e.g. `6` to `TCP`.
+// Other protocols are named by their number.
+var protocolNames = map[int]string{
+	1:   "ICMP",
+	2:   "IGMP",
+	6:   "TCP",
+	17:  "UDP",
+	41:  "IPV6",
+	47:  "GRE",
+	50:  "ESP",
+	51:  "AH",
+	58:  "ICMPV6",
+	89:  "OSPF",
+	132: "SCTP",
+}
+
+// protocolName returns the name of an IP protocol number
+func protocolName(number int) string {
+	if name, ok := protocolNames[number]; ok {
+		return name
+	}
+	return strconv.Itoa(number)
+}
+
+// The versions of the NetFlow export packets which are decoded, where IPFIX is version 10
+var (
+	netFlowV5 uint16 = 5
+	netFlowV9 uint16 = 9
+	ipfixVersion uint16 = 10
+)
+
+// The IDs of the NetFlow v9 and IPFIX fields which are read, which are the same in both (IPFIX's information elements
+// are numbered after NetFlow v9's fields)
+var (
+	inBytesField uint16 = 1
+	inPacketsField uint16 = 2
+	protocolField uint16 = 4
+	tcpFlagsField uint16 = 6
+	sourcePortField uint16 = 7
+	sourceIPv4Field uint16 = 8
+	destinationPortField uint16 = 11
+	destinationIPv4Field uint16 = 12
+	firstSwitchedField uint16 = 22
+	sourceIPv6Field uint16 = 27
+	destinationIPv6Field uint16 = 28
+	flowStartSecondsField uint16 = 150
+	flowStartMillisecondsField uint16 = 152
+	systemInitTimeField uint16 = 160
+)
+
+// variableLength is the length of the IPFIX fields whose length is given by each record
+var variableLength = 65535
+
+// netFlowField is a field of a template, and enterprise fields are skipped
+type netFlowField struct {
+	ID uint16
+	Length int
+	Enterprise bool
+}
+
+// netFlowTemplate describes the records of the data sets which reference it. The records of options templates describe
+// the exporter rather than flows, so they are skipped.
+type netFlowTemplate struct {
+	Fields []netFlowField
+	Options bool
+}
+
+// netFlowDecoder decodes NetFlow v5, v9 and IPFIX export packets into Connections. The v9 and IPFIX templates are kept
+// across packets, by exporter, observation domain and template ID.
+type netFlowDecoder struct {
+	templates map[string]netFlowTemplate
+	// unknownTemplates counts the data sets which were skipped, as their template wasn't exported yet
+	unknownTemplates int
+}
+
+func newNetFlowDecoder() *netFlowDecoder {
+	return &netFlowDecoder{templates: map[string]netFlowTemplate{}}
+}
+
+// decode returns the Connections of the flow records of an export packet, which was sent by the exporter
+func (d *netFlowDecoder) decode(exporter string, data []byte) ([]Connection, error) {
+	if len(data) < 2 {
+		return nil, errors.New("truncated export packet")
+	}
+	switch version := binary.BigEndian.Uint16(data); version {
+	case netFlowV5:
+		return decodeNetFlowV5(data)
+	case netFlowV9:
+		return d.decodeNetFlowV9(exporter, data)
+	case ipfixVersion:
+		return d.decodeIPFIX(exporter, data)
+	default:
+		return nil, errors.Errorf("unsupported NetFlow version %d", version)
+	}
+}
+
+// decodeNetFlowV5 decodes the fixed records of a NetFlow v5 packet, whose start time is relative to the exporter's
+// uptime
+func decodeNetFlowV5(data []byte) ([]Connection, error) {
+	if len(data) < 24 {
+		return nil, errors.New("truncated NetFlow v5 header")
+	}
+	count := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < 24+count*48 {
+		return nil, errors.Errorf("NetFlow v5 packet has %d byte(s), but %d record(s) need %d", len(data), count, 24+count*48)
+	}
+	uptime := binary.BigEndian.Uint32(data[4:])
+	exported := time.Unix(int64(binary.BigEndian.Uint32(data[8:])), int64(binary.BigEndian.Uint32(data[12:])))
+
+	var connections []Connection
+	for i := 0; i < count; i++ {
+		record := data[24+i*48 : 24+(i+1)*48]
+		first := binary.BigEndian.Uint32(record[24:])
+		connections = append(connections, Connection{
+			Timestamp: epochTimestamp(uptimeTime(exported, uptime, first)),
+			Source: copyIP(record[0:4]),
+			SourcePort: int(binary.BigEndian.Uint16(record[32:])),
+			Destination: copyIP(record[4:8]),
+			DestinationPort: int(binary.BigEndian.Uint16(record[34:])),
+			Protocol: protocolName(int(record[38])),
+			Fields: map[string]string{
+				"bytes": strconv.FormatUint(uint64(binary.BigEndian.Uint32(record[20:])), 10),
+				"packets": strconv.FormatUint(uint64(binary.BigEndian.Uint32(record[16:])), 10),
+				"tcp_flags": strconv.Itoa(int(record[37])),
+			},
+		})
+	}
+	return connections, nil
+}
+
+// decodeNetFlowV9 decodes the template and data flowsets of a NetFlow v9 packet
+func (d *netFlowDecoder) decodeNetFlowV9(exporter string, data []byte) ([]Connection, error) {
+	if len(data) < 20 {
+		return nil, errors.New("truncated NetFlow v9 header")
+	}
+	uptime := binary.BigEndian.Uint32(data[4:])
+	exported := time.Unix(int64(binary.BigEndian.Uint32(data[8:])), 0)
+	prefix := fmt.Sprintf("9/%s/%d/", exporter, binary.BigEndian.Uint32(data[16:]))
+
+	var connections []Connection
+	err := forEachSet(data[20:], func(id uint16, set []byte) error {
+		switch {
+		case id == 0:
+			return d.readTemplates(prefix, set, false, false)
+		case id == 1:
+			return d.readTemplates(prefix, set, true, false)
+		case id >= 256:
+			conns, err := d.readRecords(prefix, id, set, func(values map[uint16][]byte) time.Time {
+				if first, ok := values[firstSwitchedField]; ok {
+					return uptimeTime(exported, uptime, uint32(readUint(first)))
+				}
+				return exported
+			})
+			connections = append(connections, conns...)
+			return err
+		}
+		return nil
+	})
+	return connections, err
+}
+
+// decodeIPFIX decodes the template and data sets of an IPFIX message, whose records may hold their start time in
+// several ways
+func (d *netFlowDecoder) decodeIPFIX(exporter string, data []byte) ([]Connection, error) {
+	if len(data) < 16 {
+		return nil, errors.New("truncated IPFIX header")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < 16 || length > len(data) {
+		return nil, errors.Errorf("IPFIX message has %d byte(s), but its header has %d", len(data), length)
+	}
+	exported := time.Unix(int64(binary.BigEndian.Uint32(data[4:])), 0)
+	prefix := fmt.Sprintf("10/%s/%d/", exporter, binary.BigEndian.Uint32(data[12:]))
+
+	var connections []Connection
+	err := forEachSet(data[16:length], func(id uint16, set []byte) error {
+		switch {
+		case id == 2:
+			return d.readTemplates(prefix, set, false, true)
+		case id == 3:
+			return d.readTemplates(prefix, set, true, true)
+		case id >= 256:
+			conns, err := d.readRecords(prefix, id, set, func(values map[uint16][]byte) time.Time {
+				if ms, ok := values[flowStartMillisecondsField]; ok {
+					return time.Unix(0, int64(readUint(ms))*int64(time.Millisecond))
+				}
+				if s, ok := values[flowStartSecondsField]; ok {
+					return time.Unix(int64(readUint(s)), 0)
+				}
+				first, okFirst := values[firstSwitchedField]
+				boot, okBoot := values[systemInitTimeField]
+				if okFirst && okBoot {
+					return time.Unix(0, int64(readUint(boot)+readUint(first))*int64(time.Millisecond))
+				}
+				return exported
+			})
+			connections = append(connections, conns...)
+			return err
+		}
+		return nil
+	})
+	return connections, err
+}
+
+// forEachSet calls the function with the ID and content of each set (or flowset) of a packet
+func forEachSet(data []byte, f func(id uint16, set []byte) error) error {
+	// Sets are 4 byte aligned, so fewer bytes than a set header are padding
+	for len(data) >= 4 {
+		id, length := binary.BigEndian.Uint16(data), int(binary.BigEndian.Uint16(data[2:]))
+		if length < 4 || length > len(data) {
+			return errors.Errorf("set %d has an invalid length %d", id, length)
+		}
+		if err := f(id, data[4:length]); err != nil {
+			return err
+		}
+		data = data[length:]
+	}
+	return nil
+}
+
+// readTemplates reads the templates (or options templates) of a template set, where IPFIX fields may be enterprise
+// fields or have a variable length. An IPFIX template without fields withdraws the template.
+func (d *netFlowDecoder) readTemplates(prefix string, set []byte, options, ipfix bool) error {
+	for len(set) >= 4 {
+		id := binary.BigEndian.Uint16(set)
+		if id < 256 {
+			// Template IDs start at 256, so a lower one is the set's padding
+			return nil
+		}
+
+		var count int
+		switch {
+		case options && ipfix:
+			if len(set) < 6 {
+				return errors.Errorf("truncated options template %d", id)
+			}
+			count, set = int(binary.BigEndian.Uint16(set[2:])), set[6:]
+		case options:
+			if len(set) < 6 {
+				return errors.Errorf("truncated options template %d", id)
+			}
+			// The scope and option lengths are in bytes, of 4 bytes for each field
+			count, set = int(binary.BigEndian.Uint16(set[2:])+binary.BigEndian.Uint16(set[4:]))/4, set[6:]
+		default:
+			count, set = int(binary.BigEndian.Uint16(set[2:])), set[4:]
+		}
+		if ipfix && count == 0 {
+			delete(d.templates, prefix+strconv.Itoa(int(id)))
+			continue
+		}
+
+		template := netFlowTemplate{Options: options}
+		for i := 0; i < count; i++ {
+			if len(set) < 4 {
+				return errors.Errorf("truncated template %d", id)
+			}
+			field := netFlowField{ID: binary.BigEndian.Uint16(set), Length: int(binary.BigEndian.Uint16(set[2:]))}
+			set = set[4:]
+			if ipfix && field.ID&0x8000 != 0 {
+				if len(set) < 4 {
+					return errors.Errorf("truncated template %d", id)
+				}
+				field.ID, field.Enterprise, set = field.ID&0x7fff, true, set[4:]
+			}
+			if !ipfix && field.Length == variableLength {
+				return errors.Errorf("template %d has a variable length field, which NetFlow v9 doesn't support", id)
+			}
+			template.Fields = append(template.Fields, field)
+		}
+		d.templates[prefix+strconv.Itoa(int(id))] = template
+	}
+	return nil
+}
+
+// readRecords reads the flow records of a data set with its template. Data sets of templates which weren't exported yet
+// are skipped and counted, as the exporter resends its templates periodically.
+func (d *netFlowDecoder) readRecords(prefix string, id uint16, set []byte, start func(values map[uint16][]byte) time.Time) ([]Connection, error) {
+	template, ok := d.templates[prefix+strconv.Itoa(int(id))]
+	if !ok {
+		d.unknownTemplates++
+		return nil, nil
+	}
+	if template.Options {
+		return nil, nil
+	}
+
+	minLength := 0
+	for _, field := range template.Fields {
+		if field.Length == variableLength {
+			minLength++
+		} else {
+			minLength += field.Length
+		}
+	}
+	if minLength == 0 {
+		return nil, errors.Errorf("template %d has no fields", id)
+	}
+
+	var connections []Connection
+	// The rest of the set, which is shorter than a record, is padding
+	for len(set) >= minLength {
+		values := map[uint16][]byte{}
+		for _, field := range template.Fields {
+			length := field.Length
+			if length == variableLength {
+				if len(set) < 1 {
+					return connections, errors.Errorf("truncated record of template %d", id)
+				}
+				length, set = int(set[0]), set[1:]
+				if length == 255 {
+					if len(set) < 2 {
+						return connections, errors.Errorf("truncated record of template %d", id)
+					}
+					length, set = int(binary.BigEndian.Uint16(set)), set[2:]
+				}
+			}
+			if len(set) < length {
+				return connections, errors.Errorf("truncated record of template %d", id)
+			}
+			if !field.Enterprise {
+				values[field.ID] = set[:length]
+			}
+			set = set[length:]
+		}
+
+		if conn, ok := flowConnection(values, start(values)); ok {
+			connections = append(connections, conn)
+		}
+	}
+	return connections, nil
+}
+
+// flowConnection creates a Connection from the values of a flow record's fields, and returns false for records
+// without addresses
+func flowConnection(values map[uint16][]byte, start time.Time) (Connection, bool) {
+	source, destination := values[sourceIPv4Field], values[destinationIPv4Field]
+	if source == nil || destination == nil {
+		source, destination = values[sourceIPv6Field], values[destinationIPv6Field]
+	}
+	if (len(source) != net.IPv4len && len(source) != net.IPv6len) || len(destination) != len(source) {
+		return Connection{}, false
+	}
+
+	conn := Connection{
+		Timestamp: epochTimestamp(start),
+		Source: copyIP(source),
+		SourcePort: int(readUint(values[sourcePortField])),
+		Destination: copyIP(destination),
+		DestinationPort: int(readUint(values[destinationPortField])),
+		Protocol: protocolName(int(readUint(values[protocolField]))),
+	}
+	for name, id := range map[string]uint16{"bytes": inBytesField, "packets": inPacketsField, "tcp_flags": tcpFlagsField} {
+		if value, ok := values[id]; ok {
+			if conn.Fields == nil {
+				conn.Fields = map[string]string{}
+			}
+			conn.Fields[name] = strconv.FormatUint(readUint(value), 10)
+		}
+	}
+	return conn, true
+}
+
+// readUint reads a big endian unsigned integer of any length up to 8 bytes, as the lengths of template fields vary
+func readUint(value []byte) uint64 {
+	var n uint64
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// uptimeTime returns the time of an uptime (in milliseconds) of the exporter, given the time and uptime of the export
+func uptimeTime(exported time.Time, uptime, at uint32) time.Time {
+	// The uptime wraps around every ~49 days, which the unsigned subtraction accounts for
+	return exported.Add(-time.Duration(uptime-at) * time.Millisecond)
+}
+
+// readNetFlow reads the Connections of a NetFlow v5, v9 or IPFIX export stream, which is either a pcap (or pcapng)
+// capture of the collector's port, an nfcapd file, or a dump of NetFlow v5 or IPFIX packets one after the other.
+// Captured packets which aren't exports are skipped and counted.
+func (c ConnectionsReadWriter) readNetFlow(r *bufio.Reader) ([]Connection, error) {
+	decoder := newNetFlowDecoder()
+	defer func() {
+		if decoder.unknownTemplates > 0 {
+			log.Printf("Skipped %d NetFlow data set(s), as their template wasn't exported before them \n", decoder.unknownTemplates)
+		}
+	}()
+
+	if !isCapture(r) {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read NetFlow file")
+		}
+		if isNfcapd(data) {
+			return decodeNfcapd(data)
+		}
+		return decodeNetFlowDump(decoder, data)
+	}
+
+	packets, err := newPacketSource(r)
+	if err != nil {
+		return nil, err
+	}
+	var connections []Connection
+	skipped := 0
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return connections, err
+		}
+
+		// The datagrams which were fragmented are skipped, as their first fragment doesn't hold the whole export
+		ip, ok := decodeIP(packet)
+		if !ok || ip.Protocol != udpProtocol || ip.Fragment {
+			continue
+		}
+		_, port, payload, ok := decodeUDP(ip.Payload)
+		if !ok || (c.NetFlowPorts != nil && !containsInt(c.NetFlowPorts, port)) {
+			continue
+		}
+
+		conns, err := decoder.decode(ip.Source.String(), payload)
+		if err != nil {
+			skipped++
+			continue
+		}
+		connections = append(connections, conns...)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d captured packet(s), which weren't valid NetFlow exports \n", skipped)
+	}
+	return connections, nil
+}
+
+// decodeNetFlowDump decodes the packets of a dump, whose packets are delimited by their own lengths. NetFlow v9 packets
+// don't hold their length, so they can only be read from a capture.
+func decodeNetFlowDump(decoder *netFlowDecoder, data []byte) ([]Connection, error) {
+	var connections []Connection
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 4 {
+			return connections, errors.Errorf("truncated export packet at offset %d", offset)
+		}
+
+		var length int
+		switch version := binary.BigEndian.Uint16(data[offset:]); version {
+		case netFlowV5:
+			length = 24 + int(binary.BigEndian.Uint16(data[offset+2:]))*48
+		case ipfixVersion:
+			length = int(binary.BigEndian.Uint16(data[offset+2:]))
+		case netFlowV9:
+			return connections, errors.Errorf("NetFlow v9 packet at offset %d doesn't hold its length, so it can only be read from a pcap capture", offset)
+		default:
+			return connections, errors.Errorf("unsupported NetFlow version %d at offset %d", version, offset)
+		}
+		if length < 4 || offset+length > len(data) {
+			return connections, errors.Errorf("truncated export packet at offset %d", offset)
+		}
+
+		conns, err := decoder.decode("", data[offset:offset+length])
+		if err != nil {
+			return connections, errors.Wrapf(err, "export packet at offset %d", offset)
+		}
+		connections = append(connections, conns...)
+		offset += length
+	}
+	return connections, nil
+}
@@ -0,0 +1,241 @@
+package engine_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestNetFlow(t *testing.T) {
+	spec.Run(t, "NetFlow", testNetFlow, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+// flowBytes builds a packet of big endian values, of 1, 2 or 4 bytes by their type
+func flowBytes(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, value := range values {
+		switch v := value.(type) {
+		case []byte:
+			buf.Write(v)
+		default:
+			_ = binary.Write(&buf, binary.BigEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+// flowSet builds a set (or flowset) of the content, with its header
+func flowSet(id uint16, content ...interface{}) []byte {
+	body := flowBytes(content...)
+	return flowBytes(id, uint16(4+len(body)), body)
+}
+
+// capture builds a little endian pcap file of Ethernet frames, holding the UDP datagrams sent from the exporter
+func capture(exporter net.IP, port uint16, datagrams ...[]byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, 1})
+	for i, datagram := range datagrams {
+		udp := flowBytes(uint16(40000), port, uint16(8+len(datagram)), uint16(0), datagram)
+		ip := flowBytes(uint8(0x45), uint8(0), uint16(20+len(udp)), uint16(0), uint16(0), uint8(64), uint8(17), uint16(0), []byte(exporter.To4()), []byte(net.ParseIP("10.0.0.100").To4()), udp)
+		frame := flowBytes(make([]byte, 12), uint16(0x0800), ip)
+		_ = binary.Write(&buf, binary.LittleEndian, []uint32{uint32(1599665118 + i), 0, uint32(len(frame)), uint32(len(frame))})
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// littleEndianBytes builds the little endian values, as flowBytes builds big endian ones
+func littleEndianBytes(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, value := range values {
+		_ = binary.Write(&buf, binary.LittleEndian, value)
+	}
+	return buf.Bytes()
+}
+
+// nfcapdFile builds a little endian nfcapd file of the blocks, each of which is the type and records of the block
+func nfcapdFile(flags uint32, blocks ...[][]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(littleEndianBytes(uint16(0xa50c), uint16(1), flags, uint32(len(blocks)), make([]byte, 128), make([]byte, 136)))
+	for _, block := range blocks {
+		records := bytes.Join(block[1:], nil)
+		buf.Write(littleEndianBytes(uint32(len(block)-1), uint32(len(records)), uint16(block[0][0]), uint16(0), records))
+	}
+	return buf.Bytes()
+}
+
+func testNetFlow(t *testing.T, when spec.G, it spec.S) {
+	netFlowRW := engine.ConnectionsReadWriter{InputFormat: engine.NetFlowFormat}
+	source, destination := net.ParseIP("192.0.0.2").To4(), net.ParseIP("192.128.0.32").To4()
+
+	// A NetFlow v5 record of a TCP flow, which started 1.5 seconds before it was exported
+	v5 := flowBytes(uint16(5), uint16(1), uint32(10000), uint32(1599665120), uint32(0), uint32(1), uint8(0), uint8(0), uint16(0),
+		[]byte(source), []byte(destination), make([]byte, 4), uint16(0), uint16(0), uint32(3), uint32(180), uint32(8500), uint32(9000),
+		uint16(5000), uint16(22), uint8(0), uint8(0x1b), uint8(6), uint8(0), uint16(0), uint16(0), uint8(0), uint8(0), uint16(0))
+	v5Conn := engine.Connection{
+		Timestamp: "1599665118.500000",
+		Source: net.ParseIP("192.0.0.2"),
+		SourcePort: 5000,
+		Destination: net.ParseIP("192.128.0.32"),
+		DestinationPort: 22,
+		Protocol: "TCP",
+		Fields: map[string]string{"bytes": "180", "packets": "3", "tcp_flags": "27"},
+	}
+
+	// An IPFIX template with an enterprise field and a variable length field, which are skipped, and its data record
+	ipfixTemplate := flowSet(2, uint16(256), uint16(8),
+		uint16(8), uint16(4), uint16(12), uint16(4), uint16(7), uint16(2), uint16(11), uint16(2), uint16(4), uint16(1),
+		uint16(152), uint16(8), uint16(0x8000|1), uint16(4), uint32(9), uint16(82), uint16(65535))
+	ipfixData := flowSet(256, []byte(source), []byte(destination), uint16(53000), uint16(53), uint8(17),
+		uint64(1599665118593), uint32(7), uint8(4), []byte("eth0"), make([]byte, 3))
+	ipfix := func(sets ...[]byte) []byte {
+		body := bytes.Join(sets, nil)
+		return flowBytes(uint16(10), uint16(16+len(body)), uint32(1599665120), uint32(1), uint32(0), body)
+	}
+	ipfixConn := engine.Connection{
+		Timestamp: "1599665118.593000",
+		Source: net.ParseIP("192.0.0.2"),
+		SourcePort: 53000,
+		Destination: net.ParseIP("192.128.0.32"),
+		DestinationPort: 53,
+		Protocol: "UDP",
+	}
+
+	when("a dump of export packets", func() {
+		it("decodes NetFlow v5 records, relative to the exporter's uptime", func() {
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(v5))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn}, connections)
+		})
+
+		it("decodes IPFIX records with the templates of earlier messages", func() {
+			dump := append(append(ipfix(ipfixTemplate), v5...), ipfix(ipfixData)...)
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(dump))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn, ipfixConn}, connections)
+		})
+
+		it("skips the data sets of unknown templates", func() {
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(ipfix(ipfixData)))
+			assert.Nil(t, err)
+			assert.Empty(t, connections)
+		})
+
+		it("returns an error for a truncated packet", func() {
+			_, err := netFlowRW.ReadStream(bytes.NewReader(v5[:len(v5)-10]))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "truncated export packet at offset 0")
+		})
+
+		it("returns an error for NetFlow v9, which needs a capture", func() {
+			_, err := netFlowRW.ReadStream(bytes.NewReader(flowBytes(uint16(9), uint16(0), make([]byte, 16))))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "can only be read from a pcap capture")
+		})
+	})
+
+	when("an nfcapd file", func() {
+		// A record of an IPv4 flow, and of an IPv6 flow with 64 bit counters, whose values are in the byte order of the
+		// file, the same as their addresses
+		v4Record := littleEndianBytes(uint16(10), uint16(48), uint16(0), uint16(1), uint16(500), uint16(0), uint32(1599665118), uint32(1599665120),
+			uint8(0), uint8(0x1b), uint8(6), uint8(0), uint16(5000), uint16(22), uint16(0), uint8(0), uint8(0),
+			uint32(0xc0000002), uint32(0xc0800020), uint32(3), uint32(180))
+		v6Record := littleEndianBytes(uint16(10), uint16(80), uint16(0x1|0x2|0x4), uint16(1), uint16(0), uint16(0), uint32(1599665119), uint32(1599665119),
+			uint8(0), uint8(0), uint8(58), uint8(0), uint16(0), uint16(0), uint16(0), uint8(0), uint8(0),
+			uint64(0x20010db800000000), uint64(1), uint64(0x20010db800000000), uint64(2), uint64(1), uint64(5000000000))
+		// An extension map, which isn't needed to read the records
+		extensionMap := littleEndianBytes(uint16(2), uint16(12), uint16(1), uint16(4), uint16(4), uint16(0))
+		v6Conn := engine.Connection{
+			Timestamp: "1599665119.000000",
+			Source: net.ParseIP("2001:db8::1"),
+			Destination: net.ParseIP("2001:db8::2"),
+			Protocol: "ICMPV6",
+			Fields: map[string]string{"bytes": "5000000000", "packets": "1", "tcp_flags": "0"},
+		}
+
+		it("decodes the common records of its data blocks, skipping the other records and blocks", func() {
+			catalog := [][]byte{{3}, make([]byte, 16)}
+			file := nfcapdFile(0, [][]byte{{2}, extensionMap, v4Record}, catalog, [][]byte{{2}, v6Record})
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(file))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn, v6Conn}, connections)
+		})
+
+		it("returns an error for a compressed file", func() {
+			_, err := netFlowRW.ReadStream(bytes.NewReader(nfcapdFile(0x1, [][]byte{{2}, v4Record})))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "compressed nfcapd files aren't read")
+		})
+
+		it("returns an error for a truncated record", func() {
+			_, err := netFlowRW.ReadStream(bytes.NewReader(nfcapdFile(0, [][]byte{{2}, v4Record[:40]})))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "has an improper size 48")
+		})
+	})
+
+	when("a capture of the collector port", func() {
+		// A NetFlow v9 template of an IPv6 ICMPv6 flow, whose start is relative to the exporter's uptime
+		v9Template := flowSet(0, uint16(300), uint16(4), uint16(27), uint16(16), uint16(28), uint16(16), uint16(4), uint16(1), uint16(22), uint16(4))
+		v9Data := flowSet(300, []byte(net.ParseIP("2001:db8::1")), []byte(net.ParseIP("2001:db8::2")), uint8(58), uint32(9000), make([]byte, 3))
+		v9 := func(sets ...[]byte) []byte {
+			return flowBytes(uint16(9), uint16(len(sets)), uint32(10000), uint32(1599665120), uint32(1), uint32(0), bytes.Join(sets, nil))
+		}
+		v9Conn := engine.Connection{
+			Timestamp: "1599665119.000000",
+			Source: net.ParseIP("2001:db8::1"),
+			Destination: net.ParseIP("2001:db8::2"),
+			Protocol: "ICMPV6",
+		}
+
+		it("decodes NetFlow v5, v9 and IPFIX datagrams, keeping the templates of each exporter", func() {
+			pcap := capture(net.ParseIP("10.0.0.1"), 2055, v9(v9Template), v5, v9(v9Data), ipfix(ipfixTemplate, ipfixData))
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(pcap))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn, v9Conn, ipfixConn}, connections)
+		})
+
+		it("only decodes the datagrams sent to the ports, when they are set", func() {
+			pcap := capture(net.ParseIP("10.0.0.1"), 9995, v5)
+			portsRW := engine.ConnectionsReadWriter{InputFormat: engine.NetFlowFormat, NetFlowPorts: []int{2055}}
+			connections, err := portsRW.ReadStream(bytes.NewReader(pcap))
+			assert.Nil(t, err)
+			assert.Empty(t, connections)
+
+			portsRW.NetFlowPorts = []int{2055, 9995}
+			connections, err = portsRW.ReadStream(bytes.NewReader(pcap))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn}, connections)
+		})
+
+		it("skips datagrams which aren't exports", func() {
+			pcap := capture(net.ParseIP("10.0.0.1"), 2055, []byte("not a flow"), v5)
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(pcap))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn}, connections)
+		})
+
+		it("skips the first fragment of a fragmented datagram, as well as the later ones", func() {
+			pcap := capture(net.ParseIP("10.0.0.1"), 2055, v5, v5)
+			// The more fragments flag of the first datagram, after the pcap, record and Ethernet headers
+			binary.BigEndian.PutUint16(pcap[24+16+14+6:], 0x2000)
+			connections, err := netFlowRW.ReadStream(bytes.NewReader(pcap))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{v5Conn}, connections)
+		})
+	})
+
+	it("returns an error for an unknown input format", func() {
+		unknownRW := engine.ConnectionsReadWriter{InputFormat: "sflow"}
+		_, err := unknownRW.ReadStream(bytes.NewReader(v5))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "unknown input format sflow")
+	})
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// nfcapdMagic begins the files of nfcapd (and nfdump), in the byte order of the host which wrote them
+var nfcapdMagic uint16 = 0xa50c
+
+// nfcapdLayoutVersion is the layout of the files of nfdump 1.6, which is the one read
+var nfcapdLayoutVersion uint16 = 1
+
+// The lengths of the file header (with its 128 byte ident), the stat record which follows it, and the header of each
+// data block
+var (
+	nfcapdHeaderLength = 140
+	nfcapdStatLength = 136
+	nfcapdBlockHeaderLength = 12
+)
+
+// The file flags of the compressed files (by LZO, bzip2 or LZ4), whose blocks aren't read
+var nfcapdCompressed uint32 = 0x1 | 0x8 | 0x10
+
+// nfcapdDataBlock is the type of the blocks of flow records, while the others (e.g. the catalog) are skipped
+var nfcapdDataBlock uint16 = 2
+
+// nfcapdCommonRecord is the type of the flow records, while the others (e.g. extension maps and exporter records) are
+// skipped
+var nfcapdCommonRecord uint16 = 10
+
+// The flags of a common record, which set the length of its addresses and counters
+var (
+	nfcapdIPv6Flag uint16 = 0x1
+	nfcapdPackets64Flag uint16 = 0x2
+	nfcapdBytes64Flag uint16 = 0x4
+)
+
+// nfcapdCommonLength is the length of a common record before its addresses
+var nfcapdCommonLength = 32
+
+// isNfcapd returns true if the data begins with the magic of an nfcapd file, in either byte order
+func isNfcapd(data []byte) bool {
+	return len(data) >= 2 && (binary.LittleEndian.Uint16(data) == nfcapdMagic || binary.BigEndian.Uint16(data) == nfcapdMagic)
+}
+
+// decodeNfcapd decodes the flow records of an uncompressed nfcapd file of nfdump 1.6. Each record begins with its
+// addresses, packets and bytes, so the extension maps which describe the optional fields after them aren't needed.
+func decodeNfcapd(data []byte) ([]Connection, error) {
+	if len(data) < nfcapdHeaderLength+nfcapdStatLength {
+		return nil, errors.New("truncated nfcapd file header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint16(data) != nfcapdMagic {
+		order = binary.BigEndian
+	}
+	if version := order.Uint16(data[2:]); version != nfcapdLayoutVersion {
+		return nil, errors.Errorf("unsupported nfcapd layout version %d, only the layout %d of nfdump 1.6 is read", version, nfcapdLayoutVersion)
+	}
+	if flags := order.Uint32(data[4:]); flags&nfcapdCompressed != 0 {
+		return nil, errors.New("compressed nfcapd files aren't read, they can be uncompressed with `nfdump -r <file> -w <uncompressed file>`")
+	}
+	blocks := int(order.Uint32(data[8:]))
+
+	var connections []Connection
+	offset := nfcapdHeaderLength + nfcapdStatLength
+	for i := 0; i < blocks; i++ {
+		if len(data)-offset < nfcapdBlockHeaderLength {
+			return connections, errors.Errorf("truncated nfcapd block at offset %d", offset)
+		}
+		records := int(order.Uint32(data[offset:]))
+		size := int(order.Uint32(data[offset+4:]))
+		id := order.Uint16(data[offset+8:])
+		start := offset + nfcapdBlockHeaderLength
+		if size > len(data)-start {
+			return connections, errors.Errorf("nfcapd block at offset %d has %d byte(s), but only %d are left", offset, size, len(data)-start)
+		}
+		offset = start + size
+		if id != nfcapdDataBlock {
+			continue
+		}
+
+		conns, err := decodeNfcapdBlock(order, data[start:offset], records)
+		if err != nil {
+			return connections, errors.Wrapf(err, "nfcapd block at offset %d", start-nfcapdBlockHeaderLength)
+		}
+		connections = append(connections, conns...)
+	}
+	return connections, nil
+}
+
+// decodeNfcapdBlock decodes the common records of a data block, skipping its other records
+func decodeNfcapdBlock(order binary.ByteOrder, block []byte, records int) ([]Connection, error) {
+	var connections []Connection
+	for i, offset := 0, 0; i < records; i++ {
+		if len(block)-offset < 4 {
+			return connections, errors.Errorf("truncated record at offset %d", offset)
+		}
+		recordType, size := order.Uint16(block[offset:]), int(order.Uint16(block[offset+2:]))
+		if size < 4 || size > len(block)-offset {
+			return connections, errors.Errorf("record at offset %d has an improper size %d", offset, size)
+		}
+		record := block[offset : offset+size]
+		offset += size
+		if recordType != nfcapdCommonRecord {
+			continue
+		}
+
+		conn, ok := nfcapdConnection(order, record)
+		if !ok {
+			return connections, errors.Errorf("common record at offset %d is too short for its addresses and counters", offset-size)
+		}
+		connections = append(connections, conn)
+	}
+	return connections, nil
+}
+
+// nfcapdConnection creates a Connection from a common record
+func nfcapdConnection(order binary.ByteOrder, record []byte) (Connection, bool) {
+	if len(record) < nfcapdCommonLength {
+		return Connection{}, false
+	}
+	flags := order.Uint16(record[4:])
+	addressLength, packetsLength, bytesLength := net.IPv4len, 4, 4
+	if flags&nfcapdIPv6Flag != 0 {
+		addressLength = net.IPv6len
+	}
+	if flags&nfcapdPackets64Flag != 0 {
+		packetsLength = 8
+	}
+	if flags&nfcapdBytes64Flag != 0 {
+		bytesLength = 8
+	}
+	if len(record) < nfcapdCommonLength+2*addressLength+packetsLength+bytesLength {
+		return Connection{}, false
+	}
+
+	first := time.Unix(int64(order.Uint32(record[12:])), int64(order.Uint16(record[8:]))*int64(time.Millisecond))
+	addresses := record[nfcapdCommonLength:]
+	counters := addresses[2*addressLength:]
+	return Connection{
+		Timestamp: epochTimestamp(first),
+		Source: nfcapdIP(order, addresses[:addressLength]),
+		SourcePort: int(order.Uint16(record[24:])),
+		Destination: nfcapdIP(order, addresses[addressLength:2*addressLength]),
+		DestinationPort: int(order.Uint16(record[26:])),
+		Protocol: protocolName(int(record[22])),
+		Fields: map[string]string{
+			"bytes": strconv.FormatUint(nfcapdUint(order, counters[packetsLength:packetsLength+bytesLength]), 10),
+			"packets": strconv.FormatUint(nfcapdUint(order, counters[:packetsLength]), 10),
+			"tcp_flags": strconv.Itoa(int(record[21])),
+		},
+	}, true
+}
+
+// nfcapdIP reads an address of a record, which nfdump stores as a 32 bit integer (for IPv4) or two 64 bit integers
+// (for IPv6) in the byte order of the file
+func nfcapdIP(order binary.ByteOrder, b []byte) net.IP {
+	ip := make([]byte, len(b))
+	if len(b) == net.IPv4len {
+		binary.BigEndian.PutUint32(ip, order.Uint32(b))
+	} else {
+		binary.BigEndian.PutUint64(ip, order.Uint64(b))
+		binary.BigEndian.PutUint64(ip[8:], order.Uint64(b[8:]))
+	}
+	return copyIP(ip)
+}
+
+// nfcapdUint reads a 32 or 64 bit counter of a record
+func nfcapdUint(order binary.ByteOrder, b []byte) uint64 {
+	if len(b) == 8 {
+		return order.Uint64(b)
+	}
+	return uint64(order.Uint32(b))
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+var NewTupleAlert = "NEW_TUPLE"
+
+// NoveltyDetector flags the (source, destination, destination port, protocol) tuples which are absent from a Baseline,
+// i.e. a host talking to another host on a port it had never used before
+type NoveltyDetector struct {
+	baseline *Baseline
+	// maxAge treats baseline tuples which weren't seen for longer than it before a Connection as absent. 0 never does.
+	maxAge time.Duration
+	tuples map[string]*noveltyGroup
+	keys   []string
+	// full is set once maxDetectorGroups novel tuples are tracked, after which new ones are dropped
+	full bool
+}
+
+// noveltyGroup holds the Connections of a single novel tuple
+type noveltyGroup struct {
+	first Connection
+	last  Connection
+	count int
+}
+
+// NewNoveltyDetector returns a NoveltyDetector which flags the tuples absent from the baseline
+func NewNoveltyDetector(baseline *Baseline, maxAge time.Duration) *NoveltyDetector {
+	return &NoveltyDetector{baseline: baseline, maxAge: maxAge, tuples: map[string]*noveltyGroup{}}
+}
+
+// Observe records the Connection if its tuple is absent from the baseline. The novel tuples are only alerted on once
+// the stream ends, so that each is reported once, with all of its Connections counted.
+func (n *NoveltyDetector) Observe(conn Connection) []Alert {
+	key := tupleKey(conn)
+	if group, ok := n.tuples[key]; ok {
+		group.count++
+		group.last = conn
+		return nil
+	}
+
+	if entry, ok := n.baseline.Get(conn); ok {
+		ts, err := conn.Time()
+		if n.maxAge <= 0 || err != nil || ts.Sub(entry.LastSeen) <= n.maxAge {
+			return nil
+		}
+	}
+
+	if len(n.tuples) >= maxDetectorGroups {
+		if !n.full {
+			log.Printf("Too many new tuples, only the first %d are alerted on \n", maxDetectorGroups)
+			n.full = true
+		}
+		return nil
+	}
+	n.tuples[key] = &noveltyGroup{first: conn, last: conn, count: 1}
+	n.keys = append(n.keys, key)
+	return nil
+}
+
+// Report returns the Alerts of the novel tuples seen so far, see Flush
+func (n *NoveltyDetector) Report() []Alert {
+	return n.Flush()
+}
+
+// Flush returns an Alert for each novel tuple, in the order they were first seen
+func (n *NoveltyDetector) Flush() []Alert {
+	var alerts []Alert
+	for _, key := range n.keys {
+		group := n.tuples[key]
+		conn := group.first
+		alerts = append(alerts, Alert{
+			Type:        NewTupleAlert,
+			Key:         fmt.Sprintf("source %s -> %s:%d/%s", conn.Source, conn.Destination, conn.DestinationPort, conn.Protocol),
+			Count:       group.count,
+			FirstSeen:   conn.Timestamp,
+			LastSeen:    group.last.Timestamp,
+			Connections: []Connection{conn},
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,91 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestNoveltyDetector(t *testing.T) {
+	spec.Run(t, "NoveltyDetector", testNoveltyDetector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testNoveltyDetector(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir   string
+		baseline *engine.Baseline
+	)
+
+	connection := func(timestamp string, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       timestamp,
+			Source:          net.ParseIP("10.0.0.1"),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "novelty")
+		assert.Nil(t, err)
+		baseline, err = engine.OpenBaseline(filepath.Join(tmpDir, "baseline.db"))
+		assert.Nil(t, err)
+
+		_, err = baseline.Learn([]engine.Connection{
+			connection("1000", "10.0.0.2", 22),
+			connection("1000", "10.0.0.3", 443),
+		})
+		assert.Nil(t, err)
+	})
+
+	it.After(func() {
+		assert.Nil(t, baseline.Close())
+		assert.Nil(t, os.RemoveAll(tmpDir))
+	})
+
+	when("a tuple is absent from the baseline", func() {
+		it("flags it once, with all of its connections counted", func() {
+			conns := []engine.Connection{
+				connection("2000", "10.0.0.2", 22),
+				connection("2001", "10.0.0.2", 3389),
+				connection("2002", "10.0.0.3", 443),
+				connection("2003", "10.0.0.2", 3389),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewNoveltyDetector(baseline, 0))
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.NewTupleAlert,
+				Key:         "source 10.0.0.1 -> 10.0.0.2:3389/TCP",
+				Count:       2,
+				FirstSeen:   "2001",
+				LastSeen:    "2003",
+				Connections: []engine.Connection{conns[1]},
+			}}, result.Alerts)
+		})
+	})
+
+	when("a baseline tuple is older than the max age", func() {
+		it("flags it as new again", func() {
+			conns := []engine.Connection{
+				connection("2000", "10.0.0.2", 22),
+				connection("9000", "10.0.0.3", 443),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewNoveltyDetector(baseline, time.Hour))
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, "source 10.0.0.1 -> 10.0.0.3:443/TCP", result.Alerts[0].Key)
+		})
+	})
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The modes of reading a capture: a Connection for each flow, or for each packet, see ConnectionsReadWriter.CaptureMode
+var (
+	FlowMode = "flow"
+	PacketMode = "packet"
+)
+
+// DefaultFlowTimeout is the time a flow may be idle before its next packet starts a new flow, see
+// ConnectionsReadWriter.FlowTimeout
+var DefaultFlowTimeout = 2 * time.Minute
+
+// The TCP flags which tell the direction and end of a flow
+var (
+	tcpFIN uint8 = 0x01
+	tcpSYN uint8 = 0x02
+	tcpRST uint8 = 0x04
+	tcpACK uint8 = 0x10
+)
+
+// The IP protocol numbers of the transport layers which are decoded, besides UDP
+var (
+	icmpProtocol uint8 = 1
+	tcpProtocol uint8 = 6
+	icmpv6Protocol uint8 = 58
+)
+
+// packetConnection is the Connection of a single captured packet, from its sender to its receiver
+type packetConnection struct {
+	Connection
+	Time time.Time
+	// Length is the length of the IP packet, or of the ARP message
+	Length int
+	// Flags holds the TCP flags of a TCP segment
+	Flags uint8
+}
+
+// decodeConnection decodes the Connection of an IPv4, IPv6 or ARP packet, and returns false for other packets, and for
+// the fragments whose transport header is in another packet
+func decodeConnection(packet capturedPacket) (packetConnection, bool) {
+	etherType, data, ok := decodeLinkLayer(packet)
+	if !ok {
+		return packetConnection{}, false
+	}
+	if etherType == arpEtherType {
+		return decodeARP(packet.Time, data)
+	}
+
+	ip, ok := decodeIP(packet)
+	if !ok || ip.LaterFragment {
+		return packetConnection{}, false
+	}
+	conn := packetConnection{
+		Connection: Connection{
+			Source: ip.Source,
+			Destination: ip.Destination,
+			Protocol: protocolName(int(ip.Protocol)),
+		},
+		Time: packet.Time,
+		Length: ip.Length,
+	}
+
+	payload := ip.Payload
+	switch ip.Protocol {
+	case tcpProtocol:
+		if len(payload) < 14 {
+			return packetConnection{}, false
+		}
+		conn.SourcePort, conn.DestinationPort = int(binary.BigEndian.Uint16(payload)), int(binary.BigEndian.Uint16(payload[2:]))
+		conn.Flags = payload[13]
+	case udpProtocol:
+		source, destination, _, ok := decodeUDP(payload)
+		if !ok {
+			return packetConnection{}, false
+		}
+		conn.SourcePort, conn.DestinationPort = source, destination
+	case icmpProtocol, icmpv6Protocol:
+		if len(payload) < 2 {
+			return packetConnection{}, false
+		}
+		conn.Fields = map[string]string{"icmp_type": strconv.Itoa(int(payload[0])), "icmp_code": strconv.Itoa(int(payload[1]))}
+	}
+	return conn, true
+}
+
+// decodeARP decodes the Connection of an ARP message, from its sender's address to its target's address
+func decodeARP(at time.Time, data []byte) (packetConnection, bool) {
+	if len(data) < 8 {
+		return packetConnection{}, false
+	}
+	hardwareLength, protocolLength := int(data[4]), int(data[5])
+	length := 8 + 2*hardwareLength + 2*protocolLength
+	if binary.BigEndian.Uint16(data[2:]) != ipv4EtherType || protocolLength != net.IPv4len || len(data) < length {
+		return packetConnection{}, false
+	}
+
+	operation := "request"
+	if binary.BigEndian.Uint16(data[6:]) == 2 {
+		operation = "reply"
+	}
+	sender := data[8+hardwareLength : 8+hardwareLength+protocolLength]
+	target := data[8+2*hardwareLength+protocolLength : length]
+	return packetConnection{
+		Connection: Connection{
+			Source: copyIP(sender),
+			Destination: copyIP(target),
+			Protocol: "ARP",
+			Fields: map[string]string{"arp_operation": operation},
+		},
+		Time: at,
+		Length: length,
+	}, true
+}
+
+// flow aggregates the packets between two endpoints, from the one which initiated it
+type flow struct {
+	conn Connection
+	first time.Time
+	last time.Time
+	packets int
+	bytes int
+	flags uint8
+	// closed is set once a TCP flow was closed (by a FIN or RST), so that a new SYN starts a new flow
+	closed bool
+}
+
+// flowKey is the same for the packets of both directions of a flow
+func flowKey(conn Connection) string {
+	a := net.JoinHostPort(conn.Source.String(), strconv.Itoa(conn.SourcePort))
+	b := net.JoinHostPort(conn.Destination.String(), strconv.Itoa(conn.DestinationPort))
+	if a > b {
+		a, b = b, a
+	}
+	return conn.Protocol + " " + a + " " + b
+}
+
+// reversed returns the Connection from its receiver to its sender
+func reversed(conn Connection) Connection {
+	conn.Source, conn.Destination = conn.Destination, conn.Source
+	conn.SourcePort, conn.DestinationPort = conn.DestinationPort, conn.SourcePort
+	return conn
+}
+
+// readCapture reads the Connections of the packets of a pcap or pcapng capture, as flows or as packets by the
+// CaptureMode. A flow is from the host which sent its first packet, unless a TCP SYN (or SYN-ACK) tells otherwise, and
+// starts at its first packet.
+func (c ConnectionsReadWriter) readCapture(r *bufio.Reader) ([]Connection, error) {
+	packets, err := newPacketSource(r)
+	if err != nil {
+		return nil, err
+	}
+	timeout := c.FlowTimeout
+	if timeout == 0 {
+		timeout = DefaultFlowTimeout
+	}
+
+	var connections []Connection
+	var flows []*flow
+	active := map[string]*flow{}
+	skipped := 0
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return connections, err
+		}
+
+		conn, ok := decodeConnection(packet)
+		if !ok {
+			skipped++
+			continue
+		}
+		if c.CaptureMode == PacketMode {
+			conn.Timestamp = epochTimestamp(conn.Time)
+			if conn.Fields == nil {
+				conn.Fields = map[string]string{}
+			}
+			conn.Fields["bytes"] = strconv.Itoa(conn.Length)
+			if conn.Protocol == protocolName(int(tcpProtocol)) {
+				conn.Fields["tcp_flags"] = strconv.Itoa(int(conn.Flags))
+			}
+			connections = append(connections, conn.Connection)
+			continue
+		}
+
+		key := flowKey(conn.Connection)
+		syn := conn.Flags&(tcpSYN|tcpACK) == tcpSYN
+		f := active[key]
+		if f != nil && (conn.Time.Sub(f.last) > timeout || (syn && f.closed)) {
+			f = nil
+		}
+		if f == nil {
+			f = &flow{conn: conn.Connection, first: conn.Time}
+			// A SYN-ACK is sent by the responder, when the SYN itself wasn't captured
+			if conn.Flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK {
+				f.conn = reversed(conn.Connection)
+			}
+			active[key] = f
+			flows = append(flows, f)
+		}
+
+		f.last = conn.Time
+		f.packets++
+		f.bytes += conn.Length
+		f.flags |= conn.Flags
+		if conn.Flags&(tcpFIN|tcpRST) != 0 {
+			f.closed = true
+		}
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d captured packet(s), which weren't IPv4, IPv6 or ARP packets, or were fragments \n", skipped)
+	}
+
+	for _, f := range flows {
+		conn := f.conn
+		conn.Timestamp = epochTimestamp(f.first)
+		fields := map[string]string{}
+		for name, value := range conn.Fields {
+			fields[name] = value
+		}
+		fields["packets"] = strconv.Itoa(f.packets)
+		fields["bytes"] = strconv.Itoa(f.bytes)
+		fields["duration"] = strconv.FormatFloat(f.last.Sub(f.first).Seconds(), 'f', -1, 64)
+		if conn.Protocol == protocolName(int(tcpProtocol)) {
+			fields["tcp_flags"] = strconv.Itoa(int(f.flags))
+		}
+		conn.Fields = fields
+		connections = append(connections, conn)
+	}
+	return connections, nil
+}
@@ -0,0 +1,250 @@
+package engine_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestPackets(t *testing.T) {
+	spec.Run(t, "Packets", testPackets, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+// timedFrame is a frame of a capture, captured the milliseconds after the capture's start
+type timedFrame struct {
+	millis int
+	frame []byte
+}
+
+var captureStart = time.Unix(1599665118, 0)
+
+// packetCapture builds a little endian pcap file of the frames, of the link type
+func packetCapture(linkType uint32, frames ...timedFrame) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, linkType})
+	for _, f := range frames {
+		at := captureStart.Add(time.Duration(f.millis) * time.Millisecond)
+		_ = binary.Write(&buf, binary.LittleEndian, []uint32{uint32(at.Unix()), uint32(at.Nanosecond() / 1000), uint32(len(f.frame)), uint32(len(f.frame))})
+		buf.Write(f.frame)
+	}
+	return buf.Bytes()
+}
+
+func ethernet(etherType uint16, payload []byte) []byte {
+	return flowBytes(make([]byte, 12), etherType, payload)
+}
+
+func ipv4(source, destination string, protocol uint8, payload []byte) []byte {
+	return flowBytes(uint8(0x45), uint8(0), uint16(20+len(payload)), uint16(0), uint16(0), uint8(64), protocol, uint16(0),
+		[]byte(net.ParseIP(source).To4()), []byte(net.ParseIP(destination).To4()), payload)
+}
+
+func ipv6(source, destination string, next uint8, payload []byte) []byte {
+	return flowBytes(uint32(0x60000000), uint16(len(payload)), next, uint8(64),
+		[]byte(net.ParseIP(source)), []byte(net.ParseIP(destination)), payload)
+}
+
+func tcp(sourcePort, destinationPort uint16, flags uint8) []byte {
+	return flowBytes(sourcePort, destinationPort, uint32(0), uint32(0), uint8(0x50), flags, uint16(65535), uint16(0), uint16(0))
+}
+
+func udp(sourcePort, destinationPort uint16, payload []byte) []byte {
+	return flowBytes(sourcePort, destinationPort, uint16(8+len(payload)), uint16(0), payload)
+}
+
+func arp(operation uint16, sender, target string) []byte {
+	return flowBytes(uint16(1), uint16(0x0800), uint8(6), uint8(4), operation,
+		make([]byte, 6), []byte(net.ParseIP(sender).To4()), make([]byte, 6), []byte(net.ParseIP(target).To4()))
+}
+
+// tcpFrame is an Ethernet frame of a TCP segment, between the hosts
+func tcpFrame(millis int, source string, sourcePort uint16, destination string, destinationPort uint16, flags uint8) timedFrame {
+	return timedFrame{millis, ethernet(0x0800, ipv4(source, destination, 6, tcp(sourcePort, destinationPort, flags)))}
+}
+
+func testPackets(t *testing.T, when spec.G, it spec.S) {
+	pcapRW := engine.ConnectionsReadWriter{InputFormat: engine.PcapFormat}
+	client, server := "192.0.0.2", "192.128.0.32"
+
+	// A TCP handshake, some data and the close of the connection
+	session := []timedFrame{
+		tcpFrame(0, client, 5000, server, 22, 0x02),
+		tcpFrame(10, server, 22, client, 5000, 0x12),
+		tcpFrame(20, client, 5000, server, 22, 0x10),
+		{30, ethernet(0x0800, ipv4(client, "8.8.8.8", 17, udp(53000, 53, []byte("query"))))},
+		tcpFrame(1500, server, 22, client, 5000, 0x11),
+	}
+	sessionConn := engine.Connection{
+		Timestamp: "1599665118.000000",
+		Source: net.ParseIP(client),
+		SourcePort: 5000,
+		Destination: net.ParseIP(server),
+		DestinationPort: 22,
+		Protocol: "TCP",
+		Fields: map[string]string{"packets": "4", "bytes": "160", "duration": "1.5", "tcp_flags": "19"},
+	}
+	dnsConn := engine.Connection{
+		Timestamp: "1599665118.030000",
+		Source: net.ParseIP(client),
+		SourcePort: 53000,
+		Destination: net.ParseIP("8.8.8.8"),
+		DestinationPort: 53,
+		Protocol: "UDP",
+		Fields: map[string]string{"packets": "1", "bytes": "33", "duration": "0"},
+	}
+
+	when("flows are read", func() {
+		it("reads a Connection for each flow, from its initiator and at its first packet", func() {
+			connections, err := pcapRW.ReadStream(bytes.NewReader(packetCapture(1, session...)))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{sessionConn, dnsConn}, connections)
+		})
+
+		it("detects pcap captures", func() {
+			connections, err := engine.ConnectionsReadWriter{}.ReadStream(bytes.NewReader(packetCapture(1, session...)))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{sessionConn, dnsConn}, connections)
+		})
+
+		it("infers the initiator by the SYN-ACK, when the capture misses the SYN", func() {
+			connections, err := pcapRW.ReadStream(bytes.NewReader(packetCapture(1, session[1], session[2])))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 1)
+			assert.Equal(t, net.ParseIP(client), connections[0].Source)
+			assert.Equal(t, 22, connections[0].DestinationPort)
+			assert.Equal(t, "18", connections[0].Fields["tcp_flags"])
+		})
+
+		it("starts a new flow on a SYN after the flow was closed, or after the flow timeout", func() {
+			frames := append(append([]timedFrame{}, session...),
+				tcpFrame(1600, client, 5000, server, 22, 0x02),
+				tcpFrame(1600+int(engine.DefaultFlowTimeout/time.Millisecond)+1, client, 5000, server, 22, 0x10))
+			connections, err := pcapRW.ReadStream(bytes.NewReader(packetCapture(1, frames...)))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 4)
+			assert.Equal(t, "1599665119.600000", connections[2].Timestamp)
+			assert.Equal(t, "1", connections[2].Fields["packets"])
+			assert.Equal(t, "1", connections[3].Fields["packets"])
+
+			timeoutRW := engine.ConnectionsReadWriter{InputFormat: engine.PcapFormat, FlowTimeout: time.Millisecond}
+			connections, err = timeoutRW.ReadStream(bytes.NewReader(packetCapture(1, session...)))
+			assert.Nil(t, err)
+			assert.Len(t, connections, 5)
+		})
+
+		it("reads IPv6, ICMP and ARP flows, and skips other packets", func() {
+			echo := ipv6("2001:db8::1", "2001:db8::2", 58, flowBytes(uint8(128), uint8(0), make([]byte, 6)))
+			reply := ipv6("2001:db8::2", "2001:db8::1", 58, flowBytes(uint8(129), uint8(0), make([]byte, 6)))
+			frames := []timedFrame{
+				{0, ethernet(0x86dd, echo)},
+				{1, ethernet(0x88cc, make([]byte, 20))},
+				{2, ethernet(0x86dd, reply)},
+				{3, ethernet(0x0806, arp(1, "10.0.0.1", "10.0.0.2"))},
+			}
+			connections, err := pcapRW.ReadStream(bytes.NewReader(packetCapture(1, frames...)))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{
+				{
+					Timestamp: "1599665118.000000",
+					Source: net.ParseIP("2001:db8::1"),
+					Destination: net.ParseIP("2001:db8::2"),
+					Protocol: "ICMPV6",
+					Fields: map[string]string{"icmp_type": "128", "icmp_code": "0", "packets": "2", "bytes": "96", "duration": "0.002"},
+				},
+				{
+					Timestamp: "1599665118.003000",
+					Source: net.ParseIP("10.0.0.1"),
+					Destination: net.ParseIP("10.0.0.2"),
+					Protocol: "ARP",
+					Fields: map[string]string{"arp_operation": "request", "packets": "1", "bytes": "28", "duration": "0"},
+				},
+			}, connections)
+		})
+
+		it("skips the fragments after the first one", func() {
+			fragment := ipv4(client, server, 17, make([]byte, 16))
+			binary.BigEndian.PutUint16(fragment[6:], 185)
+			frames := []timedFrame{session[3], {40, ethernet(0x0800, fragment)}}
+			connections, err := pcapRW.ReadStream(bytes.NewReader(packetCapture(1, frames...)))
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{dnsConn}, connections)
+		})
+	})
+
+	it("reads a Connection for each packet, in the packet mode", func() {
+		packetRW := engine.ConnectionsReadWriter{InputFormat: engine.PcapFormat, CaptureMode: engine.PacketMode}
+		connections, err := packetRW.ReadStream(bytes.NewReader(packetCapture(1, session[1], session[3])))
+		assert.Nil(t, err)
+		assert.Equal(t, []engine.Connection{
+			{
+				Timestamp: "1599665118.010000",
+				Source: net.ParseIP(server),
+				SourcePort: 22,
+				Destination: net.ParseIP(client),
+				DestinationPort: 5000,
+				Protocol: "TCP",
+				Fields: map[string]string{"bytes": "40", "tcp_flags": "18"},
+			},
+			{
+				Timestamp: "1599665118.030000",
+				Source: net.ParseIP(client),
+				SourcePort: 53000,
+				Destination: net.ParseIP("8.8.8.8"),
+				DestinationPort: 53,
+				Protocol: "UDP",
+				Fields: map[string]string{"bytes": "33"},
+			},
+		}, connections)
+	})
+
+	it("reads pcapng captures, with the timestamp resolution of their interfaces", func() {
+		block := func(blockType uint32, body []byte) []byte {
+			padded := append(body, make([]byte, (4-len(body)%4)%4)...)
+			length := uint32(12 + len(padded))
+			var buf bytes.Buffer
+			_ = binary.Write(&buf, binary.LittleEndian, blockType)
+			_ = binary.Write(&buf, binary.LittleEndian, length)
+			buf.Write(padded)
+			_ = binary.Write(&buf, binary.LittleEndian, length)
+			return buf.Bytes()
+		}
+		little := func(values ...interface{}) []byte {
+			var buf bytes.Buffer
+			for _, value := range values {
+				_ = binary.Write(&buf, binary.LittleEndian, value)
+			}
+			return buf.Bytes()
+		}
+
+		// A raw IP interface with nanosecond timestamps, and a packet of it
+		packet := ipv4(client, server, 6, tcp(5000, 22, 0x02))
+		nanos := uint64(captureStart.UnixNano() + 250000)
+		pcapng := bytes.Join([][]byte{
+			block(0x0a0d0d0a, little(uint32(0x1a2b3c4d), uint16(1), uint16(0), int64(-1))),
+			block(0x00000001, little(uint16(101), uint16(0), uint32(0), uint16(9), uint16(1), uint8(9), make([]byte, 3), uint32(0))),
+			block(0x00000006, append(little(uint32(0), uint32(nanos>>32), uint32(nanos), uint32(len(packet)), uint32(len(packet))), packet...)),
+		}, nil)
+
+		connections, err := engine.ConnectionsReadWriter{}.ReadStream(bytes.NewReader(pcapng))
+		assert.Nil(t, err)
+		assert.Len(t, connections, 1)
+		assert.Equal(t, "1599665118.000250", connections[0].Timestamp)
+		assert.Equal(t, net.ParseIP(client), connections[0].Source)
+		assert.Equal(t, 22, connections[0].DestinationPort)
+	})
+
+	it("returns an error for a truncated capture", func() {
+		pcap := packetCapture(1, session...)
+		_, err := pcapRW.ReadStream(bytes.NewReader(pcap[:len(pcap)-10]))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "truncated pcap packet")
+	})
+}
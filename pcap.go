@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The magic numbers of a pcap file, whose byte order is the file's byte order, and which tell the precision of its
+// timestamps
+var (
+	pcapMicroMagic uint32 = 0xa1b2c3d4
+	pcapNanoMagic uint32 = 0xa1b23c4d
+)
+
+// The link types of the captured packets which are decoded, see decodeIP
+var (
+	linkTypeNull uint32 = 0
+	linkTypeEthernet uint32 = 1
+	linkTypeRaw uint32 = 101
+	linkTypeLinuxSLL uint32 = 113
+	linkTypeLinuxSLL2 uint32 = 276
+)
+
+// udpProtocol is the IP protocol number of UDP
+var udpProtocol uint8 = 17
+
+// capturedPacket is a packet of a capture file, with the time it was captured
+type capturedPacket struct {
+	Time time.Time
+	LinkType uint32
+	Data []byte
+}
+
+// pcapReader reads the packets of a classic pcap file
+type pcapReader struct {
+	r io.Reader
+	order binary.ByteOrder
+	nano bool
+	linkType uint32
+}
+
+// isPcap peeks at the beginning of a stream, and returns true if it begins with the magic number of a pcap file, in
+// either byte order
+func isPcap(r *bufio.Reader) bool {
+	peeked, err := r.Peek(4)
+	if err != nil {
+		return false
+	}
+	for _, magic := range []uint32{pcapMicroMagic, pcapNanoMagic} {
+		if binary.BigEndian.Uint32(peeked) == magic || binary.LittleEndian.Uint32(peeked) == magic {
+			return true
+		}
+	}
+	return false
+}
+
+// newPcapReader reads the global header of a pcap file
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.Wrap(err, "failed to read pcap header")
+	}
+
+	p := &pcapReader{r: r}
+	switch {
+	case binary.BigEndian.Uint32(header) == pcapMicroMagic || binary.BigEndian.Uint32(header) == pcapNanoMagic:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == pcapMicroMagic || binary.LittleEndian.Uint32(header) == pcapNanoMagic:
+		p.order = binary.LittleEndian
+	default:
+		return nil, errors.Errorf("invalid pcap magic number %x", header[:4])
+	}
+	p.nano = p.order.Uint32(header) == pcapNanoMagic
+	p.linkType = p.order.Uint32(header[20:]) & 0xffff
+	return p, nil
+}
+
+// next returns the next packet of the file, and io.EOF once there are none
+func (p *pcapReader) next() (capturedPacket, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return capturedPacket{}, errors.New("truncated pcap packet header")
+		}
+		return capturedPacket{}, err
+	}
+
+	length := p.order.Uint32(header[8:])
+	// Snapshot lengths are far below this, so a longer packet means the file is corrupt
+	if length > 256*1024 {
+		return capturedPacket{}, errors.Errorf("invalid pcap packet length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return capturedPacket{}, errors.Wrap(err, "truncated pcap packet")
+	}
+
+	fraction := int64(p.order.Uint32(header[4:]))
+	if !p.nano {
+		fraction *= 1000
+	}
+	return capturedPacket{
+		Time: time.Unix(int64(p.order.Uint32(header)), fraction).UTC(),
+		LinkType: p.linkType,
+		Data: data,
+	}, nil
+}
+
+// ipPacket is the network layer of a captured packet
+type ipPacket struct {
+	Source net.IP
+	Destination net.IP
+	Protocol uint8
+	// Fragment is set for the fragments of a packet, whose payload can't be decoded on its own
+	Fragment bool
+	// LaterFragment is set for the fragments of a packet after the first one, whose payload doesn't begin with the
+	// header of the transport layer
+	LaterFragment bool
+	// Length is the length of the whole packet, by its header
+	Length int
+	Payload []byte
+}
+
+// The EtherTypes of the network layers which are decoded
+var (
+	ipv4EtherType uint16 = 0x0800
+	arpEtherType uint16 = 0x0806
+	ipv6EtherType uint16 = 0x86dd
+)
+
+// decodeIP decodes the IPv4 or IPv6 packet of a captured packet, and returns false for other packets (e.g. ARP)
+func decodeIP(packet capturedPacket) (ipPacket, bool) {
+	etherType, data, ok := decodeLinkLayer(packet)
+	if !ok {
+		return ipPacket{}, false
+	}
+	switch etherType {
+	case ipv4EtherType:
+		return decodeIPv4(data)
+	case ipv6EtherType:
+		return decodeIPv6(data)
+	}
+	return ipPacket{}, false
+}
+
+// decodeLinkLayer returns the EtherType and payload of a captured packet. The link types without an EtherType only
+// carry IP packets, whose EtherType is guessed by their version.
+func decodeLinkLayer(packet capturedPacket) (uint16, []byte, bool) {
+	data := packet.Data
+	var etherType uint16
+	switch packet.LinkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return 0, nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		// VLAN tags are skipped, including stacked ones
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return 0, nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return 0, nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data), data[20:]
+	case linkTypeNull:
+		// The address family is in the byte order of the capturing host, and is only used to skip the header
+		if len(data) < 4 {
+			return 0, nil, false
+		}
+		data = data[4:]
+	case linkTypeRaw:
+	default:
+		return 0, nil, false
+	}
+	if len(data) == 0 {
+		return 0, nil, false
+	}
+
+	if etherType == 0 {
+		switch data[0] >> 4 {
+		case 4:
+			etherType = ipv4EtherType
+		case 6:
+			etherType = ipv6EtherType
+		}
+	}
+	return etherType, data, true
+}
+
+func decodeIPv4(data []byte) (ipPacket, bool) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return ipPacket{}, false
+	}
+	headerLength := int(data[0]&0x0f) * 4
+	totalLength := int(binary.BigEndian.Uint16(data[2:]))
+	if headerLength < 20 || totalLength < headerLength || len(data) < headerLength {
+		return ipPacket{}, false
+	}
+	// The capture may hold padding after the packet, or only part of it
+	if totalLength < len(data) {
+		data = data[:totalLength]
+	}
+
+	flags := binary.BigEndian.Uint16(data[6:])
+	return ipPacket{
+		Source: copyIP(data[12:16]),
+		Destination: copyIP(data[16:20]),
+		Protocol: data[9],
+		Fragment: flags&0x2000 != 0 || flags&0x1fff != 0,
+		LaterFragment: flags&0x1fff != 0,
+		Length: totalLength,
+		Payload: data[headerLength:],
+	}, true
+}
+
+// decodeIPv6 decodes an IPv6 packet, skipping its common extension headers
+func decodeIPv6(data []byte) (ipPacket, bool) {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return ipPacket{}, false
+	}
+	packet := ipPacket{
+		Source: copyIP(data[8:24]),
+		Destination: copyIP(data[24:40]),
+		Protocol: data[6],
+		Length: 40 + int(binary.BigEndian.Uint16(data[4:])),
+	}
+	payload := data[40:]
+	if length := packet.Length - 40; length < len(payload) {
+		payload = payload[:length]
+	}
+
+	for {
+		switch packet.Protocol {
+		// Hop-by-hop, routing and destination options
+		case 0, 43, 60:
+			if len(payload) < 8 {
+				return ipPacket{}, false
+			}
+			length := (int(payload[1]) + 1) * 8
+			if len(payload) < length {
+				return ipPacket{}, false
+			}
+			packet.Protocol, payload = payload[0], payload[length:]
+		// Fragment
+		case 44:
+			if len(payload) < 8 {
+				return ipPacket{}, false
+			}
+			packet.Fragment = true
+			packet.LaterFragment = binary.BigEndian.Uint16(payload[2:])>>3 != 0
+			packet.Protocol, payload = payload[0], payload[8:]
+		default:
+			packet.Payload = payload
+			return packet, true
+		}
+	}
+}
+
+// copyIP copies an address out of a packet, in the 16 byte form net.ParseIP returns, so that the Connections compare
+// equal to those of the other formats
+func copyIP(b []byte) net.IP {
+	return net.IP(append([]byte{}, b...)).To16()
+}
+
+// decodeUDP decodes the ports and payload of a UDP datagram
+func decodeUDP(data []byte) (int, int, []byte, bool) {
+	if len(data) < 8 {
+		return 0, 0, nil, false
+	}
+	payload := data[8:]
+	if length := int(binary.BigEndian.Uint16(data[4:])); length >= 8 && length-8 < len(payload) {
+		payload = payload[:length-8]
+	}
+	return int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:])), payload, true
+}
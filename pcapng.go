@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The types of the pcapng blocks which are read, while the rest are skipped
+var (
+	sectionHeaderBlock uint32 = 0x0a0d0d0a
+	interfaceDescriptionBlock uint32 = 0x00000001
+	obsoletePacketBlock uint32 = 0x00000002
+	simplePacketBlock uint32 = 0x00000003
+	enhancedPacketBlock uint32 = 0x00000006
+)
+
+// pcapngByteOrderMagic is written in the byte order of the section, after its header's type and length
+var pcapngByteOrderMagic uint32 = 0x1a2b3c4d
+
+// The options of an interface description, which set the resolution and offset of its timestamps
+var (
+	tsResolutionOption uint16 = 9
+	tsOffsetOption uint16 = 14
+)
+
+// maxBlockLength is the longest pcapng block which is read, so that a corrupt length fails clearly
+var maxBlockLength uint32 = 16 * 1024 * 1024
+
+// pcapngInterface is an interface of a pcapng section, whose packets share its link type and timestamp resolution
+type pcapngInterface struct {
+	linkType uint32
+	snapLength uint32
+	// unitsPerSecond is the resolution of the timestamps, which are microseconds unless the interface sets another
+	unitsPerSecond uint64
+	offset int64
+}
+
+// pcapngReader reads the packets of a pcapng file, of any number of sections and interfaces
+type pcapngReader struct {
+	r io.Reader
+	order binary.ByteOrder
+	interfaces []pcapngInterface
+	// last is the time of the last packet, which is the time of the simple packets, as they have no timestamp
+	last time.Time
+}
+
+// packetSource is a capture file, whose packets are read one by one
+type packetSource interface {
+	// next returns the next packet of the file, and io.EOF once there are none
+	next() (capturedPacket, error)
+}
+
+// isPcapng peeks at the beginning of a stream, and returns true if it begins with a pcapng section header, whose type
+// is the same in either byte order
+func isPcapng(r *bufio.Reader) bool {
+	peeked, err := r.Peek(4)
+	return err == nil && binary.BigEndian.Uint32(peeked) == sectionHeaderBlock
+}
+
+// isCapture returns true if a stream is a pcap or pcapng capture
+func isCapture(r *bufio.Reader) bool {
+	return isPcap(r) || isPcapng(r)
+}
+
+// newPacketSource returns the reader of a pcap or pcapng capture
+func newPacketSource(r *bufio.Reader) (packetSource, error) {
+	if isPcapng(r) {
+		return &pcapngReader{r: r}, nil
+	}
+	return newPcapReader(r)
+}
+
+func (p *pcapngReader) next() (capturedPacket, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return capturedPacket{}, err
+		}
+
+		switch blockType {
+		case sectionHeaderBlock:
+			// Each section has its own interfaces
+			p.interfaces = nil
+		case interfaceDescriptionBlock:
+			if err := p.readInterface(body); err != nil {
+				return capturedPacket{}, err
+			}
+		case enhancedPacketBlock, obsoletePacketBlock:
+			// Both blocks have the same layout, but the obsolete one has a shorter interface ID, followed by a drops count
+			headerLength := 20
+			if len(body) < headerLength {
+				return capturedPacket{}, errors.New("truncated pcapng packet block")
+			}
+			id := p.order.Uint32(body)
+			if blockType == obsoletePacketBlock {
+				id = uint32(p.order.Uint16(body))
+			}
+			if int(id) >= len(p.interfaces) {
+				return capturedPacket{}, errors.Errorf("pcapng packet of undescribed interface %d", id)
+			}
+			iface := p.interfaces[id]
+			length := int(p.order.Uint32(body[12:]))
+			if length > len(body)-headerLength {
+				return capturedPacket{}, errors.New("truncated pcapng packet block")
+			}
+
+			timestamp := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			seconds, fraction := timestamp/iface.unitsPerSecond, timestamp%iface.unitsPerSecond
+			p.last = time.Unix(int64(seconds)+iface.offset, int64(float64(fraction)*1e9/float64(iface.unitsPerSecond))).UTC()
+			return capturedPacket{
+				Time: p.last,
+				LinkType: iface.linkType,
+				Data: append([]byte{}, body[headerLength:headerLength+length]...),
+			}, nil
+		case simplePacketBlock:
+			if len(p.interfaces) == 0 {
+				return capturedPacket{}, errors.New("pcapng simple packet without an interface")
+			}
+			if len(body) < 4 {
+				return capturedPacket{}, errors.New("truncated pcapng simple packet block")
+			}
+			iface := p.interfaces[0]
+			data := body[4:]
+			length := p.order.Uint32(body)
+			if iface.snapLength != 0 && length > iface.snapLength {
+				length = iface.snapLength
+			}
+			if int(length) < len(data) {
+				data = data[:length]
+			}
+			return capturedPacket{Time: p.last, LinkType: iface.linkType, Data: append([]byte{}, data...)}, nil
+		}
+	}
+}
+
+// readBlock reads the next block, and returns its type and body. A section header block sets the byte order of the
+// blocks which follow it.
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.New("truncated pcapng block header")
+		}
+		return 0, nil, err
+	}
+
+	// The type of a section header is a palindrome, so it is read before the section's byte order is known
+	blockType := binary.BigEndian.Uint32(header)
+	if blockType == sectionHeaderBlock {
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(p.r, magic); err != nil {
+			return 0, nil, errors.New("truncated pcapng section header")
+		}
+		switch {
+		case binary.BigEndian.Uint32(magic) == pcapngByteOrderMagic:
+			p.order = binary.BigEndian
+		case binary.LittleEndian.Uint32(magic) == pcapngByteOrderMagic:
+			p.order = binary.LittleEndian
+		default:
+			return 0, nil, errors.Errorf("invalid pcapng byte order magic %x", magic)
+		}
+		header = append(header, magic...)
+	} else if p.order == nil {
+		return 0, nil, errors.New("pcapng file doesn't begin with a section header")
+	} else {
+		blockType = p.order.Uint32(header)
+	}
+
+	length := p.order.Uint32(header[4:])
+	if length < uint32(len(header))+4 || length%4 != 0 || length > maxBlockLength {
+		return 0, nil, errors.Errorf("invalid pcapng block length %d", length)
+	}
+	rest := make([]byte, length-uint32(len(header)))
+	if _, err := io.ReadFull(p.r, rest); err != nil {
+		return 0, nil, errors.New("truncated pcapng block")
+	}
+	// The block ends with its length again, which isn't part of the body
+	return blockType, rest[:len(rest)-4], nil
+}
+
+// readInterface reads an interface description, and the options which set the resolution and offset of its timestamps
+func (p *pcapngReader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("truncated pcapng interface description")
+	}
+	iface := pcapngInterface{
+		linkType: uint32(p.order.Uint16(body)),
+		snapLength: p.order.Uint32(body[4:]),
+		unitsPerSecond: 1000000,
+	}
+
+	options := body[8:]
+	for len(options) >= 4 {
+		code, length := p.order.Uint16(options), int(p.order.Uint16(options[2:]))
+		if code == 0 || len(options) < 4+length {
+			break
+		}
+		value := options[4 : 4+length]
+		switch {
+		case code == tsResolutionOption && length >= 1:
+			// The resolution is a negative power of 10, or of 2 when its top bit is set
+			base, exponent := 10.0, float64(value[0]&0x7f)
+			if value[0]&0x80 != 0 {
+				base = 2
+			}
+			if units := math.Pow(base, exponent); units >= 1 && units <= 1e18 {
+				iface.unitsPerSecond = uint64(units)
+			}
+		case code == tsOffsetOption && length >= 8:
+			iface.offset = int64(p.order.Uint64(value))
+		}
+		// Option values are padded to 4 bytes, and the padding of the last one may be missing
+		padded := 4 + (length+3)/4*4
+		if padded > len(options) {
+			break
+		}
+		options = options[padded:]
+	}
+
+	p.interfaces = append(p.interfaces, iface)
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"regexp"
 	"strconv"
+	"time"
 )
 
 // Policy contains information about a network policy, and is matched against a Connection to see whether it matches
@@ -12,9 +14,41 @@ type Policy struct {
 	ID        string
 	Name      string
 	IPMap	  map[string]interface{}
+	// Networks holds the networks of the rule's `networks`, which match any address within them, e.g. `10.0.1.0/24`
+	Networks  []*net.IPNet
 	Ports     []Port
 	ProtocolMap map[string]interface{}
+	// Fields holds the values the extra fields of a Connection (e.g. Zeek's `service`) may have, by name, and a
+	// Connection without one of the fields doesn't match
+	Fields    map[string][]string
 	Verdict   string
+	// Threshold is only set for stateful threshold rules, which are matched against a window of Connections, instead
+	// of a single one
+	Threshold *Threshold
+	// Sequence is only set for correlation rules, which are matched against the rules matched by several Connections
+	Sequence *Sequence
+	// Improper holds the criteria which couldn't be parsed (e.g. a threshold or sequence), and a Policy with any of
+	// them never matches, rather than matching each Connection as a stateless rule
+	Improper []string
+	// Score is the weight the rule adds to the risk score of the Connections matching it, when it is set (see Scorer)
+	Score *float64
+	// These describe the rule for the people reviewing its matches, and aren't used for matching
+	Description string
+	Owner       string
+	Tags        []string
+	// Techniques holds the MITRE ATT&CK technique IDs the rule detects, e.g. `T1021.004`
+	Techniques []string
+	// Disabled rules never match, and ValidFrom and ExpiresAt bound the time a rule applies, when they are set (see
+	// ApplyLifecycle)
+	Disabled  bool
+	ValidFrom time.Time
+	ExpiresAt time.Time
+	// ImproperLifecycle holds the valid_from and expires_at values which couldn't be parsed (e.g. `expires_at
+	// 2020-12-31`), and a Policy with any of them never applies, rather than applying for longer than intended
+	ImproperLifecycle []string
+	// connectionClock is set when the time a rule applies is checked against the timestamp of each Connection, rather
+	// than once against the current time
+	connectionClock bool
 }
 
 // Port defines a range of port values
@@ -23,9 +57,13 @@ type Port struct {
 	End   int `json:"end"`
 }
 
+// techniquePattern matches a MITRE ATT&CK technique ID, with an optional sub-technique
+var techniquePattern = regexp.MustCompile(`^T\d{4}(\.\d{3})?$`)
+
 var (
 	IgnoreVerdict = "IGNORE"
 	InspectVerdict = "INSPECT"
+	AlertVerdict = "ALERT"
 )
 
 // NewPolicy accepts a policyJson, and parses it to form a Policy struct
@@ -53,6 +91,16 @@ func NewPolicy(policyJson policyJson) Policy {
 		}
 	}
 
+	// Unlike the IPs, which are simplified to their address, the networks match the whole CIDR
+	for _, cidr := range policyJson.Networks {
+		_, network, err := net.ParseCIDR(fmt.Sprintf("%v", cidr))
+		if err != nil {
+			log.Printf("Improper policy network %s found \n", cidr)
+			continue
+		}
+		newPol.Networks = append(newPol.Networks, network)
+	}
+
 	for _, protocol := range policyJson.Protocols {
 		if newPol.ProtocolMap == nil {
 			newPol.ProtocolMap = make(map[string]interface{})
@@ -60,6 +108,26 @@ func NewPolicy(policyJson policyJson) Policy {
 		newPol.ProtocolMap[fmt.Sprintf("%v", protocol)] = nil
 	}
 
+	if policyJson.Fields != nil {
+		fields, ok := policyJson.Fields.(map[string]interface{})
+		if !ok {
+			log.Printf("Improper policy fields %+v found \n", policyJson.Fields)
+		}
+		for name, values := range fields {
+			list, ok := values.([]interface{})
+			if !ok {
+				// A single value is the same as a list of it
+				list = []interface{}{values}
+			}
+			if newPol.Fields == nil {
+				newPol.Fields = map[string][]string{}
+			}
+			for _, value := range list {
+				newPol.Fields[name] = append(newPol.Fields[name], fmt.Sprintf("%v", value))
+			}
+		}
+	}
+
 	for _, portRange := range policyJson.Ports {
 		portMap, ok := portRange.(map[string]interface{})
 		if ok {
@@ -78,19 +146,136 @@ func NewPolicy(policyJson policyJson) Policy {
 			}
 		}
 	}
+
+	if policyJson.Threshold != nil {
+		threshold, err := newThreshold(policyJson.Threshold)
+		if err != nil {
+			log.Printf("Improper policy threshold %+v found: %s, so '%s' never matches \n", policyJson.Threshold, err, newPol.Name)
+			newPol.Improper = append(newPol.Improper, "threshold: "+err.Error())
+		} else {
+			newPol.Threshold = threshold
+			if newPol.Verdict != AlertVerdict {
+				log.Printf("Threshold policy '%s' has verdict %s, but will only produce %s alerts \n", newPol.Name, newPol.Verdict, AlertVerdict)
+			}
+		}
+	}
+
+	if policyJson.Score != nil {
+		score, err := strconv.ParseFloat(fmt.Sprintf("%v", policyJson.Score), 64)
+		if err != nil || score < 0 {
+			log.Printf("Improper policy score %v found \n", policyJson.Score)
+		} else {
+			newPol.Score = &score
+		}
+	}
+
+	newPol.Description = stringOrEmpty(policyJson.Description)
+	newPol.Owner = stringOrEmpty(policyJson.Owner)
+	for _, tag := range policyJson.Tags {
+		newPol.Tags = append(newPol.Tags, fmt.Sprintf("%v", tag))
+	}
+	for _, technique := range policyJson.Techniques {
+		id := fmt.Sprintf("%v", technique)
+		if !techniquePattern.MatchString(id) {
+			log.Printf("Improper policy technique %s found \n", id)
+			continue
+		}
+		newPol.Techniques = append(newPol.Techniques, id)
+	}
+
+	if policyJson.Enabled != nil {
+		enabled, err := strconv.ParseBool(fmt.Sprintf("%v", policyJson.Enabled))
+		if err != nil {
+			log.Printf("Improper policy enabled flag %v found \n", policyJson.Enabled)
+		} else {
+			newPol.Disabled = !enabled
+		}
+	}
+	var ok bool
+	if newPol.ValidFrom, ok = parsePolicyTime("valid_from", policyJson.ValidFrom); !ok {
+		newPol.ImproperLifecycle = append(newPol.ImproperLifecycle, fmt.Sprintf("valid_from %v", policyJson.ValidFrom))
+	}
+	if newPol.ExpiresAt, ok = parsePolicyTime("expires_at", policyJson.ExpiresAt); !ok {
+		newPol.ImproperLifecycle = append(newPol.ImproperLifecycle, fmt.Sprintf("expires_at %v", policyJson.ExpiresAt))
+	}
+
+	if policyJson.Sequence != nil {
+		sequence, err := newSequence(policyJson.Sequence)
+		if err != nil {
+			log.Printf("Improper policy sequence %+v found: %s, so '%s' never matches \n", policyJson.Sequence, err, newPol.Name)
+			newPol.Improper = append(newPol.Improper, "sequence: "+err.Error())
+		} else {
+			newPol.Sequence = sequence
+		}
+	}
 	return newPol
 }
 
-// Matches a Policy against a Connection, returning true if the Connection matches all set elements of the Policy
+// parsePolicyTime parses an optional RFC 3339 policy timestamp, which is zero when it is missing or improper, and
+// returns false when it is improper
+func parsePolicyTime(field string, value interface{}) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, true
+	}
+	parsed, err := time.Parse(time.RFC3339, fmt.Sprintf("%v", value))
+	if err != nil {
+		log.Printf("Improper policy %s %v found \n", field, value)
+		return time.Time{}, false
+	}
+	return parsed, true
+}
+
+// RuleMetadata returns the IDs of the matched Policies, together with their distinct tags and techniques, in policy
+// order
+func RuleMetadata(matched []Policy) (ids, tags, techniques []string) {
+	for _, policy := range matched {
+		ids = append(ids, policy.ID)
+		for _, tag := range policy.Tags {
+			if !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		for _, technique := range policy.Techniques {
+			if !containsString(techniques, technique) {
+				techniques = append(techniques, technique)
+			}
+		}
+	}
+	return ids, tags, techniques
+}
+
+// ActiveAt returns true if the time is within the Policy's valid_from and expires_at, when they are set
+func (p Policy) ActiveAt(t time.Time) bool {
+	return (p.ValidFrom.IsZero() || !t.Before(p.ValidFrom)) && (p.ExpiresAt.IsZero() || t.Before(p.ExpiresAt))
+}
+
+// stateful returns true if the Policy is matched against several Connections together, rather than against each one
+func (p Policy) stateful() bool {
+	return p.Threshold != nil || p.Sequence != nil
+}
+
+// Matches a Policy against a Connection, returning true if the Connection matches all set elements of the Policy.
+// Disabled Policies, and those with Improper criteria or an ImproperLifecycle, never match.
 func (p Policy) Matches(conn Connection) bool {
-	matchIP := p.IPMap == nil
+	if p.Disabled || p.Improper != nil || p.ImproperLifecycle != nil {
+		return false
+	}
+	// Connections without a valid timestamp are matched as usual, to ensure it is resilient
+	if p.connectionClock {
+		if ts, err := conn.Time(); err == nil && !p.ActiveAt(ts) {
+			return false
+		}
+	}
+
+	anyIP := p.IPMap == nil && p.Networks == nil
+	matchIP := anyIP
 	sourceIPFound,destIPFound := false, false
 	// Separately handles both source and destination, because both could match the IP map
-	if _, ok := p.IPMap[conn.Source.String()]; ok  {
+	if p.containsIP(conn.Source) {
 		sourceIPFound = true
 		matchIP = true
 	}
-	if _, ok := p.IPMap[conn.Destination.String()]; ok {
+	if p.containsIP(conn.Destination) {
 		destIPFound = true
 		matchIP = true
 	}
@@ -99,13 +284,13 @@ func (p Policy) Matches(conn Connection) bool {
 	for _, portRange := range p.Ports {
 		// Separately handles both source and destination port, because both could match
 		if conn.SourcePort >= portRange.Start && conn.SourcePort <= portRange.End {
-			if p.IPMap == nil || sourceIPFound {
+			if anyIP || sourceIPFound {
 				matchPort = true
 				break
 			}
 		}
 		if conn.DestinationPort >= portRange.Start && conn.DestinationPort <= portRange.End {
-			if p.IPMap == nil  || destIPFound {
+			if anyIP || destIPFound {
 				matchPort = true
 				break
 			}
@@ -117,5 +302,35 @@ func (p Policy) Matches(conn Connection) bool {
 		matchProtocol = true
 	}
 
-	return matchIP && matchPort && matchProtocol
-}
\ No newline at end of file
+	return matchIP && matchPort && matchProtocol && p.matchesFields(conn)
+}
+
+// matchesFields returns true if each of the Policy's Fields is one of the extra fields of the Connection, with one of
+// its values
+func (p Policy) matchesFields(conn Connection) bool {
+	for name, values := range p.Fields {
+		value, ok := conn.Fields[name]
+		if !ok || !containsString(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesIP returns true if the Policy has no IP criteria, or if it contains the address
+func (p Policy) matchesIP(ip net.IP) bool {
+	return (p.IPMap == nil && p.Networks == nil) || p.containsIP(ip)
+}
+
+// containsIP returns true if the address is one of the Policy's IPs, or is within one of its Networks
+func (p Policy) containsIP(ip net.IP) bool {
+	if _, ok := p.IPMap[ip.String()]; ok {
+		return true
+	}
+	for _, network := range p.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -15,12 +15,24 @@ type PolicyReader struct {}
 
 // This leaves the results from the json intentionally untyped, to make it more resilient to improper values.
 type policyJson struct {
-	ID        interface{}   `json:"id"`
-	Name      interface{}   `json:"name"`
-	IPs       []interface{} `json:"ips,omitempty"`
-	Ports     []interface{}   `json:"ports,omitempty"`
-	Protocols []interface{} `json:"protocols,omitempty"`
-	Verdict   interface{}   `json:"verdict"`
+	ID          interface{}   `json:"id"`
+	Name        interface{}   `json:"name"`
+	IPs         []interface{} `json:"ips,omitempty"`
+	Networks    []interface{} `json:"networks,omitempty"`
+	Ports       []interface{} `json:"ports,omitempty"`
+	Protocols   []interface{} `json:"protocols,omitempty"`
+	Fields      interface{}   `json:"fields,omitempty"`
+	Verdict     interface{}   `json:"verdict"`
+	Threshold   interface{}   `json:"threshold,omitempty"`
+	Sequence    interface{}   `json:"sequence,omitempty"`
+	Score       interface{}   `json:"score,omitempty"`
+	Description interface{}   `json:"description,omitempty"`
+	Owner       interface{}   `json:"owner,omitempty"`
+	Tags        []interface{} `json:"tags,omitempty"`
+	Techniques  []interface{} `json:"techniques,omitempty"`
+	Enabled     interface{}   `json:"enabled,omitempty"`
+	ValidFrom   interface{}   `json:"valid_from,omitempty"`
+	ExpiresAt   interface{}   `json:"expires_at,omitempty"`
 }
 
 // Read a `policy.json` file and returns a Policy slice
@@ -42,8 +54,25 @@ func (p PolicyReader) Read(path string) ([]Policy, error) {
 func transformToPolicies(polJson []policyJson) ([]Policy, error) {
 	var policies []Policy
 
+	statelessIDs := map[string]interface{}{}
 	for _, pol := range polJson {
-		policies = append(policies, NewPolicy(pol))
+		policy := NewPolicy(pol)
+		policies = append(policies, policy)
+		if !policy.stateful() {
+			statelessIDs[policy.ID] = nil
+		}
+	}
+
+	// Sequence steps are built on the rules matched by each Connection, so they can only reference stateless rules
+	for _, policy := range policies {
+		if policy.Sequence == nil {
+			continue
+		}
+		for _, step := range policy.Sequence.Steps {
+			if _, ok := statelessIDs[step]; !ok {
+				log.Printf("Sequence policy '%s' references unknown rule %s, and won't match \n", policy.Name, step)
+			}
+		}
 	}
 
 	return policies, nil
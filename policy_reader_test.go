@@ -89,6 +89,43 @@ func testPolicyReader(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("policy file with metadata", func() {
+			it("reads the metadata, skipping improper techniques", func() {
+				policies, err := policyReader.Read(filepath.Join(testdataPath, "metadata_policy.json"))
+				assert.Nil(t, err)
+				assert.Equal(t, 2, len(policies))
+				assert.Equal(t, "Interactive logins should go through the bastion", policies[0].Description)
+				assert.Equal(t, "secops", policies[0].Owner)
+				assert.Equal(t, []string{"remote-access", "admin"}, policies[0].Tags)
+				assert.Equal(t, []string{"T1021.004"}, policies[0].Techniques)
+				assert.Equal(t, "", policies[1].Description)
+				assert.Equal(t, "", policies[1].Owner)
+			})
+		})
+
+		when("policy file with networks", func() {
+			it("matches the whole CIDR of the networks, but only the address of the ips, skipping improper networks", func() {
+				policies, err := policyReader.Read(filepath.Join(testdataPath, "network_policy.json"))
+				assert.Nil(t, err)
+				assert.Equal(t, 2, len(policies))
+				assert.Equal(t, map[string]interface{}{"127.0.0.0": nil}, policies[0].IPMap)
+				assert.Nil(t, policies[0].Networks)
+				assert.Nil(t, policies[1].IPMap)
+				assert.Equal(t, 1, len(policies[1].Networks))
+				assert.Equal(t, "10.0.1.0/24", policies[1].Networks[0].String())
+			})
+		})
+
+		when("policy file with extra fields", func() {
+			it("reads the values of each field, skipping improper fields", func() {
+				policies, err := policyReader.Read(filepath.Join(testdataPath, "zeek_policy.json"))
+				assert.Nil(t, err)
+				assert.Equal(t, 2, len(policies))
+				assert.Equal(t, map[string][]string{"service": {"ssh"}, "conn_state": {"SF"}}, policies[0].Fields)
+				assert.Nil(t, policies[1].Fields)
+			})
+		})
+
 		when("policy file", func() {
 			it("returns list of policies", func() {
 				policies, err := policyReader.Read(filepath.Join(testdataPath, "policy.json"))
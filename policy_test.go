@@ -64,6 +64,29 @@ func testPolicy(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("matching networks", func() {
+			it("matches an ip within the network", func() {
+				_, network, _ := net.ParseCIDR("192.128.0.0/16")
+				pol.Networks = []*net.IPNet{network}
+				assert.True(t, pol.Matches(conn))
+			})
+
+			it("matches a port on the same side as the network", func() {
+				_, network, _ := net.ParseCIDR("192.128.0.0/16")
+				pol.Networks = []*net.IPNet{network}
+				pol.Ports = []engine.Port{{Start: 5000, End: 5000}}
+				assert.False(t, pol.Matches(conn))
+				pol.Ports = []engine.Port{{Start: 51000, End: 51000}}
+				assert.True(t, pol.Matches(conn))
+			})
+
+			it("doesn't match when no network contains an ip", func() {
+				_, network, _ := net.ParseCIDR("10.0.0.0/8")
+				pol.Networks = []*net.IPNet{network}
+				assert.False(t, pol.Matches(conn))
+			})
+		})
+
 		when("just matching ports", func() {
 			it("matches source port", func() {
 				pol.Ports = []engine.Port{{Start: 4900, End: 5001}}
@@ -83,6 +106,22 @@ func testPolicy(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("matching extra fields", func() {
+			it("matches when each field has one of the values", func() {
+				conn.Fields = map[string]string{"service": "ssh", "conn_state": "SF"}
+				pol.Fields = map[string][]string{"service": {"ssh", "rdp"}, "conn_state": {"SF"}}
+				assert.True(t, pol.Matches(conn))
+			})
+
+			it("doesn't match when a field has another value, or is missing", func() {
+				conn.Fields = map[string]string{"service": "http"}
+				pol.Fields = map[string][]string{"service": {"ssh", "rdp"}}
+				assert.False(t, pol.Matches(conn))
+				pol.Fields = map[string][]string{"conn_state": {"SF"}}
+				assert.False(t, pol.Matches(conn))
+			})
+		})
+
 		when("matches both ip and ports", func() {
 			it("returns false if only satisfies one", func() {
 				pol.IPMap = map[string]interface{}{"192.0.0.3":nil}
@@ -110,4 +149,17 @@ func testPolicy(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 	})
-}
\ No newline at end of file
+
+	when("#RuleMetadata", func() {
+		it("returns the IDs of the matched rules, with their distinct tags and techniques", func() {
+			ids, tags, techniques := engine.RuleMetadata([]engine.Policy{
+				{ID: "1", Tags: []string{"remote-access", "admin"}, Techniques: []string{"T1021.004"}},
+				{ID: "2", Tags: []string{"remote-access"}, Techniques: []string{"T1021.001"}},
+				{ID: "3"},
+			})
+			assert.Equal(t, []string{"1", "2", "3"}, ids)
+			assert.Equal(t, []string{"remote-access", "admin"}, tags)
+			assert.Equal(t, []string{"T1021.004", "T1021.001"}, techniques)
+		})
+	})
+}
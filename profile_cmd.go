@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewProfileCommand creates a CLI which trains the host profiles of the anomaly detector on a connections file, and
+// writes them to a local baseline file
+func NewProfileCommand() *cobra.Command {
+	profileConnectionsPaths := networkConnectionsPaths
+	profileOutputPath := filepath.Join("out", "profiles.json")
+	profileWindow := time.Hour
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Train the host profiles of the anomaly detector on a connections file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return trainProfiles(cmd.OutOrStdout(), profileConnectionsPaths, profileOutputPath, profileWindow)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&profileConnectionsPaths, "connections", "c", profileConnectionsPaths, "Paths, globs or directories of valid connections files, of normal traffic")
+	cmd.Flags().StringVarP(&profileOutputPath, "output", "o", profileOutputPath, "Path for output profiles JSON file")
+	cmd.Flags().DurationVar(&profileWindow, "window", profileWindow, "Length of the host-windows which are profiled and scored")
+
+	return cmd
+}
+
+func trainProfiles(out io.Writer, connectionsPaths []string, outputPath string, window time.Duration) error {
+	if window <= 0 {
+		return errors.Errorf("invalid window %s", window)
+	}
+
+	connectionsRW, err := newConnectionsReadWriter()
+	if err != nil {
+		return err
+	}
+	connections, _, err := readConnections(connectionsRW, connectionsPaths)
+	if err != nil {
+		return err
+	}
+
+	profiles := TrainProfiles(connections, window)
+	fmt.Fprintf(out, "Profiled %d host(s) over %d window(s) of %s\n", len(profiles.Hosts), profiles.Global.Windows, window)
+
+	profilesRW := ProfilesReadWriter{}
+	return profilesRW.Write(profiles, outputPath)
+}
@@ -1,8 +1,15 @@
 package engine
 
 import (
+	"context"
 	"log"
+	"net"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -11,57 +18,840 @@ import (
 var (
 	// These are the default paths, used in the program. Users can override them, by providing arguments to the program.
 	policyPath = filepath.Join("data", "policy.json")
-	networkConnectionsPath = filepath.Join("data", "attacks.csv")
+	networkConnectionsPaths = []string{filepath.Join("data", "attacks.csv")}
 	outputPath = filepath.Join("out", "suspicious.csv")
+	alertsPath = filepath.Join("out", "alerts.json")
+	// The output is written in the format of its extension, unless one is provided, and only holds the suspicious
+	// connections unless all are requested
+	outputFormat = ""
+	outputAll = false
+	outputFields []string
+	// The graph is only exported when a path is provided
+	graphPath = ""
+	graphAll = false
+	graphMinEdgeCount = 1
+	graphSubnetBits = 24
+	assetsPath = ""
+
+	// These are the default limits of the built-in detectors, and a limit of 0 disables the detector
+	verticalScanPorts = 100
+	horizontalSweepHosts = 100
+	scanWindow = time.Minute
+	beaconMinCount = 10
+	beaconMinScore = 0.9
+	beaconJitter = 0.1
+	beaconAllDirections = false
+
+	lateralMovement = false
+	internalNetworks = DefaultInternalNetworks
+	adminPorts = DefaultAdminPorts
+	hubPeers = 50
+	fanOutPeers = 20
+	fanOutWindow = time.Hour
+	minChainHops = 2
+
+	// The baseline of known tuples is only used when a path is provided, and is built by the learn command
+	baselinePath = ""
+	baselineMaxAge = 30 * 24 * time.Hour
+
+	// The anomaly detector is only used when profiles are provided, and they are trained by the profile command
+	anomalyProfilesPath = ""
+	anomalyThreshold = 4.0
+	anomalySuspicious = false
+
+	// These configure the risk score of the suspicious connections, see Scorer
+	scoreFunction = WeightedScore
+	detectorWeight = 1.0
+	sortByScore = false
+
+	// Suspicious connections are grouped into incidents by these keys, until the key was inactive for the gap
+	incidentsPath = filepath.Join("out", "incidents.json")
+	incidentKeyFields = []string{SourceIncidentKey, RuleIncidentKey}
+	incidentGap = 30 * time.Minute
+
+	// Suppressions are only applied when a path is provided, and the connections they suppress are written separately
+	suppressionsPath = ""
+	suppressedPath = filepath.Join("out", "suppressed.csv")
+
+	// The valid_from and expires_at of rules are checked against this clock, see ApplyLifecycle
+	lifecycleClock = WallClock
+
+	// These describe the layout of the connections files, when it differs from the default, see Schema
+	csvColumns []string
+	csvDelimiter = ""
+	csvComment = ""
+	csvLazyQuotes = false
+	// The format of the connections files is detected unless it is provided, and NetFlow exports must be provided
+	inputFormat = ""
+	netFlowPorts []int
+	pcapMode = FlowMode
+	pcapFlowTimeout = DefaultFlowTimeout
+
+	// The connections files are read one after the other, unless they are merged by their timestamps
+	mergeInputs = false
+
+	// The connections file is only followed when requested, with a summary of each interval rather than of the run
+	follow = false
+	followInterval = DefaultFollowInterval
+	summaryInterval = DefaultSummaryInterval
 )
 
+// analysisOptions are the inputs, outputs and detectors of a single run of the engine
+type analysisOptions struct {
+	policyPath string
+	// connectionsPaths are the paths, globs and directories of the connections files, see ExpandInputs
+	connectionsPaths []string
+	outputPath string
+	alertsPath string
+	incidentsPath string
+	suppressedPath string
+	graphPath string
+	detectors []Detector
+	// baseline is kept open for the NoveltyDetector, and must be closed once the run is done
+	baseline *Baseline
+	// anomalies adds the Connections of anomalous host-windows to the suspicious output, when it is set
+	anomalies *AnomalyDetector
+}
+
 // NewRunCommand creates a CLI for the engine
 func NewRunCommand() *cobra.Command {
 	cmd := &cobra.Command{
+		Use: "engine",
 		Short: "Tool to detect network attacks, using a rule file",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runNetworkAnalysis(policyPath, networkConnectionsPath, outputPath)
+			// Logs are written to stderr, so when the suspicious connections are written to stdout, it only holds the CSV
+			if outputPath == StdStream {
+				statusOutput = os.Stderr
+			}
+			opts := analysisOptions{
+				policyPath: policyPath,
+				connectionsPaths: networkConnectionsPaths,
+				outputPath: outputPath,
+				alertsPath: alertsPath,
+				incidentsPath: incidentsPath,
+				suppressedPath: suppressedPath,
+				graphPath: graphPath,
+			}
+			err := opts.addDetectors()
+			if opts.baseline != nil {
+				defer opts.baseline.Close()
+			}
+			if err != nil {
+				return err
+			}
+			if follow {
+				ctx := cmd.Context()
+				if ctx == nil {
+					ctx = context.Background()
+				}
+				return followNetworkAnalysis(ctx, opts)
+			}
+			return runNetworkAnalysis(opts)
 		},
 	}
 
 	cmd.Flags().StringVarP(&policyPath, "policy", "p", policyPath, "Path to a valid JSON policy file")
-	cmd.Flags().StringVarP(&networkConnectionsPath, "connections", "c", networkConnectionsPath, "Path to a valid connections csv file")
-	cmd.Flags().StringVarP(&outputPath, "output", "o", outputPath, "Path for output suspicious CSV file")
+	cmd.Flags().StringSliceVarP(&networkConnectionsPaths, "connections", "c", networkConnectionsPaths, "Paths, globs or directories of valid connections files, or - for stdin")
+	cmd.Flags().StringVarP(&outputPath, "output", "o", outputPath, "Path for output suspicious CSV file, or - for stdout (the alerts, incidents and suppressed connections are still written to their own files)")
+	cmd.Flags().StringVarP(&alertsPath, "alerts", "a", alertsPath, "Path for output alerts JSON file")
+	cmd.Flags().StringVar(&outputFormat, "output-format", outputFormat, "Format of the output file: csv or jsonl (detected by the output's extension by default, and csv for stdout)")
+	cmd.Flags().BoolVar(&outputAll, "output-all", outputAll, "Write all connections to the output, with their verdict, rather than only the suspicious ones")
+	cmd.Flags().StringSliceVar(&outputFields, "output-fields", outputFields, "Extra fields of the connections (e.g. Zeek's service and duration) to add as columns of the CSV output, while the JSON Lines output holds all of them")
+	cmd.Flags().IntVar(&verticalScanPorts, "vertical-scan-ports", verticalScanPorts, "Alert on a source touching more than this many ports on a single host within the scan window (0 disables)")
+	cmd.Flags().IntVar(&horizontalSweepHosts, "horizontal-sweep-hosts", horizontalSweepHosts, "Alert on a source touching more than this many hosts on a single port within the scan window (0 disables)")
+	cmd.Flags().DurationVar(&scanWindow, "scan-window", scanWindow, "Window for the port-scan and host-sweep detectors")
+	cmd.Flags().IntVar(&beaconMinCount, "beacon-min-count", beaconMinCount, "Minimum connections between a pair before it may be flagged as beaconing (0 disables)")
+	cmd.Flags().Float64Var(&beaconMinScore, "beacon-min-score", beaconMinScore, "Minimum regularity score (between 0 and 1) for a pair to be flagged as beaconing")
+	cmd.Flags().Float64Var(&beaconJitter, "beacon-jitter", beaconJitter, "Tolerated deviation of a beacon interval from the median interval, as a fraction of it")
+	cmd.Flags().BoolVar(&beaconAllDirections, "beacon-all-directions", beaconAllDirections, "Flag beaconing between any pair, rather than only from an internal source to an external destination")
+	cmd.Flags().BoolVar(&lateralMovement, "lateral-movement", lateralMovement, "Analyze the host communication graph for lateral movement")
+	cmd.Flags().StringSliceVar(&internalNetworks, "internal-networks", internalNetworks, "CIDRs of the internal networks, for the lateral movement analysis and the direction of beacons")
+	cmd.Flags().IntSliceVar(&adminPorts, "admin-ports", adminPorts, "Admin ports, whose internal hops are flagged as chains by the lateral movement analysis")
+	cmd.Flags().IntVar(&hubPeers, "hub-peers", hubPeers, "Flag a host reaching more than this many internal peers as a fan-out hub (0 disables)")
+	cmd.Flags().IntVar(&fanOutPeers, "fanout-peers", fanOutPeers, "Flag a host reaching more than this many new internal peers within the fan-out window (0 disables)")
+	cmd.Flags().DurationVar(&fanOutWindow, "fanout-window", fanOutWindow, "Window for the sudden fan-out analysis")
+	cmd.Flags().IntVar(&minChainHops, "min-chain-hops", minChainHops, "Flag chains of at least this many consecutive internal hops on admin ports, for the lateral movement analysis (0 disables)")
+	cmd.Flags().StringVar(&graphPath, "graph-output", graphPath, "Path for output communication graph file, as DOT (.dot), GraphML (.graphml) or JSON (not exported by default)")
+	cmd.Flags().BoolVar(&graphAll, "graph-all", graphAll, "Export the graph of all connections, rather than only of the suspicious ones")
+	cmd.Flags().IntVar(&graphMinEdgeCount, "graph-min-edge-count", graphMinEdgeCount, "Collapse the graph edges between a pair of hosts with fewer connections than this into a single edge")
+	cmd.Flags().IntVar(&graphSubnetBits, "graph-subnet-bits", graphSubnetBits, "Group the graph nodes without an asset label by their subnet of this prefix length (0 disables)")
+	cmd.Flags().StringVar(&assetsPath, "assets", assetsPath, "Path to a JSON file labelling networks as assets")
+	cmd.Flags().StringVar(&baselinePath, "baseline", baselinePath, "Path to a baseline file built by the learn command, to alert on tuples which were never seen before")
+	cmd.Flags().DurationVar(&baselineMaxAge, "baseline-max-age", baselineMaxAge, "Treat the baseline tuples which weren't seen for this long before a connection as new (0 disables)")
+	cmd.Flags().StringVar(&anomalyProfilesPath, "anomaly-profiles", anomalyProfilesPath, "Path to a profiles file built by the profile command, to score host-windows for anomalies")
+	cmd.Flags().Float64Var(&anomalyThreshold, "anomaly-threshold", anomalyThreshold, "Flag the host-windows whose anomaly score (the highest z-score of their features) is at least this")
+	cmd.Flags().StringVar(&scoreFunction, "score-function", scoreFunction, "Function combining the risk score of a suspicious connection: weighted ((rules + detectors) * criticality), sum or max")
+	cmd.Flags().Float64Var(&detectorWeight, "detector-weight", detectorWeight, "Weight each type of detector alert on a host adds to the risk score of its connections")
+	cmd.Flags().BoolVar(&sortByScore, "sort-by-score", sortByScore, "Sort the suspicious output by risk score, from highest to lowest")
+	cmd.Flags().StringVar(&incidentsPath, "incidents", incidentsPath, "Path for output incidents JSON file")
+	cmd.Flags().StringSliceVar(&incidentKeyFields, "incident-key", incidentKeyFields, "Fields suspicious connections are grouped into incidents by: source, destination, rule, port and protocol")
+	cmd.Flags().DurationVar(&incidentGap, "incident-gap", incidentGap, "Start a new incident for a key, once it was inactive for longer than this")
+	cmd.Flags().BoolVar(&anomalySuspicious, "anomaly-suspicious", anomalySuspicious, "Add the connections of anomalous host-windows to the suspicious output, with a reason column")
+	cmd.Flags().StringVar(&suppressionsPath, "suppressions", suppressionsPath, "Path to a suppressions JSON file, whose matching suspicious connections are moved to the suppressed output until they expire")
+	cmd.Flags().StringVar(&suppressedPath, "suppressed-output", suppressedPath, "Path for output suppressed CSV file")
+	cmd.Flags().BoolVar(&follow, "follow", follow, "Follow the connections file like tail -F, analyzing the rows appended to it until interrupted")
+	cmd.Flags().DurationVar(&followInterval, "follow-interval", followInterval, "How often the followed connections file is checked for new rows")
+	cmd.Flags().DurationVar(&summaryInterval, "summary-interval", summaryInterval, "How often the summary of the rows analyzed since the last one is logged, when following the connections file")
+	cmd.Flags().StringVar(&lifecycleClock, "lifecycle-clock", lifecycleClock, "Clock the valid_from and expires_at of rules are checked against: wall (the current time) or connection (the timestamp of each connection)")
+
+	// The schema applies to every command which reads connections files
+	cmd.PersistentFlags().StringSliceVar(&csvColumns, "csv-columns", csvColumns, "Columns of connections files without a header, in order, where - skips a column (default [timestamp,source,source_port,destination,destination_port,protocol])")
+	cmd.PersistentFlags().StringVar(&csvDelimiter, "csv-delimiter", csvDelimiter, "Delimiter of the columns of connections files, e.g. ; or tab (default \",\")")
+	cmd.PersistentFlags().StringVar(&csvComment, "csv-comment", csvComment, "Character starting the comment lines of connections files, which are skipped (no comments by default)")
+	cmd.PersistentFlags().BoolVar(&csvLazyQuotes, "csv-lazy-quotes", csvLazyQuotes, "Allow improperly quoted columns in connections files")
+	cmd.PersistentFlags().StringVar(&inputFormat, "input-format", inputFormat, "Format of the connections files: csv, jsonl, zeek, netflow, pcap, aws or gcp (detected by default, except for netflow and aws logs without a header)")
+	cmd.PersistentFlags().IntSliceVar(&netFlowPorts, "netflow-ports", netFlowPorts, "UDP ports of the NetFlow exports read from a pcap capture (any port by default)")
+	cmd.PersistentFlags().StringVar(&pcapMode, "pcap-mode", pcapMode, "Read a connection for each flow or each packet of a pcap capture: flow or packet")
+	cmd.PersistentFlags().BoolVar(&mergeInputs, "merge-inputs", mergeInputs, "Merge the connections files by their timestamps into a single ordered stream, rather than reading them one after the other")
+	cmd.PersistentFlags().DurationVar(&pcapFlowTimeout, "pcap-flow-timeout", pcapFlowTimeout, "Time a flow of a pcap capture may be idle before its next packet starts a new flow")
+
+	cmd.AddCommand(NewTestCommand())
+	cmd.AddCommand(NewLearnCommand())
+	cmd.AddCommand(NewSuggestCommand())
+	cmd.AddCommand(NewProfileCommand())
+	cmd.AddCommand(NewLintCommand())
 
 	return cmd
 }
 
-func runNetworkAnalysis(policyPath, networkConnectionsPath, outputPath string) error {
+// addDetectors creates the detectors which are enabled by the flags
+func (o *analysisOptions) addDetectors() error {
+	if verticalScanPorts > 0 {
+		o.detectors = append(o.detectors, NewVerticalScanDetector(verticalScanPorts, scanWindow))
+	}
+	if horizontalSweepHosts > 0 {
+		o.detectors = append(o.detectors, NewHorizontalSweepDetector(horizontalSweepHosts, scanWindow))
+	}
+	if beaconMinCount > 0 {
+		var networks []*net.IPNet
+		if !beaconAllDirections {
+			var err error
+			if networks, err = ParseNetworks(internalNetworks); err != nil {
+				return errors.Wrap(err, "parsing internal networks")
+			}
+		}
+		o.detectors = append(o.detectors, NewBeaconDetector(beaconMinCount, beaconMinScore, beaconJitter, networks))
+	}
+
+	if lateralMovement {
+		networks, err := ParseNetworks(internalNetworks)
+		if err != nil {
+			return errors.Wrap(err, "parsing internal networks")
+		}
+
+		o.detectors = append(o.detectors, NewLateralMovementDetector(LateralMovementConfig{
+			InternalNetworks: networks,
+			AdminPorts: adminPorts,
+			HubPeers: hubPeers,
+			FanOutPeers: fanOutPeers,
+			FanOutWindow: fanOutWindow,
+			MinChainHops: minChainHops,
+		}))
+	}
+
+	if anomalyProfilesPath != "" {
+		profilesRW := ProfilesReadWriter{}
+		profiles, err := profilesRW.Read(anomalyProfilesPath)
+		if err != nil {
+			return errors.Wrapf(err, "parsing profiles file %s", anomalyProfilesPath)
+		}
+
+		detector := NewAnomalyDetector(profiles, anomalyThreshold)
+		o.detectors = append(o.detectors, detector)
+		if anomalySuspicious {
+			o.anomalies = detector
+		}
+	}
+
+	if baselinePath != "" {
+		baseline, err := OpenBaseline(baselinePath)
+		if err != nil {
+			return err
+		}
+		o.baseline = baseline
+
+		size, err := baseline.Len()
+		if err != nil {
+			return errors.Wrap(err, "failed to read baseline")
+		}
+		// Every tuple is new to an empty baseline, so alerting on them would only be noise
+		if size == 0 {
+			log.Printf("The baseline %s is empty, so it won't be used until tuples are learned into it \n", baselinePath)
+			return nil
+		}
+		o.detectors = append(o.detectors, NewNoveltyDetector(baseline, baselineMaxAge))
+	}
+	return nil
+}
+
+// newConnectionsReadWriter returns a ConnectionsReadWriter, which reads the connections files of the --input-format and
+// the --csv-* schema
+func newConnectionsReadWriter() (ConnectionsReadWriter, error) {
+	if inputFormat != "" && !containsString(InputFormats, inputFormat) {
+		return ConnectionsReadWriter{}, errors.Errorf("unknown input format %s, expected one of %s", inputFormat, strings.Join(InputFormats, ", "))
+	}
+	if pcapMode != FlowMode && pcapMode != PacketMode {
+		return ConnectionsReadWriter{}, errors.Errorf("unknown pcap mode %s, expected %s or %s", pcapMode, FlowMode, PacketMode)
+	}
+	if pcapFlowTimeout <= 0 {
+		return ConnectionsReadWriter{}, errors.New("--pcap-flow-timeout must be positive")
+	}
+	delimiter, err := ParseSchemaRune(csvDelimiter)
+	if err != nil {
+		return ConnectionsReadWriter{}, errors.Wrap(err, "parsing --csv-delimiter")
+	}
+	comment, err := ParseSchemaRune(csvComment)
+	if err != nil {
+		return ConnectionsReadWriter{}, errors.Wrap(err, "parsing --csv-comment")
+	}
+
+	return ConnectionsReadWriter{Schema: Schema{
+		Columns:    csvColumns,
+		Delimiter:  delimiter,
+		Comment:    comment,
+		LazyQuotes: csvLazyQuotes,
+	}, InputFormat: inputFormat, NetFlowPorts: netFlowPorts,
+		CaptureMode: pcapMode, FlowTimeout: pcapFlowTimeout}, nil
+}
+
+// readAssets reads the --assets file, if one was provided
+func readAssets() ([]Asset, error) {
+	if assetsPath == "" {
+		return nil, nil
+	}
+
+	assetReader := AssetReader{}
+	assets, err := assetReader.Read(assetsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing assets file %s", assetsPath)
+	}
+	return assets, nil
+}
+
+// exportGraph writes the communication graph of the suspicious Connections (or of all of them, with --graph-all), with
+// the names of the rules each edge matched
+func exportGraph(path string, policies []Policy, connections []Connection, results DetectionResult) error {
+	networks, err := ParseNetworks(internalNetworks)
+	if err != nil {
+		return errors.Wrap(err, "parsing internal networks")
+	}
+
+	assets, err := readAssets()
+	if err != nil {
+		return err
+	}
+
+	graph := NewGraph(networks).WithGroups(assets, graphSubnetBits)
+	exported := results.Suspicious
+	if graphAll {
+		exported = connections
+	}
+	for _, conn := range exported {
+		graph.AddMatched(conn, Evaluate(policies, conn).Matched)
+	}
+
+	graphWriter := GraphWriter{MinEdgeCount: graphMinEdgeCount}
+	return graphWriter.Write(graph, path)
+}
+
+// analysis is what a run needs to analyze the connections, which is the same for each of them when they are followed
+type analysis struct {
+	policies []Policy
+	// skipped are the rules which were skipped by their lifecycle
+	skipped []SkippedPolicy
+	scorer *Scorer
+	incidents *IncidentGrouper
+	connectionsRW ConnectionsReadWriter
+}
+
+// newAnalysis reads the policy and the assets of a run, and creates its scorer and incident grouper
+func newAnalysis(opts analysisOptions) (analysis, error) {
+	if outputFormat != "" && outputFormat != CSVFormat && outputFormat != JSONLinesFormat {
+		return analysis{}, errors.Errorf("unknown output format %s, expected %s or %s", outputFormat, CSVFormat, JSONLinesFormat)
+	}
+
 	policyReader := PolicyReader{}
-	policies, err := policyReader.Read(policyPath)
+	policies, err := policyReader.Read(opts.policyPath)
 	if err != nil {
-		return errors.Wrapf(err, "parsing policy file %s", policyPath)
+		return analysis{}, errors.Wrapf(err, "parsing policy file %s", opts.policyPath)
+	}
+	policies, skipped, err := ApplyLifecycle(policies, lifecycleClock, time.Now())
+	if err != nil {
+		return analysis{}, err
 	}
 
-	connectionsRW := ConnectionsReadWriter{}
-	connections, err := connectionsRW.Read(networkConnectionsPath)
+	assets, err := readAssets()
+	if err != nil {
+		return analysis{}, err
+	}
+	scorer, err := NewScorer(scoreFunction, detectorWeight, policies, assets)
+	if err != nil {
+		return analysis{}, err
+	}
+	incidents, err := NewIncidentGrouper(incidentKeyFields, incidentGap)
+	if err != nil {
+		return analysis{}, err
+	}
+
+	connectionsRW, err := newConnectionsReadWriter()
+	if err != nil {
+		return analysis{}, err
+	}
+	return analysis{policies: policies, skipped: skipped, scorer: scorer, incidents: incidents, connectionsRW: connectionsRW}, nil
+}
+
+func runNetworkAnalysis(opts analysisOptions) error {
+	a, err := newAnalysis(opts)
+	if err != nil {
+		return err
+	}
+	policies, scorer, incidents, connectionsRW := a.policies, a.scorer, a.incidents, a.connectionsRW
+
+	connections, stats, err := readConnections(connectionsRW, opts.connectionsPaths)
 	if err != nil{
-		return errors.Wrapf(err, "parsing connections file %s", networkConnectionsPath)
+		return err
 	}
 
-	results := DetectAttacks(policies, connections)
+	results := DetectAttacks(policies, connections, opts.detectors...)
 
 	log.Println("Successfully completed analyzing the connections.")
 	log.Printf("\nResults:\n")
+	logResults(results, a.skipped)
+	// The provenance of the suspicious connections is only tracked when there are several files
+	provenance := len(stats) > 1
+	if provenance {
+		logInputStats(stats, results.Suspicious)
+	}
+
+	if len(results.Alerts) != 0 {
+		alertsWriter := AlertsWriter{}
+		if err := alertsWriter.Write(results.Alerts, opts.alertsPath); err != nil {
+			return err
+		}
+	}
+
+	if opts.graphPath != "" {
+		if err := exportGraph(opts.graphPath, policies, connections, results); err != nil {
+			return err
+		}
+	}
+
+	// indices holds the index of each of the suspicious Connections in the input
+	suspicious, indices := results.Suspicious, results.SuspiciousIndices
+	var reasons []string
+	if opts.anomalies != nil {
+		indices, reasons = addAnomalies(connections, results, opts.anomalies)
+		suspicious = connectionsAt(connections, indices)
+		log.Printf("* %d anomalous connection(s) were added to the suspicious output\n", len(suspicious)-len(results.Suspicious))
+	}
+
+	if suppressionsPath != "" {
+		if suspicious, indices, reasons, err = suppress(policies, suspicious, indices, reasons, opts.suppressedPath); err != nil {
+			return err
+		}
+	}
+
+	if len(suspicious) == 0 && !outputAll {
+		log.Println("No suspicious connections were found.")
+		log.Println("As a result, we won't write an output file.")
+		return nil
+	}
+
+	// The output holds the suspicious Connections, and with --output-all the rest of them too, in the order of the input
+	output := suspicious
+	verdicts := make([]string, len(suspicious))
+	for i := range verdicts {
+		verdicts[i] = SuspiciousVerdict
+	}
+	if outputAll {
+		output, verdicts, reasons = withUnsuspicious(connections, results.Verdicts, indices, reasons)
+	}
+
+	scorer.AddAlerts(results.Alerts)
+	rows := newOutputRows(policies, output, verdicts, reasons, scorer, incidents)
+	rows.provenance = provenance
+	log.Printf("* Risk scores were computed as %s\n", scorer.Describe())
+	log.Printf("* The suspicious connections were grouped into %d incident(s)\n", len(incidents.Incidents()))
+
+	incidentsWriter := IncidentsWriter{}
+	if err := incidentsWriter.Write(incidents.Incidents(), opts.incidentsPath); err != nil {
+		return err
+	}
+
+	if sortByScore {
+		sortByScores(rows.connections, rows.scores, rows.verdicts, rows.incidentIDs, rows.ruleIDs, rows.tags, rows.techniques, rows.reasons)
+	}
+
+	connectionsRW.Format = outputFormat
+	return connectionsRW.Write(rows.connections, opts.outputPath, rows.columns(isJSONOutput(opts.outputPath))...)
+}
+
+// readConnections reads the connections files of the paths, globs and directories, one after the other or (with
+// --merge-inputs) merged by their timestamps, and returns them together with the statistics of each file. The Origin
+// of each Connection is tracked when there are several files.
+func readConnections(connectionsRW ConnectionsReadWriter, inputs []string) ([]Connection, []InputStats, error) {
+	paths, err := ExpandInputs(inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+	connectionsRW.TrackOrigin = len(paths) > 1
+	return connectionsRW.ReadAll(paths, mergeInputs)
+}
+
+// logInputStats logs the statistics of each connections file, with the amount of its suspicious connections
+func logInputStats(stats []InputStats, suspicious []Connection) {
+	suspiciousCount := map[string]int{}
+	for _, conn := range suspicious {
+		if conn.Origin != nil {
+			suspiciousCount[conn.Origin.File]++
+		}
+	}
+	for _, stat := range stats {
+		log.Printf("* File %s had %d connection(s), %d of them suspicious, from %s to %s\n", stat.Path, stat.Connections,
+			suspiciousCount[stat.Path], formatStatsTime(stat.First), formatStatsTime(stat.Last))
+		if stat.OutOfOrder != 0 {
+			log.Printf("* File %s had %d connection(s) earlier than the one before them\n", stat.Path, stat.OutOfOrder)
+		}
+	}
+}
+
+// formatStatsTime formats the first or last time of a connections file, which is zero when it had no valid timestamps
+func formatStatsTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// logResults logs the summary of a DetectionResult, and the rules which were skipped by their lifecycle
+func logResults(results DetectionResult, skipped []SkippedPolicy) {
 	log.Printf("* There were %d clean connections\n", results.CleanCount)
 	log.Printf("* There were %d suspicious connections\n", len(results.Suspicious))
 	log.Printf("* %d connection(s) didn't match any rule(s)\n", results.NoMatchCount)
 	for key, val := range results.RuleCount {
 		log.Printf("* Rule '%s' matched successfully with %d connections\n", key, val)
 	}
+	for _, key := range sortedKeys(results.TagCount) {
+		log.Printf("* Tag '%s' matched with %d connections\n", key, results.TagCount[key])
+	}
+	for _, key := range sortedKeys(results.TechniqueCount) {
+		log.Printf("* Technique %s matched with %d connections\n", key, results.TechniqueCount[key])
+	}
+	for _, skip := range skipped {
+		log.Printf("* Rule '%s' was skipped, as it %s\n", skip.Policy.Name, skip.Reason)
+	}
+	log.Printf("* There were %d alert(s)\n", len(results.Alerts))
+	for _, alert := range results.Alerts {
+		log.Printf("* %s\n", alert)
+	}
+}
 
-	if len(results.Suspicious) == 0{
-		log.Println("No suspicious connections were found.")
-		log.Println("As a result, we won't write an output file.")
-		return nil
+// isJSONOutput returns true if the output is written in the JSON Lines format, by the --output-format or by the
+// output's extension
+func isJSONOutput(path string) bool {
+	return outputFormat == JSONLinesFormat || (outputFormat == "" && path != StdStream && formatOf(path) == JSONLinesFormat)
+}
+
+// outputRows are the Connections of the output, together with the values of their columns
+type outputRows struct {
+	connections []Connection
+	verdicts []string
+	// reasons are only set when anomalies are added to the output
+	reasons []string
+	scores []float64
+	incidentIDs []string
+	ruleIDs []string
+	tags []string
+	techniques []string
+	// provenance adds the file and line each Connection was read from to the output
+	provenance bool
+}
+
+// newOutputRows scores the suspicious Connections of the output and groups them into incidents, and collects the
+// metadata of the rules each Connection matched
+func newOutputRows(policies []Policy, output []Connection, verdicts, reasons []string, scorer *Scorer, incidents *IncidentGrouper) outputRows {
+	rows := outputRows{
+		connections: output,
+		verdicts: verdicts,
+		reasons: reasons,
+		scores: make([]float64, len(output)),
+		incidentIDs: make([]string, len(output)),
+		ruleIDs: make([]string, len(output)),
+		tags: make([]string, len(output)),
+		techniques: make([]string, len(output)),
 	}
+	for i, conn := range output {
+		matched := Evaluate(policies, conn).Matched
+		// Only the suspicious Connections are scored and grouped, while the rest keep the rules they matched
+		if verdicts[i] == SuspiciousVerdict {
+			rows.scores[i] = scorer.Score(conn, matched)
+			rows.incidentIDs[i] = incidents.Add(conn, matched, rows.scores[i])
+		}
 
-	return connectionsRW.Write(results.Suspicious, outputPath)
+		ids, connTags, connTechniques := RuleMetadata(matched)
+		rows.ruleIDs[i] = strings.Join(ids, ";")
+		rows.tags[i] = strings.Join(connTags, ";")
+		rows.techniques[i] = strings.Join(connTechniques, ";")
+	}
+	return rows
+}
+
+// columns returns the Columns of the output, which differ between the CSV and JSON Lines formats
+func (r outputRows) columns(jsonOutput bool) []Column {
+	var columns []Column
+	// The verdict is always a field of the JSON Lines output, but is only a column of the CSV when it varies
+	if outputAll || jsonOutput {
+		columns = append(columns, Column{Name: "verdict", Value: func(i int, conn Connection) string {
+			return r.verdicts[i]
+		}})
+	}
+	columns = append(columns, []Column{
+		{Name: "score", JSONName: "severity", Value: func(i int, conn Connection) string {
+			return strconv.FormatFloat(r.scores[i], 'f', -1, 64)
+		}, JSONValue: func(i int, conn Connection) interface{} {
+			return r.scores[i]
+		}},
+		{Name: "incident", Value: func(i int, conn Connection) string {
+			return r.incidentIDs[i]
+		}},
+		{Name: "rules", Value: func(i int, conn Connection) string {
+			return r.ruleIDs[i]
+		}, JSONValue: func(i int, conn Connection) interface{} {
+			return splitList(r.ruleIDs[i])
+		}},
+		{Name: "tags", Value: func(i int, conn Connection) string {
+			return r.tags[i]
+		}, JSONValue: func(i int, conn Connection) interface{} {
+			return splitList(r.tags[i])
+		}},
+		{Name: "techniques", Value: func(i int, conn Connection) string {
+			return r.techniques[i]
+		}, JSONValue: func(i int, conn Connection) interface{} {
+			return splitList(r.techniques[i])
+		}},
+	}...)
+	if r.reasons != nil {
+		columns = append(columns, Column{Name: "reason", Value: func(i int, conn Connection) string {
+			return r.reasons[i]
+		}})
+	}
+	if r.provenance {
+		columns = append(columns, []Column{
+			{Name: "file", Value: func(i int, conn Connection) string {
+				if conn.Origin == nil {
+					return ""
+				}
+				return conn.Origin.File
+			}},
+			{Name: "line", Value: func(i int, conn Connection) string {
+				if conn.Origin == nil || conn.Origin.Line == 0 {
+					return ""
+				}
+				return strconv.Itoa(conn.Origin.Line)
+			}, JSONValue: func(i int, conn Connection) interface{} {
+				if conn.Origin == nil || conn.Origin.Line == 0 {
+					return nil
+				}
+				return conn.Origin.Line
+			}},
+		}...)
+	}
+	// The JSON Lines output already holds the extra fields of each Connection
+	if !jsonOutput {
+		for _, field := range outputFields {
+			field := field
+			columns = append(columns, Column{Name: field, Value: func(i int, conn Connection) string {
+				return conn.Fields[field]
+			}})
+		}
+	}
+	return columns
 }
 
+// withUnsuspicious merges the rest of the Connections into the suspicious ones, which are at the indices of the input,
+// and returns them together with their verdicts and reasons (if any), in the order of the input. Connections whose
+// detected verdict is suspicious, but aren't among the suspicious ones, were suppressed.
+func withUnsuspicious(connections []Connection, detected []string, indices []int, reasons []string) ([]Connection, []string, []string) {
+	merged := make([]Connection, 0, len(connections))
+	verdicts := make([]string, 0, len(connections))
+	var mergedReasons []string
+	next := 0
+	for i, conn := range connections {
+		// The indices are in the order of the input, so the next one is suspicious if it is at the next index
+		if next < len(indices) && indices[next] == i {
+			merged = append(merged, conn)
+			verdicts = append(verdicts, SuspiciousVerdict)
+			if reasons != nil {
+				mergedReasons = append(mergedReasons, reasons[next])
+			}
+			next++
+			continue
+		}
 
+		verdict := detected[i]
+		if verdict == SuspiciousVerdict {
+			verdict = SuppressedVerdict
+		}
+		merged = append(merged, conn)
+		verdicts = append(verdicts, verdict)
+		if reasons != nil {
+			mergedReasons = append(mergedReasons, "")
+		}
+	}
+	return merged, verdicts, mergedReasons
+}
+
+// splitList splits a `;` separated column into a list, which is empty rather than nil for an empty column, so that the
+// JSON Lines fields always hold a list
+func splitList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ";")
+}
+
+// addAnomalies merges the Connections of anomalous host-windows into the suspicious ones of the DetectionResult, and
+// returns their indices in the input, in its order, together with the reason each is suspicious
+func addAnomalies(connections []Connection, results DetectionResult, anomalies *AnomalyDetector) ([]int, []string) {
+	var indices []int
+	var reasons []string
+	next := 0
+	for i, conn := range connections {
+		var reason []string
+		// The suspicious indices are in the order of the input, so the Connection is suspicious if it is at the next one
+		if next < len(results.SuspiciousIndices) && results.SuspiciousIndices[next] == i {
+			var names []string
+			for _, policy := range results.SuspiciousMatched[next] {
+				names = append(names, policy.Name)
+			}
+			reason = append(reason, "matched "+strings.Join(names, ", "))
+			next++
+		}
+		if anomaly, ok := anomalies.Anomalous(conn); ok {
+			reason = append(reason, anomaly)
+		}
+
+		if len(reason) != 0 {
+			indices = append(indices, i)
+			reasons = append(reasons, strings.Join(reason, "; "))
+		}
+	}
+	return indices, reasons
+}
+
+// connectionsAt returns the Connections at the indices
+func connectionsAt(connections []Connection, indices []int) []Connection {
+	at := make([]Connection, len(indices))
+	for i, index := range indices {
+		at[i] = connections[index]
+	}
+	return at
+}
+
+// suppress moves the suspicious Connections matching an active suppression to the suppressed output, and returns the
+// remaining ones together with their indices and reasons (if any)
+func suppress(policies []Policy, suspicious []Connection, indices []int, reasons []string, path string) ([]Connection, []int, []string, error) {
+	suppressor, err := readSuppressor()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	kept, keptIndices, keptReasons, suppressed, suppressedBy := suppressConnections(suppressor, policies, suspicious, indices, reasons)
+
+	log.Printf("* %d suspicious connection(s) were suppressed\n", len(suppressed))
+	logSuppressions(suppressor)
+	if len(suppressed) == 0 {
+		return kept, keptIndices, keptReasons, nil
+	}
+
+	connectionsRW := ConnectionsReadWriter{}
+	if err := connectionsRW.write(suppressed, path, "suppressed connections", suppressionColumn(suppressedBy)); err != nil {
+		return nil, nil, nil, err
+	}
+	return kept, keptIndices, keptReasons, nil
+}
+
+// readSuppressor reads the --suppressions file, and returns a Suppressor of the suppressions which are active now
+func readSuppressor() (*Suppressor, error) {
+	suppressionReader := SuppressionReader{}
+	suppressions, err := suppressionReader.Read(suppressionsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing suppressions file %s", suppressionsPath)
+	}
+	return NewSuppressor(suppressions, time.Now()), nil
+}
+
+// suppressConnections splits the suspicious Connections into the ones which are kept, together with their indices and
+// reasons (if any), and the ones which are suppressed, together with the ID of the suppression of each
+func suppressConnections(suppressor *Suppressor, policies []Policy, suspicious []Connection, indices []int, reasons []string) ([]Connection, []int, []string, []Connection, []string) {
+	var kept, suppressed []Connection
+	var keptIndices []int
+	var keptReasons, suppressedBy []string
+	for i, conn := range suspicious {
+		if id, ok := suppressor.Suppress(conn, Evaluate(policies, conn).Matched); ok {
+			suppressed = append(suppressed, conn)
+			suppressedBy = append(suppressedBy, id)
+			continue
+		}
+		kept = append(kept, conn)
+		keptIndices = append(keptIndices, indices[i])
+		if reasons != nil {
+			keptReasons = append(keptReasons, reasons[i])
+		}
+	}
+	return kept, keptIndices, keptReasons, suppressed, suppressedBy
+}
+
+// logSuppressions logs how many connections each active suppression suppressed
+func logSuppressions(suppressor *Suppressor) {
+	for _, suppression := range suppressor.Active() {
+		log.Printf("* Suppression '%s' (%s, owned by %s until %s) suppressed %d connection(s)\n", suppression.ID, suppression.Reason, suppression.Owner, suppression.Expires.Format(time.RFC3339), suppressor.Count(suppression.ID))
+	}
+}
+
+// suppressionColumn is the column of the suppressed output, which holds the ID of the suppression of each Connection
+func suppressionColumn(suppressedBy []string) Column {
+	return Column{Name: "suppression", Value: func(i int, conn Connection) string {
+		return suppressedBy[i]
+	}}
+}
+
+// sortedKeys returns the keys of the counts in order, so that they are reported the same in each run
+func sortedKeys(counts map[string]int) []string {
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortByScores sorts the suspicious Connections, together with their scores and the values of their columns (if any),
+// from the highest score to the lowest. Connections with the same score keep their order.
+func sortByScores(suspicious []Connection, scores []float64, values ...[]string) {
+	order := make([]int, len(suspicious))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	sortedConns := make([]Connection, len(suspicious))
+	sortedScores := make([]float64, len(scores))
+	for i, index := range order {
+		sortedConns[i], sortedScores[i] = suspicious[index], scores[index]
+	}
+	copy(suspicious, sortedConns)
+	copy(scores, sortedScores)
+
+	for _, column := range values {
+		if column == nil {
+			continue
+		}
+		sorted := make([]string, len(column))
+		for i, index := range order {
+			sorted[i] = column[index]
+		}
+		copy(column, sorted)
+	}
+}
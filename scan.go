@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	VerticalScanAlert    = "VERTICAL_SCAN"
+	HorizontalSweepAlert = "HORIZONTAL_SWEEP"
+)
+
+// ScanDetector flags a source which touches more than a limit of distinct targets within a window.
+// A vertical scan is a source touching many destination ports on a single host, and a horizontal sweep is a source
+// touching many hosts on a single port.
+type ScanDetector struct {
+	alertType string
+	limit     int
+	window    time.Duration
+	groups    map[string]windowGroup
+}
+
+// NewVerticalScanDetector returns a ScanDetector which flags a source touching more than ports distinct destination
+// ports on a single host within window
+func NewVerticalScanDetector(ports int, window time.Duration) *ScanDetector {
+	return &ScanDetector{alertType: VerticalScanAlert, limit: ports, window: window, groups: map[string]windowGroup{}}
+}
+
+// NewHorizontalSweepDetector returns a ScanDetector which flags a source touching more than hosts distinct destination
+// hosts on a single port within window
+func NewHorizontalSweepDetector(hosts int, window time.Duration) *ScanDetector {
+	return &ScanDetector{alertType: HorizontalSweepAlert, limit: hosts, window: window, groups: map[string]windowGroup{}}
+}
+
+// scanGroup holds, for a single group, the first Connection seen for each distinct value within the window, together
+// with the last time the value was seen. It never holds more than limit+1 values, as that is enough to trigger an alert.
+type scanGroup struct {
+	conns  map[string]Connection
+	times  map[string]time.Time
+	latest time.Time
+}
+
+func (g *scanGroup) last() time.Time {
+	return g.latest
+}
+
+// target returns the group a Connection belongs to, and the distinct value it touches in that group.
+// Connections without a destination port (e.g. ICMP or ARP) can't be part of a vertical scan.
+func (s *ScanDetector) target(conn Connection) (string, string, bool) {
+	if s.alertType == VerticalScanAlert {
+		if conn.DestinationPort == 0 {
+			return "", "", false
+		}
+		key := fmt.Sprintf("source %s -> host %s", conn.Source, conn.Destination)
+		return key, strconv.Itoa(conn.DestinationPort) + "/" + conn.Protocol, true
+	}
+
+	key := fmt.Sprintf("source %s -> port %d/%s", conn.Source, conn.DestinationPort, conn.Protocol)
+	return key, conn.Destination.String(), true
+}
+
+// Observe adds a Connection to its group, and returns an Alert if the group crossed the limit of distinct values.
+// Connections without a valid timestamp can't be placed in a window, and are ignored.
+func (s *ScanDetector) Observe(conn Connection) []Alert {
+	ts, err := conn.Time()
+	if err != nil {
+		return nil
+	}
+
+	key, value, ok := s.target(conn)
+	if !ok {
+		return nil
+	}
+
+	start := ts.Add(-s.window)
+	group, ok := s.groups[key]
+	if !ok {
+		if len(s.groups) >= maxDetectorGroups {
+			evictGroups(s.groups, start)
+		}
+		group = &scanGroup{conns: map[string]Connection{}, times: map[string]time.Time{}}
+		s.groups[key] = group
+	}
+	scan := group.(*scanGroup)
+
+	// Drops the values which haven't been seen within the window
+	for seen, last := range scan.times {
+		if last.Before(start) {
+			delete(scan.times, seen)
+			delete(scan.conns, seen)
+		}
+	}
+	if _, ok := scan.conns[value]; !ok {
+		scan.conns[value] = conn
+	}
+	scan.times[value] = ts
+	scan.latest = ts
+
+	if len(scan.conns) <= s.limit {
+		return nil
+	}
+
+	// Starts the group over, so a single scan raises a single alert rather than one per Connection
+	delete(s.groups, key)
+	return []Alert{newGroupAlert(s.alertType, key, scan.conns)}
+}
+
+// Flush returns no Alerts, as scans are alerted on as soon as they cross the limit
+func (s *ScanDetector) Flush() []Alert {
+	return nil
+}
+
+// newGroupAlert builds an Alert from the contributing Connections of a group, ordered by their timestamps
+func newGroupAlert(alertType, key string, conns map[string]Connection) Alert {
+	contributing := make([]Connection, 0, len(conns))
+	for _, conn := range conns {
+		contributing = append(contributing, conn)
+	}
+	sort.Slice(contributing, func(i, j int) bool {
+		iTime, _ := contributing[i].Time()
+		jTime, _ := contributing[j].Time()
+		if iTime.Equal(jTime) {
+			// Keeps the order stable for Connections at the same time, as they were collected from a map
+			return strings.Join(contributing[i].toCSV(), ",") < strings.Join(contributing[j].toCSV(), ",")
+		}
+		return iTime.Before(jTime)
+	})
+
+	return Alert{
+		Type:        alertType,
+		Key:         key,
+		Count:       len(contributing),
+		FirstSeen:   contributing[0].Timestamp,
+		LastSeen:    contributing[len(contributing)-1].Timestamp,
+		Connections: contributing,
+	}
+}
@@ -0,0 +1,108 @@
+package engine_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestScanDetector(t *testing.T) {
+	spec.Run(t, "ScanDetector", testScanDetector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testScanDetector(t *testing.T, when spec.G, it spec.S) {
+	connection := func(timestamp int, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       fmt.Sprintf("%d.000000", timestamp),
+			Source:          net.ParseIP("192.0.0.3"),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	when("vertical scan", func() {
+		it("flags a source touching more than the limit of ports on a single host", func() {
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", 22),
+				connection(1001, "10.0.0.1", 22),
+				connection(1002, "10.0.0.2", 80),
+				connection(1003, "10.0.0.1", 80),
+				connection(1004, "10.0.0.1", 443),
+				connection(1005, "10.0.0.1", 8080),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewVerticalScanDetector(2, time.Minute))
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.VerticalScanAlert,
+				Key:         "source 192.0.0.3 -> host 10.0.0.1",
+				Count:       3,
+				FirstSeen:   conns[0].Timestamp,
+				LastSeen:    conns[4].Timestamp,
+				Connections: []engine.Connection{conns[0], conns[3], conns[4]},
+			}}, result.Alerts)
+		})
+
+		it("forgets ports which fell out of the window", func() {
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", 22),
+				connection(1030, "10.0.0.1", 80),
+				connection(1070, "10.0.0.1", 443),
+				connection(1100, "10.0.0.1", 8080),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewVerticalScanDetector(2, time.Minute))
+			assert.Nil(t, result.Alerts)
+		})
+	})
+
+	when("horizontal sweep", func() {
+		it("flags a source touching more than the limit of hosts on a single port", func() {
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", 445),
+				connection(1001, "10.0.0.2", 445),
+				connection(1002, "10.0.0.3", 22),
+				connection(1003, "10.0.0.3", 445),
+			}
+
+			result := engine.DetectAttacks(nil, conns, engine.NewHorizontalSweepDetector(2, time.Minute))
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.HorizontalSweepAlert,
+				Key:         "source 192.0.0.3 -> port 445/TCP",
+				Count:       3,
+				FirstSeen:   conns[0].Timestamp,
+				LastSeen:    conns[3].Timestamp,
+				Connections: []engine.Connection{conns[0], conns[1], conns[3]},
+			}}, result.Alerts)
+		})
+	})
+
+	when("several detectors", func() {
+		it("runs all of them alongside the policy", func() {
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.1", 22),
+				connection(1001, "10.0.0.2", 22),
+				connection(1002, "10.0.0.2", 23),
+			}
+
+			result := engine.DetectAttacks([]engine.Policy{{
+				ID:      "1",
+				Name:    "inspect SSH",
+				Ports:   []engine.Port{{Start: 22, End: 22}},
+				Verdict: engine.InspectVerdict,
+			}}, conns, engine.NewVerticalScanDetector(1, time.Minute), engine.NewHorizontalSweepDetector(1, time.Minute))
+			assert.Equal(t, 2, len(result.Suspicious))
+			assert.Equal(t, 2, len(result.Alerts))
+			assert.Equal(t, engine.HorizontalSweepAlert, result.Alerts[0].Type)
+			assert.Equal(t, engine.VerticalScanAlert, result.Alerts[1].Type)
+		})
+	})
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"encoding/csv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Schema describes the layout of the connections files which are read, when it differs from the default: a comma
+// separated file, whose columns are in the order of the default header (timestamp, source, source_port, destination,
+// destination_port and protocol). Files with a header are mapped by the header's column names, so their columns may be
+// in any order, and may be named by any of the common aliases (e.g. `src_ip` or `dport`).
+type Schema struct {
+	// Columns names the columns of files without a header, in order, and a column named `-` is skipped. The default
+	// header's order is used when it is nil.
+	Columns []string
+	// Delimiter separates the columns, and is a comma unless set
+	Delimiter rune
+	// Comment starts the lines which are skipped, and comments aren't supported unless it is set
+	Comment rune
+	// LazyQuotes allows quotes within unquoted columns, and unescaped quotes within quoted ones
+	LazyQuotes bool
+}
+
+// SkippedColumn names a column of a file without a header which isn't a field of the Connection
+var SkippedColumn = "-"
+
+// requiredFields are the fields a header must have, while the ports may be missing (e.g. for ICMP)
+var requiredFields = []string{"timestamp", "source", "destination", "protocol"}
+
+// fieldAliases maps the column names used by different collectors (once normalized, see normalizeColumn) to the fields
+// of a Connection, which are named as in the default header
+var fieldAliases = map[string]string{
+	"timestamp": "timestamp", "ts": "timestamp", "time": "timestamp", "start_time": "timestamp", "start": "timestamp",
+	"source": "source", "src": "source", "src_ip": "source", "source_ip": "source", "src_addr": "source", "saddr": "source",
+	"source_port": "source_port", "src_port": "source_port", "sport": "source_port",
+	"destination": "destination", "dst": "destination", "dst_ip": "destination", "destination_ip": "destination", "dst_addr": "destination", "daddr": "destination",
+	"destination_port": "destination_port", "dst_port": "destination_port", "dport": "destination_port",
+	"protocol": "protocol", "proto": "protocol", "ip_proto": "protocol",
+}
+
+// ParseSchemaRune parses a delimiter or comment character, which may be given as `tab` or `\t` for a tab. An empty
+// value returns 0, for the default.
+func ParseSchemaRune(value string) (rune, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == utf8.RuneError {
+		return 0, errors.Errorf("expected a single character, got %q", value)
+	}
+	if r == '"' || r == '\r' || r == '\n' {
+		return 0, errors.Errorf("%q can't be used as a delimiter or comment character", value)
+	}
+	return r, nil
+}
+
+// normalizeColumn returns the column name in lower case, with spaces, dashes and dots replaced by underscores
+func normalizeColumn(name string) string {
+	return strings.NewReplacer(" ", "_", "-", "_", ".", "_").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// columnMapping holds the index of the column of each field of the default header, which is -1 for a missing field
+type columnMapping []int
+
+// configure sets the Schema's dialect on a reader
+func (s Schema) configure(r *csv.Reader) {
+	if s.Delimiter != 0 {
+		r.Comma = s.Delimiter
+	}
+	r.Comment = s.Comment
+	r.LazyQuotes = s.LazyQuotes
+}
+
+// headerMapping maps the columns of a header row, and returns false if the row isn't a header, meaning none of its
+// columns is a known field. A header with missing or duplicate fields returns an error.
+func headerMapping(row []string) (columnMapping, bool, error) {
+	mapping := newColumnMapping()
+	found := false
+	for i, column := range row {
+		field, ok := fieldAliases[normalizeColumn(column)]
+		if !ok {
+			continue
+		}
+		found = true
+		index := indexOf(headerRow, field)
+		if mapping[index] >= 0 {
+			return nil, true, errors.Errorf("header columns %q and %q are both the %s", row[mapping[index]], column, field)
+		}
+		mapping[index] = i
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	var missing []string
+	for _, field := range requiredFields {
+		if mapping[indexOf(headerRow, field)] < 0 {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) != 0 {
+		return nil, true, errors.Errorf("header is missing the %s column(s)", strings.Join(missing, ", "))
+	}
+	return mapping, true, nil
+}
+
+// columnsMapping maps the Schema's columns of files without a header
+func (s Schema) columnsMapping() (columnMapping, error) {
+	if s.Columns == nil {
+		mapping := newColumnMapping()
+		for i := range mapping {
+			mapping[i] = i
+		}
+		return mapping, nil
+	}
+
+	headerLike := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		if column == SkippedColumn {
+			continue
+		}
+		if _, ok := fieldAliases[normalizeColumn(column)]; !ok {
+			return nil, errors.Errorf("unknown schema column %q, expected one of %s or %s", column, strings.Join(headerRow, ", "), SkippedColumn)
+		}
+		headerLike[i] = column
+	}
+
+	mapping, ok, err := headerMapping(headerLike)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid schema columns")
+	}
+	if !ok {
+		return nil, errors.Errorf("invalid schema columns: none of them is one of %s", strings.Join(headerRow, ", "))
+	}
+	return mapping, nil
+}
+
+func newColumnMapping() columnMapping {
+	mapping := make(columnMapping, len(headerRow))
+	for i := range mapping {
+		mapping[i] = -1
+	}
+	return mapping
+}
+
+// width is the amount of columns a row must have, to hold each of the mapped fields
+func (m columnMapping) width() int {
+	width := 0
+	for _, index := range m {
+		if index+1 > width {
+			width = index + 1
+		}
+	}
+	return width
+}
+
+// row reorders the columns of a row into the order of the default header, so that it can be parsed by NewConnection
+func (m columnMapping) row(columns []string) []string {
+	row := make([]string, len(m))
+	for field, index := range m {
+		if index >= 0 {
+			row[field] = columns[index]
+		}
+	}
+	return row
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,161 @@
+package engine_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestSchema(t *testing.T) {
+	spec.Run(t, "Schema", testSchema, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSchema(t *testing.T, when spec.G, it spec.S) {
+	conn := engine.Connection{
+		Timestamp: "1599665118.593452",
+		Source: net.ParseIP("192.0.0.2"),
+		SourcePort: 5000,
+		Destination: net.ParseIP("192.128.0.32"),
+		DestinationPort: 51000,
+		Protocol: "TCP",
+	}
+
+	read := func(schema engine.Schema, content string) ([]engine.Connection, error) {
+		connectionRW := engine.ConnectionsReadWriter{Schema: schema}
+		return connectionRW.ReadStream(bytes.NewBufferString(content))
+	}
+
+	when("the file has a header", func() {
+		it("maps reordered and aliased columns", func() {
+			connections, err := read(engine.Schema{}, "Proto,dst_ip,DPORT,src ip,sport,ts,bytes\n" +
+				"TCP,192.128.0.32,51000,192.0.0.2,5000,1599665118.593452,1024\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn}, connections)
+		})
+
+		it("leaves missing ports at zero", func() {
+			connections, err := read(engine.Schema{}, "timestamp,source,destination,protocol\n" +
+				"1599665118.593452,192.0.0.2,192.128.0.32,ICMP\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{{
+				Timestamp: "1599665118.593452",
+				Source: net.ParseIP("192.0.0.2"),
+				Destination: net.ParseIP("192.128.0.32"),
+				Protocol: "ICMP",
+			}}, connections)
+		})
+
+		it("strips a byte order mark, CRLF endings, and repeated headers", func() {
+			header := "timestamp,source,source_port,destination,destination_port,protocol\r\n"
+			row := "1599665118.593452,192.0.0.2,5000,192.128.0.32,51000,TCP\r\n"
+			connections, err := read(engine.Schema{}, "\ufeff" + header + row + header + row)
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn, conn}, connections)
+		})
+
+		it("returns an error when a required column is missing", func() {
+			_, err := read(engine.Schema{}, "timestamp,src,dst\n1599665118.593452,192.0.0.2,192.128.0.32\n")
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "header is missing the protocol column(s)")
+		})
+
+		it("returns an error when two columns are the same field", func() {
+			_, err := read(engine.Schema{}, "timestamp,src,saddr,destination,protocol\n")
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), `header columns "src" and "saddr" are both the source`)
+		})
+	})
+
+	when("the file has no header", func() {
+		it("reads the default column order", func() {
+			connections, err := read(engine.Schema{}, "1599665118.593452,192.0.0.2,5000,192.128.0.32,51000,TCP\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn}, connections)
+		})
+
+		it("reads the schema's columns, skipping those named -", func() {
+			schema := engine.Schema{Columns: []string{"src", "dst", "-", "dport", "proto", "ts"}}
+			connections, err := read(schema, "192.0.0.2,192.128.0.32,ignored,51000,TCP,1599665118.593452\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{{
+				Timestamp: "1599665118.593452",
+				Source: net.ParseIP("192.0.0.2"),
+				Destination: net.ParseIP("192.128.0.32"),
+				DestinationPort: 51000,
+				Protocol: "TCP",
+			}}, connections)
+		})
+
+		it("returns an error for an unknown schema column", func() {
+			_, err := read(engine.Schema{Columns: []string{"ts", "src", "dst", "proto", "bytes"}}, "1,2,3,4,5\n")
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), `unknown schema column "bytes"`)
+		})
+
+		it("returns an error for a schema missing a required column", func() {
+			_, err := read(engine.Schema{Columns: []string{"ts", "src", "dst"}}, "1,2,3\n")
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "invalid schema columns: header is missing the protocol column(s)")
+		})
+
+		it("returns an error for a row with too few columns", func() {
+			schema := engine.Schema{Columns: []string{"ts", "src", "dst", "proto"}}
+			_, err := read(schema, "1599665118.593452,192.0.0.2,192.128.0.32\n")
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 1 has 3 column(s), but 4 are expected")
+		})
+	})
+
+	when("the file has a different dialect", func() {
+		it("reads tab and semicolon delimiters", func() {
+			connections, err := read(engine.Schema{Delimiter: '\t'}, "ts\tsrc\tsport\tdst\tdport\tproto\n" +
+				"1599665118.593452\t192.0.0.2\t5000\t192.128.0.32\t51000\tTCP\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn}, connections)
+
+			connections, err = read(engine.Schema{Delimiter: ';'}, "1599665118.593452;192.0.0.2;5000;192.128.0.32;51000;TCP\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn}, connections)
+		})
+
+		it("skips comment lines", func() {
+			connections, err := read(engine.Schema{Comment: '#'}, "# exported by collector-1\n" +
+				"1599665118.593452,192.0.0.2,5000,192.128.0.32,51000,TCP\n")
+			assert.Nil(t, err)
+			assert.Equal(t, []engine.Connection{conn}, connections)
+		})
+
+		it("allows improper quotes only with lazy quotes", func() {
+			content := "1599665118.593452,192.0.0.2,5000,192.128.0.32,51000,T\"CP\n"
+			_, err := read(engine.Schema{}, content)
+			assert.NotNil(t, err)
+
+			connections, err := read(engine.Schema{LazyQuotes: true}, content)
+			assert.Nil(t, err)
+			assert.Equal(t, "T\"CP", connections[0].Protocol)
+		})
+	})
+
+	when("#ParseSchemaRune", func() {
+		it("parses single characters and tabs", func() {
+			for value, expected := range map[string]rune{"": 0, ";": ';', "tab": '\t', `\t`: '\t', "|": '|'} {
+				r, err := engine.ParseSchemaRune(value)
+				assert.Nil(t, err)
+				assert.Equal(t, expected, r)
+			}
+		})
+
+		it("returns an error for improper characters", func() {
+			for _, value := range []string{";;", `"`, "\n"} {
+				_, err := engine.ParseSchemaRune(value)
+				assert.NotNil(t, err)
+			}
+		})
+	})
+}
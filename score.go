@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"math"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// The functions which combine the parts of a risk score, where rules is the sum of the weights of the matched INSPECT
+// rules, detectors is the sum of the weights of the detector signals, and criticality is the highest criticality of the
+// Connection's Assets
+var (
+	WeightedScore = "weighted"
+	SumScore      = "sum"
+	MaxScore      = "max"
+)
+
+// ScoreFunctions describes each of the score functions, for the report
+var ScoreFunctions = map[string]string{
+	WeightedScore: "(rules + detectors) * criticality",
+	SumScore:      "rules + detectors + criticality",
+	MaxScore:      "max(rules, detectors) * criticality",
+}
+
+// DefaultRuleScore is the weight of the rules which don't set a score
+var DefaultRuleScore = 1.0
+
+// Scorer computes the risk score of Connections, so that suspicious Connections can be prioritized
+type Scorer struct {
+	function       string
+	detectorWeight float64
+	policies       map[string]Policy
+	assets         []Asset
+	// signals holds the weight of each Alert type (or rule, for rule Alerts) raised for each source host
+	signals map[string]map[string]float64
+}
+
+// NewScorer returns a Scorer, which combines the rules matched by a Connection, the Alerts raised for its source host
+// (see AddAlerts), and the criticality of its Assets using the score function
+func NewScorer(function string, detectorWeight float64, policies []Policy, assets []Asset) (*Scorer, error) {
+	if _, ok := ScoreFunctions[function]; !ok {
+		return nil, errors.Errorf("unknown score function %s", function)
+	}
+
+	s := &Scorer{
+		function:       function,
+		detectorWeight: detectorWeight,
+		policies:       map[string]Policy{},
+		assets:         assets,
+		signals:        map[string]map[string]float64{},
+	}
+	for _, policy := range policies {
+		s.policies[policy.ID] = policy
+	}
+	return s, nil
+}
+
+// AddAlerts records the Alerts as signals for the source hosts of their Connections. Detector Alerts weigh the detector
+// weight, and the Alerts of threshold and sequence rules weigh the rule's score. Each Alert type (or rule) is only
+// counted once for each host.
+func (s *Scorer) AddAlerts(alerts []Alert) {
+	for _, alert := range alerts {
+		signal, weight := alert.Type, s.detectorWeight
+		if alert.RuleID != "" {
+			signal, weight = "rule "+alert.RuleID, ruleScore(s.policies[alert.RuleID])
+		}
+		for _, conn := range alert.Connections {
+			host := conn.Source.String()
+			if s.signals[host] == nil {
+				s.signals[host] = map[string]float64{}
+			}
+			s.signals[host][signal] = weight
+		}
+	}
+}
+
+func ruleScore(policy Policy) float64 {
+	if policy.Score == nil {
+		return DefaultRuleScore
+	}
+	return *policy.Score
+}
+
+// Describe returns the score function, e.g. `(rules + detectors) * criticality`
+func (s *Scorer) Describe() string {
+	return ScoreFunctions[s.function]
+}
+
+// Score returns the risk score of a Connection, given the Policies it matched
+func (s *Scorer) Score(conn Connection, matched []Policy) float64 {
+	rules := 0.0
+	for _, policy := range matched {
+		if policy.Verdict == InspectVerdict {
+			rules += ruleScore(policy)
+		}
+	}
+
+	detectors := 0.0
+	for _, weight := range s.signals[conn.Source.String()] {
+		detectors += weight
+	}
+
+	criticality := math.Max(s.criticality(conn.Source), s.criticality(conn.Destination))
+
+	switch s.function {
+	case SumScore:
+		return rules + detectors + criticality
+	case MaxScore:
+		return math.Max(rules, detectors) * criticality
+	default:
+		return (rules + detectors) * criticality
+	}
+}
+
+func (s *Scorer) criticality(ip net.IP) float64 {
+	if asset, ok := FindAsset(s.assets, ip); ok {
+		return asset.Criticality
+	}
+	return DefaultCriticality
+}
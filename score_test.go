@@ -0,0 +1,101 @@
+package engine_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestScorer(t *testing.T) {
+	spec.Run(t, "Scorer", testScorer, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testScorer(t *testing.T, when spec.G, it spec.S) {
+	var (
+		policies []engine.Policy
+		assets   []engine.Asset
+	)
+
+	connection := func(source, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       "1000",
+			Source:          net.ParseIP(source),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	score := func(scorer *engine.Scorer, conn engine.Connection) float64 {
+		return scorer.Score(conn, engine.Evaluate(policies, conn).Matched)
+	}
+
+	it.Before(func() {
+		var err error
+		policies, err = engine.PolicyReader{}.Read(filepath.Join(testdataPath, "score_policy.json"))
+		assert.Nil(t, err)
+		assets, err = engine.AssetReader{}.Read(filepath.Join(testdataPath, "assets.json"))
+		assert.Nil(t, err)
+	})
+
+	when("reading the policy", func() {
+		it("keeps the score weights, and skips improper ones", func() {
+			assert.Equal(t, 3.0, *policies[0].Score)
+			assert.Nil(t, policies[1].Score)
+			assert.Nil(t, policies[3].Score)
+		})
+	})
+
+	when("#Score", func() {
+		it("weighs the matched INSPECT rules by the criticality of the assets", func() {
+			scorer, err := engine.NewScorer(engine.WeightedScore, 1, policies, assets)
+			assert.Nil(t, err)
+			assert.Equal(t, "(rules + detectors) * criticality", scorer.Describe())
+
+			assert.Equal(t, 3.0, score(scorer, connection("192.168.0.1", "10.0.2.5", 22)))
+			assert.Equal(t, 20.0, score(scorer, connection("192.168.0.1", "10.0.1.5", 22)))
+			assert.Equal(t, 5.0, score(scorer, connection("10.0.1.5", "192.168.0.1", 443)))
+		})
+
+		it("adds the weight of each type of alert raised for the source host once", func() {
+			scorer, err := engine.NewScorer(engine.WeightedScore, 2, policies, assets)
+			assert.Nil(t, err)
+
+			conn := connection("192.168.0.1", "10.0.2.5", 22)
+			scorer.AddAlerts([]engine.Alert{
+				{Type: engine.BeaconAlert, Connections: []engine.Connection{conn, conn}},
+				{Type: engine.BeaconAlert, Connections: []engine.Connection{conn}},
+				{Type: engine.ThresholdAlert, RuleID: "inspect-ssh", Connections: []engine.Connection{conn}},
+				{Type: engine.VerticalScanAlert, Connections: []engine.Connection{connection("192.168.0.9", "10.0.2.5", 22)}},
+			})
+			assert.Equal(t, 8.0, score(scorer, conn))
+		})
+
+		it("combines the parts with the configured function", func() {
+			conn := connection("192.168.0.1", "10.0.1.5", 22)
+			alerts := []engine.Alert{{Type: engine.BeaconAlert, Connections: []engine.Connection{conn}}}
+
+			sum, err := engine.NewScorer(engine.SumScore, 1, policies, assets)
+			assert.Nil(t, err)
+			sum.AddAlerts(alerts)
+			assert.Equal(t, 10.0, score(sum, conn))
+
+			max, err := engine.NewScorer(engine.MaxScore, 1, policies, assets)
+			assert.Nil(t, err)
+			max.AddAlerts(alerts)
+			assert.Equal(t, 20.0, score(max, conn))
+		})
+
+		it("returns an error for an unknown function", func() {
+			_, err := engine.NewScorer("median", 1, policies, assets)
+			assert.Error(t, err)
+		})
+	})
+}
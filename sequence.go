@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	SequenceAlert = "SEQUENCE"
+)
+
+var (
+	JoinSameSource               = "same_source"
+	JoinSameDestination          = "same_destination"
+	JoinDestinationBecomesSource = "destination_becomes_source"
+)
+
+// Sequence turns a Policy into a correlation rule, which alerts when Connections match each of its Steps (the IDs of
+// other rules) in order, within Window of the first step. Consecutive steps are correlated by the Join key, e.g. with
+// JoinDestinationBecomesSource, the destination of one step has to be the source of the next.
+type Sequence struct {
+	Steps  []string
+	Join   string
+	Window time.Duration
+}
+
+// newSequence parses the untyped `sequence` object of a policy
+func newSequence(raw interface{}) (*Sequence, error) {
+	sequenceMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("sequence should be an object")
+	}
+
+	rawSteps, ok := sequenceMap["steps"].([]interface{})
+	if !ok || len(rawSteps) < 2 {
+		return nil, errors.Errorf("invalid steps %v, expected a list of at least 2 rule IDs", sequenceMap["steps"])
+	}
+	var steps []string
+	for _, step := range rawSteps {
+		steps = append(steps, fmt.Sprintf("%v", step))
+	}
+
+	window, err := parseWindow(sequenceMap["window"])
+	if err != nil {
+		return nil, err
+	}
+
+	join := JoinSameSource
+	if value, ok := sequenceMap["join"]; ok {
+		join = fmt.Sprintf("%v", value)
+	}
+	if join != JoinSameSource && join != JoinSameDestination && join != JoinDestinationBecomesSource {
+		return nil, errors.Errorf("invalid join %s, expected one of %s, %s or %s", join, JoinSameSource, JoinSameDestination, JoinDestinationBecomesSource)
+	}
+
+	return &Sequence{Steps: steps, Join: join, Window: window}, nil
+}
+
+// lookup returns the value of a Connection which is correlated with the previous step
+func (s Sequence) lookup(conn Connection) string {
+	if s.Join == JoinSameDestination {
+		return conn.Destination.String()
+	}
+	return conn.Source.String()
+}
+
+// next returns the value of a Connection which the next step is correlated with
+func (s Sequence) next(conn Connection) string {
+	if s.Join == JoinSameSource {
+		return conn.Source.String()
+	}
+	return conn.Destination.String()
+}
+
+// sequenceChain is a partial match of a Sequence, which is waiting for its next step
+type sequenceChain struct {
+	start time.Time
+	conns []Connection
+}
+
+// sequenceStore keeps the partial matches of the sequence rules of a policy. For each step, it only keeps the most
+// recent chain per join value, as it has the most time left in the window, so the state is bounded by the amount of
+// distinct hosts seen within the window.
+type sequenceStore struct {
+	policies []Policy
+	// chains holds, for each policy and step, the chains which have matched up to that step, keyed by their join value
+	chains [][]map[string]*sequenceChain
+}
+
+// newSequenceStore returns a sequenceStore for the sequence rules in the Policy slice, or nil if there aren't any
+func newSequenceStore(policies []Policy) *sequenceStore {
+	store := &sequenceStore{}
+	for _, policy := range policies {
+		if policy.Sequence == nil {
+			continue
+		}
+
+		chains := make([]map[string]*sequenceChain, len(policy.Sequence.Steps))
+		for i := range chains {
+			chains[i] = map[string]*sequenceChain{}
+		}
+		store.policies = append(store.policies, policy)
+		store.chains = append(store.chains, chains)
+	}
+
+	if len(store.policies) == 0 {
+		return nil
+	}
+	return store
+}
+
+// observe correlates a Connection, using the rules it matched, with the partial matches of each sequence rule, and
+// returns an Alert for every sequence it completed. Connections without a valid timestamp are ignored.
+func (s *sequenceStore) observe(conn Connection, matched []Policy) []Alert {
+	if len(matched) == 0 {
+		return nil
+	}
+	ts, err := conn.Time()
+	if err != nil {
+		return nil
+	}
+
+	matchedIDs := map[string]interface{}{}
+	for _, policy := range matched {
+		matchedIDs[policy.ID] = nil
+	}
+
+	var alerts []Alert
+	for i, policy := range s.policies {
+		sequence := policy.Sequence
+		chains := s.chains[i]
+		for _, stepChains := range chains {
+			if len(stepChains) >= maxDetectorGroups {
+				expireChains(chains, ts.Add(-sequence.Window))
+				break
+			}
+		}
+
+		// Goes over the steps in reverse, so that a Connection can't both start a chain and continue it
+		for step := len(sequence.Steps) - 1; step >= 0; step-- {
+			if _, ok := matchedIDs[sequence.Steps[step]]; !ok {
+				continue
+			}
+
+			if step == 0 {
+				chains[0][sequence.next(conn)] = &sequenceChain{start: ts, conns: []Connection{conn}}
+				continue
+			}
+
+			previous, ok := chains[step-1][sequence.lookup(conn)]
+			if !ok || ts.Sub(previous.start) > sequence.Window || ts.Before(previous.start) {
+				continue
+			}
+
+			chain := &sequenceChain{start: previous.start, conns: append(append([]Connection{}, previous.conns...), conn)}
+			if step < len(sequence.Steps)-1 {
+				chains[step][sequence.next(conn)] = chain
+				continue
+			}
+
+			alerts = append(alerts, newSequenceAlert(policy, chain.conns))
+			// The chain is complete, so it isn't continued, to avoid raising an alert for each repetition of the last step
+			delete(chains[step-1], sequence.lookup(conn))
+		}
+	}
+
+	return alerts
+}
+
+// expireChains removes the chains which started before the window
+func expireChains(chains []map[string]*sequenceChain, start time.Time) {
+	for _, stepChains := range chains {
+		for key, chain := range stepChains {
+			if chain.start.Before(start) {
+				delete(stepChains, key)
+			}
+		}
+	}
+}
+
+// newSequenceAlert builds the incident record of a completed sequence, whose key describes the path of the chain
+func newSequenceAlert(policy Policy, conns []Connection) Alert {
+	var path []string
+	for _, conn := range conns {
+		path = append(path, fmt.Sprintf("%s -> %s:%d/%s", conn.Source, conn.Destination, conn.DestinationPort, conn.Protocol))
+	}
+
+	return Alert{
+		Type:        SequenceAlert,
+		RuleID:      policy.ID,
+		RuleName:    policy.Name,
+		Key:         strings.Join(path, ", then "),
+		Count:       len(conns),
+		FirstSeen:   conns[0].Timestamp,
+		LastSeen:    conns[len(conns)-1].Timestamp,
+		Connections: conns,
+	}
+}
@@ -0,0 +1,109 @@
+package engine_test
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestSequence(t *testing.T) {
+	spec.Run(t, "Sequence", testSequence, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSequence(t *testing.T, when spec.G, it spec.S) {
+	var policies []engine.Policy
+
+	it.Before(func() {
+		var err error
+		policies, err = engine.PolicyReader{}.Read(filepath.Join(testdataPath, "sequence_policy.json"))
+		assert.Nil(t, err)
+	})
+
+	connection := func(timestamp int, source, destination string, port int) engine.Connection {
+		return engine.Connection{
+			Timestamp:       fmt.Sprintf("%d.000000", timestamp),
+			Source:          net.ParseIP(source),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        "TCP",
+		}
+	}
+
+	when("policy file has sequence rules", func() {
+		it("parses the valid sequences", func() {
+			assert.Equal(t, 4, len(policies))
+			assert.Equal(t, &engine.Sequence{
+				Steps:  []string{"inspect-ssh", "outbound-https"},
+				Join:   engine.JoinDestinationBecomesSource,
+				Window: 10 * time.Minute,
+			}, policies[2].Sequence)
+			assert.Nil(t, policies[3].Sequence)
+			assert.NotNil(t, policies[3].Improper)
+		})
+
+		it("never matches a rule with an improper sequence, rather than matching each connection", func() {
+			results := engine.DetectAttacks(policies[3:], []engine.Connection{connection(1599665118, "192.0.0.2", "192.128.0.20", 80)})
+			assert.Zero(t, results.RuleCount["broken sequence"])
+			assert.Equal(t, 1, results.NoMatchCount)
+		})
+	})
+
+	when("the steps are matched in order, within the window", func() {
+		it("raises an incident with the contributing connections", func() {
+			conns := []engine.Connection{
+				connection(1000, "192.0.0.3", "10.0.0.5", 22),
+				connection(1100, "10.0.0.6", "8.8.8.8", 443),
+				connection(1200, "10.0.0.5", "8.8.8.8", 443),
+			}
+
+			result := engine.DetectAttacks(policies, conns)
+			assert.Equal(t, []engine.Alert{{
+				Type:        engine.SequenceAlert,
+				RuleID:      "ssh-then-https",
+				RuleName:    "SSH pivot to HTTPS",
+				Key:         "192.0.0.3 -> 10.0.0.5:22/TCP, then 10.0.0.5 -> 8.8.8.8:443/TCP",
+				Count:       2,
+				FirstSeen:   "1000.000000",
+				LastSeen:    "1200.000000",
+				Connections: []engine.Connection{conns[0], conns[2]},
+			}}, result.Alerts)
+			assert.Equal(t, 1, len(result.Suspicious))
+			assert.Equal(t, 1, result.RuleCount["inspect SSH"])
+			assert.Equal(t, 2, result.RuleCount["outbound HTTPS"])
+			assert.Equal(t, 0, result.RuleCount["SSH pivot to HTTPS"])
+		})
+	})
+
+	when("the steps are matched out of order", func() {
+		it("doesn't raise an incident", func() {
+			conns := []engine.Connection{
+				connection(1000, "10.0.0.5", "8.8.8.8", 443),
+				connection(1100, "192.0.0.3", "10.0.0.5", 22),
+			}
+
+			result := engine.DetectAttacks(policies, conns)
+			assert.Nil(t, result.Alerts)
+		})
+	})
+
+	when("the last step is outside of the window", func() {
+		it("doesn't raise an incident", func() {
+			conns := []engine.Connection{
+				connection(1000, "192.0.0.3", "10.0.0.5", 22),
+				connection(1601, "10.0.0.5", "8.8.8.8", 443),
+			}
+
+			result := engine.DetectAttacks(policies, conns)
+			assert.Nil(t, result.Alerts)
+		})
+	})
+}
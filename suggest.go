@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SuggestConfig configures how far Suggest generalizes the observed traffic into rules
+type SuggestConfig struct {
+	// CIDRPrefix and CIDRMinHosts aggregate the destinations of a port into a single network of CIDRPrefix bits (for
+	// IPv4, and 96 bits more for IPv6), once at least CIDRMinHosts distinct addresses were observed within it.
+	// A CIDRMinHosts of 0 disables the aggregation.
+	CIDRPrefix   int
+	CIDRMinHosts int
+	// PortGap merges the ports of the same destinations into a single range, when they are at most PortGap apart
+	PortGap int
+	// MinConnections drops the suggested rules which cover fewer Connections than it
+	MinConnections int
+}
+
+// Suggestion is an IGNORE rule proposed by Suggest, together with the amount of Connections it was built from
+type Suggestion struct {
+	ID        string
+	Name      string
+	// IPs holds the CIDRs of the destinations, which are written as the rule's `ips` when they are a single address,
+	// and as its `networks` otherwise
+	IPs       []string
+	Ports     []Port
+	Protocols []string
+	// Connections is the amount of input Connections the rule covers, and Percent is their share of the input, rounded
+	// to two decimals
+	Connections int
+	Percent     float64
+}
+
+type suggestionJson struct {
+	policyJson
+	Coverage suggestionCoverage `json:"coverage"`
+}
+
+type suggestionCoverage struct {
+	Connections int     `json:"connections"`
+	Percent     float64 `json:"percent"`
+}
+
+func (s Suggestion) toJson() suggestionJson {
+	suggestion := suggestionJson{
+		policyJson: policyJson{ID: s.ID, Name: s.Name, Verdict: IgnoreVerdict},
+		Coverage:   suggestionCoverage{Connections: s.Connections, Percent: s.Percent},
+	}
+	// A single address is matched by the rule's IPs, while a wider network is only matched as a whole by its networks
+	for _, cidr := range s.IPs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			if ones, bits := network.Mask.Size(); ones < bits {
+				suggestion.Networks = append(suggestion.Networks, cidr)
+				continue
+			}
+		}
+		suggestion.IPs = append(suggestion.IPs, cidr)
+	}
+	for _, port := range s.Ports {
+		suggestion.Ports = append(suggestion.Ports, port)
+	}
+	for _, protocol := range s.Protocols {
+		suggestion.Protocols = append(suggestion.Protocols, protocol)
+	}
+	return suggestion
+}
+
+// suggestedTarget is the set of destinations of a single protocol and port, after their aggregation into networks
+type suggestedTarget struct {
+	protocol    string
+	port        int
+	networks    []string
+	connections int
+}
+
+// Suggest proposes a set of IGNORE rules covering Connections which are believed to be clean.
+// The destinations of each protocol and port are aggregated into networks, and then the ports which share the same
+// destinations are merged into ranges, so each rule allows a single service. Connections without a destination port
+// (e.g. ICMP) are covered by rules without ports, and those without a destination IP (e.g. ARP) by rules without IPs.
+func Suggest(conns []Connection, config SuggestConfig) []Suggestion {
+	type portKey struct {
+		protocol string
+		port     int
+	}
+	// A nil destination is kept as an empty address, which can't be limited to any network
+	destinations := map[portKey]map[string]int{}
+	for _, conn := range conns {
+		key := portKey{protocol: conn.Protocol, port: conn.DestinationPort}
+		if destinations[key] == nil {
+			destinations[key] = map[string]int{}
+		}
+		address := ""
+		if conn.Destination != nil {
+			address = conn.Destination.String()
+		}
+		destinations[key][address]++
+	}
+
+	// Ports are only merged when they share the same protocol and destinations, and ports without a number never are
+	targets := map[string][]suggestedTarget{}
+	var signatures []string
+	for key, addresses := range destinations {
+		target := suggestedTarget{protocol: key.protocol, port: key.port, networks: aggregateNetworks(addresses, config)}
+		for _, count := range addresses {
+			target.connections += count
+		}
+
+		signature := fmt.Sprintf("%s|%t|%s", key.protocol, key.port == 0, strings.Join(target.networks, ","))
+		if _, ok := targets[signature]; !ok {
+			signatures = append(signatures, signature)
+		}
+		targets[signature] = append(targets[signature], target)
+	}
+
+	var suggestions []Suggestion
+	for _, signature := range signatures {
+		grouped := targets[signature]
+		sort.Slice(grouped, func(i, j int) bool {
+			return grouped[i].port < grouped[j].port
+		})
+
+		suggestion := Suggestion{Protocols: []string{grouped[0].protocol}, IPs: grouped[0].networks}
+		if suggestion.Protocols[0] == "" {
+			suggestion.Protocols = nil
+		}
+		for _, target := range grouped {
+			suggestion.Connections += target.connections
+			if target.port == 0 {
+				continue
+			}
+			last := len(suggestion.Ports) - 1
+			if last >= 0 && target.port-suggestion.Ports[last].End <= config.PortGap {
+				suggestion.Ports[last].End = target.port
+			} else {
+				suggestion.Ports = append(suggestion.Ports, Port{Start: target.port, End: target.port})
+			}
+		}
+
+		if suggestion.Connections < config.MinConnections {
+			continue
+		}
+		suggestion.Percent = math.Round(10000*float64(suggestion.Connections)/float64(len(conns))) / 100
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Connections != suggestions[j].Connections {
+			return suggestions[i].Connections > suggestions[j].Connections
+		}
+		return suggestions[i].describe() < suggestions[j].describe()
+	})
+	for i := range suggestions {
+		suggestions[i].ID = fmt.Sprintf("suggested-%d", i+1)
+		suggestions[i].Name = "ignore " + suggestions[i].describe()
+	}
+	return suggestions
+}
+
+// aggregateNetworks returns the sorted CIDRs covering the addresses. The addresses within a network of the configured
+// prefix are replaced by it, once there are enough of them, and an empty address (i.e. any address) replaces them all.
+func aggregateNetworks(addresses map[string]int, config SuggestConfig) []string {
+	if _, ok := addresses[""]; ok {
+		return nil
+	}
+
+	hosts := map[string][]net.IP{}
+	for address := range addresses {
+		ip := net.ParseIP(address)
+		bits, prefix := 128, config.CIDRPrefix+96
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits, prefix = ip4, 32, config.CIDRPrefix
+		}
+		if config.CIDRPrefix <= 0 || prefix > bits {
+			prefix = bits
+		}
+
+		mask := net.CIDRMask(prefix, bits)
+		network := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		hosts[network.String()] = append(hosts[network.String()], ip)
+	}
+
+	var networks []net.IPNet
+	for network, ips := range hosts {
+		if len(ips) >= config.CIDRMinHosts && config.CIDRMinHosts > 0 {
+			_, parsed, _ := net.ParseCIDR(network)
+			networks = append(networks, *parsed)
+			continue
+		}
+		for _, ip := range ips {
+			networks = append(networks, net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+
+	sort.Slice(networks, func(i, j int) bool {
+		if len(networks[i].IP) != len(networks[j].IP) {
+			return len(networks[i].IP) < len(networks[j].IP)
+		}
+		if cmp := bytes.Compare(networks[i].IP, networks[j].IP); cmp != 0 {
+			return cmp < 0
+		}
+		return networks[i].String() < networks[j].String()
+	})
+	cidrs := make([]string, 0, len(networks))
+	for _, network := range networks {
+		cidrs = append(cidrs, network.String())
+	}
+	return cidrs
+}
+
+// describe summarizes the Suggestion in a single line, e.g. `TCP 80, 443 to 10.0.1.0/24`
+func (s Suggestion) describe() string {
+	protocol := "any protocol"
+	if len(s.Protocols) != 0 {
+		protocol = strings.Join(s.Protocols, ", ")
+	}
+
+	var ports []string
+	for _, port := range s.Ports {
+		if port.Start == port.End {
+			ports = append(ports, strconv.Itoa(port.Start))
+		} else {
+			ports = append(ports, fmt.Sprintf("%d-%d", port.Start, port.End))
+		}
+	}
+	if len(ports) != 0 {
+		protocol += " " + strings.Join(ports, ", ")
+	}
+
+	switch {
+	case len(s.IPs) == 0:
+		return protocol + " to any address"
+	case len(s.IPs) <= 2:
+		return protocol + " to " + strings.Join(s.IPs, ", ")
+	default:
+		return fmt.Sprintf("%s to %s and %d more", protocol, s.IPs[0], len(s.IPs)-1)
+	}
+}
+
+// SuggestionsWriter writes Suggestions as a policy file, which can be read by the PolicyReader
+type SuggestionsWriter struct{}
+
+// Write the Suggestions to the output path, with the coverage of each rule kept alongside it
+func (w SuggestionsWriter) Write(suggestions []Suggestion, path string) error {
+	fmt.Fprintf(statusOutput, "Writing suggested policy file to %s\n", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating directory %s", filepath.Dir(path))
+	}
+
+	suggestionsJson := make([]suggestionJson, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		suggestionsJson = append(suggestionsJson, suggestion.toJson())
+	}
+
+	content, err := json.MarshalIndent(suggestionsJson, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling suggested policy")
+	}
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrapf(err, "writing file %s", path)
+	}
+
+	log.Println("Successfully wrote file.")
+	return nil
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// NewSuggestCommand creates a CLI which proposes IGNORE rules covering a connections file which is believed to be
+// clean, and writes them as a policy file
+func NewSuggestCommand() *cobra.Command {
+	suggestConnectionsPaths := networkConnectionsPaths
+	suggestOutputPath := filepath.Join("out", "suggested_policy.json")
+	config := SuggestConfig{CIDRPrefix: 24, CIDRMinHosts: 4, PortGap: 1, MinConnections: 1}
+	cmd := &cobra.Command{
+		Use:   "suggest",
+		Short: "Suggest IGNORE rules covering a connections file of known-good traffic",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return suggestPolicy(cmd.OutOrStdout(), suggestConnectionsPaths, suggestOutputPath, config)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&suggestConnectionsPaths, "connections", "c", suggestConnectionsPaths, "Paths, globs or directories of valid connections files, of known-good traffic")
+	cmd.Flags().StringVarP(&suggestOutputPath, "output", "o", suggestOutputPath, "Path for output suggested policy JSON file")
+	cmd.Flags().IntVar(&config.CIDRPrefix, "cidr-prefix", config.CIDRPrefix, "Prefix length of the networks which destinations are aggregated into (for IPv4, and 96 more for IPv6)")
+	cmd.Flags().IntVar(&config.CIDRMinHosts, "cidr-min-hosts", config.CIDRMinHosts, "Aggregate the destinations of a port into their network, once at least this many were seen within it (0 disables)")
+	cmd.Flags().IntVar(&config.PortGap, "port-gap", config.PortGap, "Merge the ports of the same destinations into a range, when they are at most this far apart")
+	cmd.Flags().IntVar(&config.MinConnections, "min-connections", config.MinConnections, "Drop the suggested rules which cover fewer connections than this")
+
+	return cmd
+}
+
+func suggestPolicy(out io.Writer, connectionsPaths []string, outputPath string, config SuggestConfig) error {
+	connectionsRW, err := newConnectionsReadWriter()
+	if err != nil {
+		return err
+	}
+	connections, _, err := readConnections(connectionsRW, connectionsPaths)
+	if err != nil {
+		return err
+	}
+
+	suggestions := Suggest(connections, config)
+	covered := 0
+	for _, suggestion := range suggestions {
+		covered += suggestion.Connections
+		fmt.Fprintf(out, "%s: %s covers %d connection(s) (%.2f%%)\n", suggestion.ID, suggestion.Name, suggestion.Connections, suggestion.Percent)
+	}
+
+	percent := 0.0
+	if len(connections) != 0 {
+		percent = 100 * float64(covered) / float64(len(connections))
+	}
+	fmt.Fprintf(out, "\n%d rule(s) cover %d of %d connection(s) (%.2f%%)\n", len(suggestions), covered, len(connections), percent)
+
+	suggestionsWriter := SuggestionsWriter{}
+	return suggestionsWriter.Write(suggestions, outputPath)
+}
@@ -0,0 +1,143 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestSuggest(t *testing.T) {
+	spec.Run(t, "Suggest", testSuggest, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSuggest(t *testing.T, when spec.G, it spec.S) {
+	var (
+		conns  []engine.Connection
+		config engine.SuggestConfig
+	)
+
+	connection := func(destination string, port int, protocol string) engine.Connection {
+		return engine.Connection{
+			Timestamp:       "1000",
+			Source:          net.ParseIP("192.168.0.7"),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        protocol,
+		}
+	}
+
+	it.Before(func() {
+		config = engine.SuggestConfig{CIDRPrefix: 24, CIDRMinHosts: 3, PortGap: 1, MinConnections: 1}
+		conns = []engine.Connection{
+			connection("10.0.1.1", 443, "TCP"),
+			connection("10.0.1.2", 443, "TCP"),
+			connection("10.0.1.3", 443, "TCP"),
+			connection("10.0.1.3", 443, "TCP"),
+			connection("10.0.2.5", 8080, "TCP"),
+			connection("10.0.2.5", 8081, "TCP"),
+			connection("10.0.2.5", 8083, "TCP"),
+			connection("10.0.3.9", 0, "ICMP"),
+		}
+	})
+
+	when("#Suggest", func() {
+		it("aggregates destinations into networks, and ports into ranges", func() {
+			assert.Equal(t, []engine.Suggestion{
+				{
+					ID:          "suggested-1",
+					Name:        "ignore TCP 443 to 10.0.1.0/24",
+					IPs:         []string{"10.0.1.0/24"},
+					Ports:       []engine.Port{{Start: 443, End: 443}},
+					Protocols:   []string{"TCP"},
+					Connections: 4,
+					Percent:     50,
+				},
+				{
+					ID:          "suggested-2",
+					Name:        "ignore TCP 8080-8081, 8083 to 10.0.2.5/32",
+					IPs:         []string{"10.0.2.5/32"},
+					Ports:       []engine.Port{{Start: 8080, End: 8081}, {Start: 8083, End: 8083}},
+					Protocols:   []string{"TCP"},
+					Connections: 3,
+					Percent:     37.5,
+				},
+				{
+					ID:          "suggested-3",
+					Name:        "ignore ICMP to 10.0.3.9/32",
+					IPs:         []string{"10.0.3.9/32"},
+					Protocols:   []string{"ICMP"},
+					Connections: 1,
+					Percent:     12.5,
+				},
+			}, engine.Suggest(conns, config))
+		})
+
+		it("keeps the addresses when there are too few of them in a network", func() {
+			config.CIDRMinHosts = 4
+			suggestions := engine.Suggest(conns, config)
+			assert.Equal(t, []string{"10.0.1.1/32", "10.0.1.2/32", "10.0.1.3/32"}, suggestions[0].IPs)
+			assert.Equal(t, "ignore TCP 443 to 10.0.1.1/32 and 2 more", suggestions[0].Name)
+		})
+
+		it("merges ports which are further apart with a larger gap", func() {
+			config.PortGap = 2
+			suggestions := engine.Suggest(conns, config)
+			assert.Equal(t, []engine.Port{{Start: 8080, End: 8083}}, suggestions[1].Ports)
+		})
+
+		it("drops the rules covering too few connections", func() {
+			config.MinConnections = 2
+			assert.Equal(t, 2, len(engine.Suggest(conns, config)))
+		})
+
+		it("doesn't limit the addresses of connections without a destination IP", func() {
+			arp := connection("10.0.0.1", 0, "ARP")
+			arp.Destination = nil
+			suggestions := engine.Suggest([]engine.Connection{arp}, config)
+			assert.Equal(t, 1, len(suggestions))
+			assert.Nil(t, suggestions[0].IPs)
+			assert.Equal(t, "ignore ARP to any address", suggestions[0].Name)
+		})
+	})
+
+	when("SuggestionsWriter#Write", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "suggest")
+			assert.Nil(t, err)
+		})
+
+		it.After(func() {
+			assert.Nil(t, os.RemoveAll(tmpDir))
+		})
+
+		it("writes a policy which covers all the connections", func() {
+			path := filepath.Join(tmpDir, "policy.json")
+			assert.Nil(t, engine.SuggestionsWriter{}.Write(engine.Suggest(conns, config), path))
+
+			content, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Contains(t, string(content), `"coverage": {`)
+
+			policies, err := engine.PolicyReader{}.Read(path)
+			assert.Nil(t, err)
+			assert.Equal(t, 3, len(policies))
+			for _, conn := range conns {
+				eval := engine.Evaluate(policies, conn)
+				assert.Equal(t, engine.CleanVerdict, eval.Verdict)
+				assert.NotEmpty(t, eval.Matched)
+			}
+		})
+	})
+}
@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SuppressedVerdict is the verdict of the suspicious Connections which were suppressed, in the output of all Connections
+var SuppressedVerdict = "SUPPRESSED"
+
+// Suppression temporarily accepts the suspicious Connections matching its criteria (e.g. those of a pen test), without
+// editing the policy. It only applies until it expires.
+type Suppression struct {
+	ID     string
+	Reason string
+	Owner  string
+	// Expires is zero when the suppression's expiry is missing or improper, and such suppressions never apply
+	Expires time.Time
+	// Rules holds the IDs of the rules the Connections must have matched, and is nil when any rule will do
+	Rules []string
+	// Improper holds the criteria which couldn't be parsed (e.g. `source 10.0.0.5`, which isn't a CIDR). A
+	// Suppression with any of them never applies, rather than applying to more Connections than intended.
+	Improper []string
+	// sources and destinations match the addresses of the Connections, the same as the networks of a Policy, and criteria
+	// matches their destination ports and protocols
+	sources      Policy
+	destinations Policy
+	criteria     Policy
+}
+
+// SuppressionReader reads Suppressions from a valid `suppressions.json` file.
+// It intentionally mirrors the PolicyReader, and doesn't accept the path as an input to the struct creation.
+type SuppressionReader struct{}
+
+// This leaves the results from the json intentionally untyped, to make it more resilient to improper values.
+type suppressionJson struct {
+	ID           interface{}   `json:"id"`
+	Sources      []interface{} `json:"sources,omitempty"`
+	Destinations []interface{} `json:"destinations,omitempty"`
+	Ports        []interface{} `json:"ports,omitempty"`
+	Protocols    []interface{} `json:"protocols,omitempty"`
+	Rules        []interface{} `json:"rules,omitempty"`
+	Reason       interface{}   `json:"reason"`
+	Owner        interface{}   `json:"owner"`
+	ExpiresAt    interface{}   `json:"expires_at"`
+}
+
+// Read a `suppressions.json` file, and returns a Suppression slice. Suppressions with an improper expiry or criteria
+// are logged, and kept so that they can be reported by lint.
+func (r SuppressionReader) Read(path string) ([]Suppression, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read suppressions file")
+	}
+
+	var suppressionsJson []suppressionJson
+	if err = json.Unmarshal(content, &suppressionsJson); err != nil {
+		return nil, errors.Wrap(err, "failed to parse suppressions file")
+	}
+
+	var suppressions []Suppression
+	for _, sup := range suppressionsJson {
+		suppression := Suppression{
+			ID:           fmt.Sprintf("%v", sup.ID),
+			Reason:       stringOrEmpty(sup.Reason),
+			Owner:        stringOrEmpty(sup.Owner),
+			sources:      NewPolicy(policyJson{ID: sup.ID, Name: sup.ID, Networks: sup.Sources}),
+			destinations: NewPolicy(policyJson{ID: sup.ID, Name: sup.ID, Networks: sup.Destinations}),
+			criteria:     NewPolicy(policyJson{ID: sup.ID, Name: sup.ID, Ports: sup.Ports, Protocols: sup.Protocols}),
+		}
+		for _, rule := range sup.Rules {
+			suppression.Rules = append(suppression.Rules, fmt.Sprintf("%v", rule))
+		}
+		if suppression.Improper = improperCriteria(sup); suppression.Improper != nil {
+			log.Printf("Suppression '%s' has improper %s, and never applies \n", suppression.ID, strings.Join(suppression.Improper, ", "))
+		}
+
+		expires, err := time.Parse(time.RFC3339, fmt.Sprintf("%v", sup.ExpiresAt))
+		if err != nil {
+			log.Printf("Improper suppression expiry %v found \n", sup.ExpiresAt)
+		} else {
+			suppression.Expires = expires
+		}
+		suppressions = append(suppressions, suppression)
+	}
+	return suppressions, nil
+}
+
+// improperCriteria returns the sources, destinations, ports and protocols of a suppression which can't be parsed, as
+// NewPolicy would skip them, leaving the suppression to match any value of the criteria
+func improperCriteria(sup suppressionJson) []string {
+	var improper []string
+	for _, ips := range []struct {
+		name   string
+		values []interface{}
+	}{{"source", sup.Sources}, {"destination", sup.Destinations}} {
+		for _, ip := range ips.values {
+			if _, _, err := net.ParseCIDR(fmt.Sprintf("%v", ip)); err != nil {
+				improper = append(improper, fmt.Sprintf("%s %v", ips.name, ip))
+			}
+		}
+	}
+	for _, portRange := range sup.Ports {
+		portMap, ok := portRange.(map[string]interface{})
+		_, startErr := strconv.Atoi(fmt.Sprintf("%v", portMap["start"]))
+		_, endErr := strconv.Atoi(fmt.Sprintf("%v", portMap["end"]))
+		if !ok || startErr != nil || endErr != nil {
+			improper = append(improper, fmt.Sprintf("port %v", portRange))
+		}
+	}
+	for _, protocol := range sup.Protocols {
+		if name, ok := protocol.(string); !ok || name == "" {
+			improper = append(improper, fmt.Sprintf("protocol %v", protocol))
+		}
+	}
+	return improper
+}
+
+// stringOrEmpty stringifies an optional json value, which is empty rather than `<nil>` when it is missing
+func stringOrEmpty(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Expired returns true if the Suppression no longer applies at the given time, or never applied
+func (s Suppression) Expired(now time.Time) bool {
+	return s.Expires.IsZero() || !now.Before(s.Expires)
+}
+
+// Matches returns true if the Connection matches each of the Suppression's criteria which is set (its sources,
+// destinations, destination ports and protocols), and matched one of its rules (if set). A Suppression with improper
+// criteria matches nothing.
+func (s Suppression) Matches(conn Connection, matched []Policy) bool {
+	if s.Improper != nil {
+		return false
+	}
+	if s.Rules != nil {
+		found := false
+		for _, policy := range matched {
+			if containsString(s.Rules, policy.ID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !s.sources.matchesIP(conn.Source) || !s.destinations.matchesIP(conn.Destination) {
+		return false
+	}
+	matchPort := s.criteria.Ports == nil
+	for _, portRange := range s.criteria.Ports {
+		if conn.DestinationPort >= portRange.Start && conn.DestinationPort <= portRange.End {
+			matchPort = true
+			break
+		}
+	}
+	_, matchProtocol := s.criteria.ProtocolMap[conn.Protocol]
+	return matchPort && (s.criteria.ProtocolMap == nil || matchProtocol)
+}
+
+// Suppressor moves the suspicious Connections matching an active Suppression to a suppressed bucket, and counts them
+type Suppressor struct {
+	active []Suppression
+	counts map[string]int
+}
+
+// NewSuppressor returns a Suppressor with the Suppressions which haven't expired at the given time. Expired
+// Suppressions are logged, so they can be cleaned up.
+func NewSuppressor(suppressions []Suppression, now time.Time) *Suppressor {
+	s := &Suppressor{counts: map[string]int{}}
+	for _, suppression := range suppressions {
+		if suppression.Expires.IsZero() {
+			log.Printf("Suppression '%s' has no valid expiry, and doesn't apply \n", suppression.ID)
+			continue
+		}
+		if suppression.Improper != nil {
+			log.Printf("Suppression '%s' has improper criteria, and doesn't apply \n", suppression.ID)
+			continue
+		}
+		if suppression.Expired(now) {
+			log.Printf("Suppression '%s' has expired, and no longer applies \n", suppression.ID)
+			continue
+		}
+		s.active = append(s.active, suppression)
+	}
+	return s
+}
+
+// Suppress returns the ID of the first active Suppression the Connection matches, given the Policies it matched, and
+// false if it isn't suppressed
+func (s *Suppressor) Suppress(conn Connection, matched []Policy) (string, bool) {
+	for _, suppression := range s.active {
+		if suppression.Matches(conn, matched) {
+			s.counts[suppression.ID]++
+			return suppression.ID, true
+		}
+	}
+	return "", false
+}
+
+// Active returns the Suppressions which apply, in file order
+func (s *Suppressor) Active() []Suppression {
+	return s.active
+}
+
+// Count returns the amount of Connections suppressed by the Suppression
+func (s *Suppressor) Count(id string) int {
+	return s.counts[id]
+}
@@ -0,0 +1,109 @@
+package engine_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestSuppressions(t *testing.T) {
+	spec.Run(t, "Suppressions", testSuppressions, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSuppressions(t *testing.T, when spec.G, it spec.S) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	https := engine.Policy{ID: "inspect-https", Name: "inspect HTTPS", Verdict: engine.InspectVerdict}
+
+	connection := func(source, destination string, port int, protocol string) engine.Connection {
+		return engine.Connection{
+			Timestamp:       "1000.000000",
+			Source:          net.ParseIP(source),
+			SourcePort:      40000,
+			Destination:     net.ParseIP(destination),
+			DestinationPort: port,
+			Protocol:        protocol,
+		}
+	}
+
+	var suppressions []engine.Suppression
+	it.Before(func() {
+		var err error
+		suppressions, err = engine.SuppressionReader{}.Read(filepath.Join("testdata", "suppressions.json"))
+		assert.Nil(t, err)
+	})
+
+	when("SuppressionReader#Read", func() {
+		it("reads the suppressions, keeping those with an improper expiry", func() {
+			assert.Equal(t, 4, len(suppressions))
+			assert.Equal(t, "pentest", suppressions[0].ID)
+			assert.Equal(t, "Q3 pen test", suppressions[0].Reason)
+			assert.Equal(t, "secops", suppressions[0].Owner)
+			assert.Equal(t, time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), suppressions[0].Expires)
+			assert.Equal(t, []string{"inspect-https"}, suppressions[1].Rules)
+			assert.True(t, suppressions[2].Expires.IsZero())
+			assert.Equal(t, "", suppressions[2].Reason)
+		})
+
+		it("keeps the criteria which can't be parsed", func() {
+			assert.Nil(t, suppressions[0].Improper)
+			assert.Equal(t, []string{"source 10.0.0.5"}, suppressions[3].Improper)
+		})
+
+		it("returns an error for a missing file", func() {
+			_, err := engine.SuppressionReader{}.Read(filepath.Join("testdata", "missing.json"))
+			assert.Error(t, err)
+		})
+	})
+
+	when("#Expired", func() {
+		it("is expired from its expiry, or when it has none", func() {
+			assert.False(t, suppressions[0].Expired(now))
+			assert.True(t, suppressions[1].Expired(now))
+			assert.True(t, suppressions[1].Expired(suppressions[1].Expires))
+			assert.False(t, suppressions[1].Expired(suppressions[1].Expires.Add(-time.Second)))
+			assert.True(t, suppressions[2].Expired(now))
+		})
+	})
+
+	when("#Matches", func() {
+		it("matches the criteria, and one of the rules when they are set", func() {
+			assert.True(t, suppressions[0].Matches(connection("10.0.0.1", "10.0.0.2", 22, "TCP"), nil))
+			assert.False(t, suppressions[0].Matches(connection("10.0.0.1", "10.0.0.2", 443, "TCP"), nil))
+			assert.False(t, suppressions[0].Matches(connection("10.0.0.2", "10.0.0.1", 22, "TCP"), nil))
+
+			conn := connection("192.168.0.1", "10.0.0.3", 443, "TCP")
+			assert.True(t, suppressions[1].Matches(conn, []engine.Policy{https}))
+			assert.False(t, suppressions[1].Matches(conn, nil))
+		})
+
+		it("never matches with improper criteria, rather than matching any address", func() {
+			assert.False(t, suppressions[3].Matches(connection("10.0.0.5", "10.0.0.2", 22, "TCP"), nil))
+			assert.False(t, suppressions[3].Matches(connection("10.0.0.9", "10.0.0.2", 22, "TCP"), nil))
+		})
+	})
+
+	when("Suppressor", func() {
+		it("suppresses with the active suppressions, and counts them", func() {
+			suppressor := engine.NewSuppressor(suppressions, now)
+			assert.Equal(t, 1, len(suppressor.Active()))
+
+			id, ok := suppressor.Suppress(connection("10.0.0.1", "10.0.0.2", 22, "TCP"), nil)
+			assert.True(t, ok)
+			assert.Equal(t, "pentest", id)
+			_, ok = suppressor.Suppress(connection("192.168.0.1", "10.0.0.3", 443, "TCP"), []engine.Policy{https})
+			assert.False(t, ok)
+			_, ok = suppressor.Suppress(connection("10.0.0.9", "10.0.0.3", 53, "UDP"), nil)
+			assert.False(t, ok)
+
+			assert.Equal(t, 1, suppressor.Count("pentest"))
+			assert.Equal(t, 0, suppressor.Count("migration"))
+		})
+	})
+}
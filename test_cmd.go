@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewTestCommand creates a CLI which runs policy unit-test fixtures against a policy file.
+// When no fixture files are given, it looks for one next to the policy (see DefaultFixturePath).
+func NewTestCommand() *cobra.Command {
+	testPolicyPath := policyPath
+	testLifecycleClock := lifecycleClock
+	cmd := &cobra.Command{
+		Use:   "test [fixture files...]",
+		Short: "Run policy unit-test fixtures against a policy file",
+		// A failing fixture is an expected outcome, and shouldn't be followed by the usage text
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fixturePaths := args
+			if len(fixturePaths) == 0 {
+				fixturePaths = []string{DefaultFixturePath(testPolicyPath)}
+			}
+			return runPolicyTests(cmd.OutOrStdout(), testPolicyPath, testLifecycleClock, fixturePaths)
+		},
+	}
+
+	cmd.Flags().StringVarP(&testPolicyPath, "policy", "p", testPolicyPath, "Path to a valid JSON policy file")
+	cmd.Flags().StringVar(&testLifecycleClock, "lifecycle-clock", testLifecycleClock, "Clock the valid_from and expires_at of rules are checked against: wall (the current time) or connection (the timestamp of each fixture)")
+
+	return cmd
+}
+
+func runPolicyTests(out io.Writer, policyPath string, clock string, fixturePaths []string) error {
+	policyReader := PolicyReader{}
+	policies, err := policyReader.Read(policyPath)
+	if err != nil {
+		return errors.Wrapf(err, "parsing policy file %s", policyPath)
+	}
+	// The fixtures are evaluated with the rules which apply, the same as the connections of a run
+	policies, skipped, err := ApplyLifecycle(policies, clock, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, skip := range skipped {
+		fmt.Fprintf(out, "Rule '%s' was skipped, as it %s\n", skip.Policy.Name, skip.Reason)
+	}
+
+	fixtureReader := FixtureReader{}
+	passed, failed := 0, 0
+	for _, path := range fixturePaths {
+		fixtures, err := fixtureReader.Read(path)
+		if err != nil {
+			return errors.Wrapf(err, "parsing fixture file %s", path)
+		}
+
+		for _, result := range RunFixtures(policies, fixtures) {
+			if result.Passed() {
+				passed++
+				fmt.Fprintf(out, "PASS %s\n", result.Fixture.Name)
+				continue
+			}
+
+			failed++
+			fmt.Fprintf(out, "FAIL %s\n", result.Fixture.Name)
+			for _, failure := range result.Failures {
+				fmt.Fprintf(out, "    %s\n", failure)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return errors.Errorf("%d fixture(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Threshold turns a Policy into a stateful rule, which alerts when more than Count Connections matching the Policy's
+// criteria are seen for the same group within Window.
+type Threshold struct {
+	Count   int
+	Window  time.Duration
+	GroupBy string
+}
+
+var (
+	GroupBySource      = "source"
+	GroupByDestination = "destination"
+	GroupByPair        = "pair"
+)
+
+// newThreshold parses the untyped `threshold` object of a policy. The window may either be a number of seconds, or a
+// Go duration string (e.g. `1m`), and the group defaults to the source.
+func newThreshold(raw interface{}) (*Threshold, error) {
+	thresholdMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("threshold should be an object")
+	}
+
+	count, err := strconv.Atoi(fmt.Sprintf("%v", thresholdMap["count"]))
+	if err != nil || count <= 0 {
+		return nil, errors.Errorf("invalid count %v", thresholdMap["count"])
+	}
+
+	window, err := parseWindow(thresholdMap["window"])
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy := GroupBySource
+	if value, ok := thresholdMap["group_by"]; ok {
+		groupBy = fmt.Sprintf("%v", value)
+	}
+	if groupBy != GroupBySource && groupBy != GroupByDestination && groupBy != GroupByPair {
+		return nil, errors.Errorf("invalid group_by %s, expected one of %s, %s or %s", groupBy, GroupBySource, GroupByDestination, GroupByPair)
+	}
+
+	return &Threshold{Count: count, Window: window, GroupBy: groupBy}, nil
+}
+
+// parseWindow parses either a number of seconds, or a Go duration string
+func parseWindow(raw interface{}) (time.Duration, error) {
+	var window time.Duration
+	switch value := raw.(type) {
+	case float64:
+		window = time.Duration(value * float64(time.Second))
+	case string:
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			return 0, errors.Wrapf(err, "invalid window %s", value)
+		}
+	default:
+		return 0, errors.Errorf("invalid window %v", raw)
+	}
+
+	if window <= 0 {
+		return 0, errors.Errorf("invalid window %v", raw)
+	}
+	return window, nil
+}
+
+// key returns the group a Connection is counted under
+func (t Threshold) key(conn Connection) string {
+	switch t.GroupBy {
+	case GroupByDestination:
+		return conn.Destination.String()
+	case GroupByPair:
+		return conn.Source.String() + "->" + conn.Destination.String()
+	default:
+		return conn.Source.String()
+	}
+}
+
+// thresholdWindow holds the matching Connections of a single group, which are still within the rule's window.
+// It never holds more than Count+1 Connections, as that is enough to trigger an alert.
+type thresholdWindow struct {
+	conns []Connection
+	times []time.Time
+}
+
+func (w *thresholdWindow) last() time.Time {
+	return w.times[len(w.times)-1]
+}
+
+// thresholdStore is the windowed state store for the threshold rules of a policy, which is driven by the timestamps
+// of the Connections, rather than wall-clock time, so that a historical file is analyzed the same as a live one.
+type thresholdStore struct {
+	policies []Policy
+	groups   []map[string]windowGroup
+}
+
+// newThresholdStore returns a thresholdStore for the threshold rules in the Policy slice, or nil if there aren't any
+func newThresholdStore(policies []Policy) *thresholdStore {
+	store := &thresholdStore{}
+	for _, policy := range policies {
+		if policy.Threshold != nil {
+			store.policies = append(store.policies, policy)
+			store.groups = append(store.groups, map[string]windowGroup{})
+		}
+	}
+
+	if len(store.policies) == 0 {
+		return nil
+	}
+	return store
+}
+
+// Observe adds a Connection to the window of each threshold rule it matches, and returns an Alert for every rule whose
+// threshold was crossed. Connections without a valid timestamp can't be placed in a window, and are ignored.
+func (s *thresholdStore) Observe(conn Connection) []Alert {
+	ts, err := conn.Time()
+	if err != nil {
+		return nil
+	}
+
+	var alerts []Alert
+	for i, policy := range s.policies {
+		if !policy.Matches(conn) {
+			continue
+		}
+
+		threshold := policy.Threshold
+		groups := s.groups[i]
+		key := threshold.key(conn)
+		group, ok := groups[key]
+		if !ok {
+			if len(groups) >= maxDetectorGroups {
+				evictGroups(groups, ts.Add(-threshold.Window))
+			}
+			group = &thresholdWindow{}
+			groups[key] = group
+		}
+		window := group.(*thresholdWindow)
+
+		// Drops the Connections which have fallen out of the window
+		start := ts.Add(-threshold.Window)
+		drop := 0
+		for drop < len(window.times) && window.times[drop].Before(start) {
+			drop++
+		}
+		window.conns = append(window.conns[drop:], conn)
+		window.times = append(window.times[drop:], ts)
+
+		if len(window.conns) > threshold.Count {
+			alerts = append(alerts, Alert{
+				Type:        ThresholdAlert,
+				RuleID:      policy.ID,
+				RuleName:    policy.Name,
+				Key:         fmt.Sprintf("%s %s", threshold.GroupBy, key),
+				Count:       len(window.conns),
+				FirstSeen:   window.conns[0].Timestamp,
+				LastSeen:    conn.Timestamp,
+				Connections: window.conns,
+			})
+			// Starts the group over, so a single burst raises a single alert rather than one per Connection
+			delete(groups, key)
+		}
+	}
+
+	return alerts
+}
+
+// Flush returns no Alerts, as threshold rules alert as soon as their threshold is crossed
+func (s *thresholdStore) Flush() []Alert {
+	return nil
+}
@@ -0,0 +1,136 @@
+package engine_test
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestThreshold(t *testing.T) {
+	spec.Run(t, "Threshold", testThreshold, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testThreshold(t *testing.T, when spec.G, it spec.S) {
+	var sshPolicy engine.Policy
+
+	it.Before(func() {
+		sshPolicy = engine.Policy{
+			ID:      "ssh-brute-force",
+			Name:    "SSH brute force",
+			Ports:   []engine.Port{{Start: 22, End: 22}},
+			Verdict: engine.AlertVerdict,
+			Threshold: &engine.Threshold{
+				Count:   3,
+				Window:  time.Minute,
+				GroupBy: engine.GroupBySource,
+			},
+		}
+	})
+
+	sshConnection := func(source string, timestamp float64) engine.Connection {
+		return engine.Connection{
+			Timestamp:       fmt.Sprintf("%f", timestamp),
+			Source:          net.ParseIP(source),
+			SourcePort:      40000,
+			Destination:     net.ParseIP("192.128.0.20"),
+			DestinationPort: 22,
+			Protocol:        "TCP",
+		}
+	}
+
+	when("policy file has threshold rules", func() {
+		it("parses the valid thresholds", func() {
+			policies, err := engine.PolicyReader{}.Read(filepath.Join(testdataPath, "threshold_policy.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, 3, len(policies))
+			assert.Equal(t, &engine.Threshold{Count: 3, Window: time.Minute, GroupBy: engine.GroupBySource}, policies[0].Threshold)
+			assert.Equal(t, &engine.Threshold{Count: 4, Window: 10 * time.Second, GroupBy: engine.GroupByPair}, policies[1].Threshold)
+			assert.Nil(t, policies[2].Threshold)
+			assert.NotNil(t, policies[2].Improper)
+		})
+
+		it("never matches a rule with an improper threshold, rather than matching each connection", func() {
+			policies, err := engine.PolicyReader{}.Read(filepath.Join(testdataPath, "threshold_policy.json"))
+			assert.Nil(t, err)
+			web := engine.Connection{Timestamp: "1599665118", Source: net.ParseIP("192.0.0.2"), SourcePort: 40000, Destination: net.ParseIP("192.128.0.20"), DestinationPort: 80, Protocol: "TCP"}
+
+			results := engine.DetectAttacks(policies[2:], []engine.Connection{web, sshConnection("192.0.0.2", 1599665119)})
+			assert.Empty(t, results.RuleCount)
+			assert.Equal(t, 2, results.NoMatchCount)
+			assert.Empty(t, results.Suspicious)
+		})
+	})
+
+	when("more connections than the threshold are within the window", func() {
+		it("raises a single alert with the contributing connections", func() {
+			conns := []engine.Connection{
+				sshConnection("192.0.0.3", 1000),
+				sshConnection("192.0.0.3", 1010),
+				sshConnection("192.0.0.4", 1015),
+				sshConnection("192.0.0.3", 1020),
+				sshConnection("192.0.0.3", 1030),
+				sshConnection("192.0.0.3", 1031),
+			}
+
+			result := engine.DetectAttacks([]engine.Policy{sshPolicy}, conns)
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, engine.Alert{
+				Type:        engine.ThresholdAlert,
+				RuleID:      "ssh-brute-force",
+				RuleName:    "SSH brute force",
+				Key:         "source 192.0.0.3",
+				Count:       4,
+				FirstSeen:   conns[0].Timestamp,
+				LastSeen:    conns[4].Timestamp,
+				Connections: []engine.Connection{conns[0], conns[1], conns[3], conns[4]},
+			}, result.Alerts[0])
+		})
+
+		it("doesn't affect the verdict of the connections", func() {
+			conns := []engine.Connection{
+				sshConnection("192.0.0.3", 1000),
+				sshConnection("192.0.0.3", 1001),
+				sshConnection("192.0.0.3", 1002),
+				sshConnection("192.0.0.3", 1003),
+			}
+
+			result := engine.DetectAttacks([]engine.Policy{sshPolicy}, conns)
+			assert.Equal(t, 1, len(result.Alerts))
+			assert.Equal(t, 4, result.CleanCount)
+			assert.Equal(t, 4, result.NoMatchCount)
+			assert.Nil(t, result.Suspicious)
+		})
+	})
+
+	when("the connections are spread beyond the window", func() {
+		it("doesn't raise an alert", func() {
+			conns := []engine.Connection{
+				sshConnection("192.0.0.3", 1000),
+				sshConnection("192.0.0.3", 1030),
+				sshConnection("192.0.0.3", 1061),
+				sshConnection("192.0.0.3", 1100),
+				sshConnection("192.0.0.3", 1200),
+			}
+
+			result := engine.DetectAttacks([]engine.Policy{sshPolicy}, conns)
+			assert.Nil(t, result.Alerts)
+		})
+	})
+
+	when("the connections don't have a valid timestamp", func() {
+		it("ignores them", func() {
+			conn := sshConnection("192.0.0.3", 0)
+			conn.Timestamp = "not-a-timestamp"
+			result := engine.DetectAttacks([]engine.Policy{sshPolicy}, []engine.Connection{conn, conn, conn, conn})
+			assert.Nil(t, result.Alerts)
+		})
+	})
+}
@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// zeekFields maps the fields of a Zeek `conn.log` to the fields of a Connection, which are named as in the default
+// header. The rest of the log's fields are kept in the Connection's Fields.
+var zeekFields = map[string]string{
+	"ts":        "timestamp",
+	"id.orig_h": "source",
+	"id.orig_p": "source_port",
+	"id.resp_h": "destination",
+	"id.resp_p": "destination_port",
+	"proto":     "protocol",
+}
+
+// zeekJSONKey is a field only Zeek logs have, which tells a Zeek JSON log apart from other JSON Lines files
+var zeekJSONKey = "id.orig_h"
+
+// The defaults of the header of a Zeek TSV log, which are used until its header sets them
+var (
+	zeekSeparator    = "\t"
+	zeekSetSeparator = ","
+	zeekUnsetField   = "-"
+	zeekEmptyField   = "(empty)"
+)
+
+// isZeekTSV peeks at the beginning of a stream, and returns true if it begins with the header of a Zeek TSV log
+func isZeekTSV(r *bufio.Reader) bool {
+	peeked, _ := r.Peek(len("#separator"))
+	return bytes.HasPrefix(peeked, []byte("#separator")) || bytes.HasPrefix(peeked, []byte("#fields"))
+}
+
+// readZeekTSV reads the Connections of a Zeek TSV `conn.log`, whose fields are named by its `#fields` header. The
+// separators and the unset and empty markers are read from the header, and unset fields are left out. Logs which were
+// concatenated (each with its own header) are read as well.
+func (c ConnectionsReadWriter) readZeekTSV(r io.Reader) ([]Connection, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+
+	separator, setSeparator, unset, empty := zeekSeparator, zeekSetSeparator, zeekUnsetField, zeekEmptyField
+	var fields []string
+	var connections []Connection
+	for line := 1; scanner.Scan(); line++ {
+		content := strings.TrimSuffix(scanner.Text(), "\r")
+		if content == "" {
+			continue
+		}
+
+		if strings.HasPrefix(content, "#") {
+			// The separator is the only directive separated by a space, as the separator itself isn't known yet
+			if strings.HasPrefix(content, "#separator ") {
+				value, err := unescapeZeek(strings.TrimPrefix(content, "#separator "))
+				if err != nil {
+					return connections, errors.Wrapf(err, "line %d has an invalid #separator", line)
+				}
+				separator = value
+				continue
+			}
+
+			directive := strings.Split(content, separator)
+			switch directive[0] {
+			case "#set_separator":
+				setSeparator = strings.Join(directive[1:], separator)
+			case "#unset_field":
+				unset = strings.Join(directive[1:], separator)
+			case "#empty_field":
+				empty = strings.Join(directive[1:], separator)
+			case "#fields":
+				fields = directive[1:]
+			}
+			continue
+		}
+
+		if fields == nil {
+			return connections, errors.Errorf("line %d comes before the #fields header", line)
+		}
+		values := strings.Split(content, separator)
+		if len(values) != len(fields) {
+			return connections, errors.Errorf("line %d has %d field(s), but the #fields header has %d", line, len(values), len(fields))
+		}
+
+		named := map[string]string{}
+		for i, value := range values {
+			switch value {
+			case unset:
+				continue
+			case empty:
+				value = ""
+			}
+			// Sets and vectors are written with the set separator, which is a comma unless the header sets another
+			named[fields[i]] = strings.Replace(value, setSeparator, ",", -1)
+		}
+
+		conn, err := zeekConnection(named)
+		if err != nil {
+			return connections, errors.Wrapf(err, "line %d", line)
+		}
+		conn.Origin = c.origin(line)
+		connections = append(connections, conn)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return connections, errors.Wrap(err, "failed to read Zeek connection file")
+	}
+	return connections, nil
+}
+
+// unescapeZeek unescapes the `\xHH` escapes of a Zeek header value, e.g. `\x09` for a tab
+func unescapeZeek(value string) (string, error) {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if !strings.HasPrefix(value[i:], `\x`) {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		if i+4 > len(value) {
+			return "", errors.Errorf("invalid escape in %q", value)
+		}
+		b, err := strconv.ParseUint(value[i+2:i+4], 16, 8)
+		if err != nil {
+			return "", errors.Errorf("invalid escape in %q", value)
+		}
+		unescaped.WriteByte(byte(b))
+		i += 3
+	}
+	return unescaped.String(), nil
+}
+
+// zeekJSONConnection reads a Connection from the object of a Zeek JSON log, whose null fields are unset, and whose sets
+// and vectors are joined with commas, the same as in a TSV log
+func zeekJSONConnection(object map[string]interface{}) (Connection, error) {
+	named := map[string]string{}
+	for key, value := range object {
+		switch typed := value.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			var values []string
+			for _, v := range typed {
+				values = append(values, stringOrEmpty(v))
+			}
+			named[key] = strings.Join(values, ",")
+		default:
+			named[key] = stringOrEmpty(value)
+		}
+	}
+	return zeekConnection(named)
+}
+
+// zeekConnection creates a Connection from the named fields of a Zeek log. Zeek's lower case protocols are upper cased
+// (e.g. `tcp` to `TCP`) to match the rules, and an ISO 8601 timestamp is converted to an epoch one.
+func zeekConnection(named map[string]string) (Connection, error) {
+	row, err := mappedRow(named, zeekFields)
+	if err != nil {
+		return Connection{}, err
+	}
+
+	timestamp := row[indexOf(headerRow, "timestamp")]
+	if ts, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		row[indexOf(headerRow, "timestamp")] = epochTimestamp(ts)
+	}
+	row[indexOf(headerRow, "protocol")] = strings.ToUpper(row[indexOf(headerRow, "protocol")])
+
+	conn := NewConnection(row)
+	conn.Fields = unmappedFields(named, zeekFields)
+	return conn, nil
+}
@@ -0,0 +1,100 @@
+package engine_test
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dfreilich/guardicore-policy-engine"
+)
+
+func TestZeek(t *testing.T) {
+	spec.Run(t, "Zeek", testZeek, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testZeek(t *testing.T, when spec.G, it spec.S) {
+	var connectionRW = engine.ConnectionsReadWriter{}
+	expected := []engine.Connection{
+		{
+			Timestamp: "1599665118.593452",
+			Source: net.ParseIP("192.0.0.2"),
+			SourcePort: 5000,
+			Destination: net.ParseIP("192.128.0.32"),
+			DestinationPort: 22,
+			Protocol: "TCP",
+			Fields: map[string]string{
+				"uid": "CHhAvVGS1DHFjwGM9",
+				"service": "ssh",
+				"duration": "1.204331",
+				"orig_bytes": "1024",
+				"resp_bytes": "2048",
+				"conn_state": "SF",
+			},
+		},
+		{
+			Timestamp: "1599665119.104211",
+			Source: net.ParseIP("192.0.0.2"),
+			Destination: net.ParseIP("192.128.0.33"),
+			Protocol: "ICMP",
+			Fields: map[string]string{
+				"uid": "C4J4Th3PJpwUYZZ6gc",
+				"conn_state": "OTH",
+				"tunnel_parents": "Ck1,Ck2",
+			},
+		},
+	}
+
+	when("TSV conn.log", func() {
+		it("maps the Zeek fields, keeping the extra ones, and leaving out unset ones", func() {
+			connections, err := connectionRW.Read(filepath.Join(testdataPath, "zeek_conn.log"))
+			assert.Nil(t, err)
+			// The TSV log sets an empty set of tunnel parents, which the JSON log leaves out
+			first := expected[0]
+			first.Fields = map[string]string{"tunnel_parents": ""}
+			for name, value := range expected[0].Fields {
+				first.Fields[name] = value
+			}
+			assert.Equal(t, []engine.Connection{first, expected[1]}, connections)
+		})
+
+		it("returns an error for a row before the #fields header", func() {
+			_, err := connectionRW.ReadStream(bytes.NewBufferString("#separator \\x09\n1599665118.593452\t192.0.0.2\n"))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 2 comes before the #fields header")
+		})
+
+		it("returns an error for a row with a different amount of fields", func() {
+			_, err := connectionRW.ReadStream(bytes.NewBufferString("#fields\tts\tid.orig_h\tid.resp_h\tproto\n1599665118.593452\t192.0.0.2\n"))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 2 has 2 field(s), but the #fields header has 4")
+		})
+
+		it("returns an error for a row missing a required field", func() {
+			_, err := connectionRW.ReadStream(bytes.NewBufferString("#fields\tts\tid.orig_h\tid.resp_h\tproto\n1599665118.593452\t-\t192.0.0.3\ttcp\n"))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "line 2: is missing the id.orig_h field(s)")
+		})
+	})
+
+	when("JSON conn.log", func() {
+		it("maps the Zeek fields, converting ISO 8601 timestamps and joining sets", func() {
+			connections, err := connectionRW.Read(filepath.Join(testdataPath, "zeek_conn.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, connections)
+		})
+	})
+
+	when("matched by a policy", func() {
+		it("matches the extra fields", func() {
+			policies, err := engine.PolicyReader{}.Read(filepath.Join(testdataPath, "zeek_policy.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, engine.SuspiciousVerdict, engine.Evaluate(policies[:1], expected[0]).Verdict)
+			assert.Equal(t, engine.CleanVerdict, engine.Evaluate(policies[:1], expected[1]).Verdict)
+		})
+	})
+}